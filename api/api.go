@@ -38,6 +38,19 @@ var ProtoPackages = []string{
 	"x40.dev.auth",
 }
 
+// MethodAdmin* are the names of the administrative methods. They're served over HTTP rather than gRPC, but are named
+// in the same way as the gRPC methods so that they're authorized in the same way.
+const (
+	MethodAdminBackup = "/x40.admin/Backup"
+)
+
+// AdminPermissions returns a paired list of method + scope definitions for the administrative methods.
+func AdminPermissions() map[string]string {
+	return map[string]string{
+		MethodAdminBackup: "api.x40.link/scopes/x40.admin.Backup",
+	}
+}
+
 // ReflectionPermissions are permissions from the reflection API.
 //
 // See
@@ -94,12 +107,16 @@ func X40PermissionsList() []string {
 	return m
 }
 
-// NewGRPCMux generates a valid GRPC server with all GRPC routes configured.
-func NewGRPCMux(storer storage.Storer, opts ...grpc.ServerOption) *grpc.Server {
+// NewGRPCMux generates a valid GRPC server with all GRPC routes configured. Links are created (and looked up) with the
+// trailing slash policy supplied; see storage.Normalize. On the folded hosts, links that differ from an existing link
+// only by case are rejected; see storage.FoldedHosts.
+func NewGRPCMux(storer storage.Storer, slash storage.SlashPolicy, folded storage.FoldedHosts, opts ...grpc.ServerOption) *grpc.Server {
 	m := grpc.NewServer(opts...)
 
 	gendev.RegisterManageURLsServer(m, &dev.URL{
-		Storer: storer,
+		Storer:        storer,
+		TrailingSlash: slash,
+		FoldedHosts:   folded,
 		Enricher: (&dev.URLEnricher{
 			Domain: "x40.link",
 			Path:   uid.New(uid.TypeRandom),
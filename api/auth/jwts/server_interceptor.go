@@ -218,7 +218,7 @@ func (o *ServerInterceptor) ValidateCtx(
 
 	_, err := o.par.ParseWithClaims(strTok, claims, o.kf)
 	if err != nil {
-		return ctx, fmt.Errorf("%w: %s", auth.ErrFailedToAuthenticate, err)
+		return ctx, fmt.Errorf("%w: %w", auth.ErrFailedToAuthenticate, err)
 	}
 
 	ctx = context.WithValue(ctx, storage.CtxKeyAgent, "sub:"+claims.Subject)
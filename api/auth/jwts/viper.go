@@ -15,6 +15,7 @@ func ServerInterceptorOptsFromViper() ([]ServerInterceptorOptionFunc, error) {
 			WithParser(jwt.NewParser(PublicJWTClaims...)),
 			WithAddedPermissions(api.X40Permissions()),
 			WithAddedPermissions(api.ReflectionPermissions()),
+			WithAddedPermissions(api.AdminPermissions()),
 		}, nil
 	}
 
@@ -46,7 +47,7 @@ func ServerInterceptorOptsFromViper() ([]ServerInterceptorOptionFunc, error) {
 	}
 
 	if len(opts) > 0 {
-		return opts, nil
+		return append(opts, WithAddedPermissions(api.AdminPermissions())), nil
 	}
 
 	return nil, cfg.ErrMissingOptions
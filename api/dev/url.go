@@ -6,6 +6,7 @@ import (
 	"errors"
 	"log"
 	"net/url"
+	"time"
 
 	"github.com/andrewhowdencom/x40.link/api/gen/dev"
 	"github.com/andrewhowdencom/x40.link/storage"
@@ -44,6 +45,14 @@ func (u *URLEnricher) Enrich(from *url.URL, to *url.URL) error {
 type URL struct {
 	Storer storage.Storer
 
+	// TrailingSlash is what is done with the trailing slash of URLs as they are created or looked up. By default, it
+	// is kept. See storage.Normalize.
+	TrailingSlash storage.SlashPolicy
+
+	// FoldedHosts are the hosts on which links are matched without regard to case. Links on them are looked up without
+	// regard to case, and are not able to be created where they differ only by case from an existing link.
+	FoldedHosts storage.FoldedHosts
+
 	Enricher func(from *url.URL, to *url.URL) error
 
 	dev.UnimplementedManageURLsServer
@@ -56,7 +65,7 @@ func (u URL) Get(ctx context.Context, req *dev.GetRequest) (*dev.Response, error
 		return nil, status.Errorf(codes.InvalidArgument, "url parse failure: %s", err)
 	}
 
-	response, err := u.Storer.Get(ctx, url)
+	response, err := u.get(ctx, u.normalize(url))
 	if errors.Is(err, storage.ErrUnauthorized) {
 		return nil, status.Error(codes.PermissionDenied, "you are not the owner of this record")
 	} else if errors.Is(err, storage.ErrNotFound) {
@@ -72,7 +81,13 @@ func (u URL) Get(ctx context.Context, req *dev.GetRequest) (*dev.Response, error
 	}, nil
 }
 
-// New generates the "from" URL on the fly
+// maxGenerateAttempts is the number of times New will generate a path for a URL before giving up, in the case
+// the generated paths collide with URLs that already exist.
+const maxGenerateAttempts = 5
+
+// New generates the "from" URL on the fly, and creates the link at it. Where the caller chose the URL and there is
+// already a link at it, the link is updated if the caller owns it (see storage.Authenticator); otherwise, New fails
+// with codes.AlreadyExists. A generated URL is only ever created.
 func (u URL) New(ctx context.Context, req *dev.NewRequest) (*dev.Response, error) {
 	to, err := url.Parse(req.SendTo)
 	if err != nil {
@@ -85,15 +100,86 @@ func (u URL) New(ctx context.Context, req *dev.NewRequest) (*dev.Response, error
 		from.Path = req.On.Path
 	}
 
-	// Add information if it is not there.
-	if err := u.Enricher(from, to); err != nil {
-		log.Println(err)
-		return nil, status.Error(codes.Internal, "unable to add missing information")
+	// Only paths that are generated can be retried. If the user has chosen the path, a collision means the path is
+	// taken.
+	generated := from.Path == ""
+
+	// A path ending in a wildcard is shorthand for a prefix; the wildcard is not part of the stored URL.
+	from, prefix := storage.TrimWildcard(from)
+	to, wildcard := storage.TrimWildcard(to)
+
+	if wildcard && !(prefix || req.Prefix) {
+		return nil, status.Error(codes.InvalidArgument, "send_to has a wildcard, but the url is not a prefix")
 	}
 
-	err = u.Storer.Put(ctx, from, to)
+	l := &storage.Link{
+		From:   from,
+		To:     to,
+		Status: int(req.StatusCode),
+		Prefix: prefix || req.Prefix,
+		Query:  storage.QueryPolicy(req.Query),
+	}
 
-	if errors.Is(err, storage.ErrUnauthorized) {
+	if !storage.ValidStatus(l.Status) {
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported redirect status code: %d", l.Status)
+	}
+
+	if !storage.ValidQuery(l.Query) {
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported query policy: %q", l.Query)
+	}
+
+	if err := storage.ValidateTemplate(l); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if req.ExpiresAt != nil {
+		if err := req.ExpiresAt.CheckValid(); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid expiry: %s", err)
+		}
+
+		l.Expires = req.ExpiresAt.AsTime()
+		if !l.Expires.After(time.Now()) {
+			return nil, status.Error(codes.InvalidArgument, "expiry must be in the future")
+		}
+	}
+
+	// The path is chosen even where it is empty once the wildcard is trimmed (as with a prefix at the root of the host,
+	// "/*"), so it is kept rather than generated.
+	path := from.Path
+
+	for attempt := 1; ; attempt++ {
+		// Add information if it is not there.
+		if err := u.Enricher(from, to); err != nil {
+			log.Println(err)
+			return nil, status.Error(codes.Internal, "unable to add missing information")
+		}
+
+		if !generated {
+			from.Path = path
+		}
+
+		l.From = u.normalize(from)
+
+		err = storage.Create(ctx, u.Storer, u.FoldedHosts, l)
+		if !errors.Is(err, storage.ErrAlreadyExists) || !generated || attempt == maxGenerateAttempts {
+			break
+		}
+
+		from.Path = ""
+	}
+
+	// A link that collides only by case is at another URL, so is not updated even where the caller owns it.
+	if !generated && errors.Is(err, storage.ErrAlreadyExists) && !errors.Is(err, storage.ErrCaseCollision) && u.owns(ctx, l.From) {
+		err = storage.PutLink(ctx, u.Storer, l)
+	}
+
+	if errors.Is(err, storage.ErrCaseCollision) {
+		return nil, status.Error(codes.AlreadyExists, "a url already exists at this address, in a different case")
+	} else if errors.Is(err, storage.ErrAlreadyExists) {
+		return nil, status.Error(codes.AlreadyExists, "a url already exists at this address")
+	} else if errors.Is(err, storage.ErrUnauthorized) {
+		// Reachable where the link changed hands since it was checked, or the storage is not a Creator and the link is
+		// written regardless; see storage.Create.
 		return nil, status.Error(codes.PermissionDenied, "you are not the owner of this record")
 	} else if err != nil {
 		log.Println(err)
@@ -101,6 +187,35 @@ func (u URL) New(ctx context.Context, req *dev.NewRequest) (*dev.Response, error
 	}
 
 	return &dev.Response{
-		Url: from.String(),
+		Url: l.From.String(),
 	}, nil
 }
+
+// normalize returns the URL as it is stored, applying the trailing slash policy.
+func (u URL) normalize(in *url.URL) *url.URL {
+	return storage.Normalize(in, storage.WithTrailingSlash(u.TrailingSlash))
+}
+
+// get looks up the URL. On the folded hosts, where there is no link at the URL itself, a link that differs from it
+// only by case is looked up instead.
+func (u URL) get(ctx context.Context, in *url.URL) (*url.URL, error) {
+	res, err := u.Storer.Get(ctx, in)
+	if !errors.Is(err, storage.ErrNotFound) || !u.FoldedHosts.Contains(in) {
+		return res, err
+	}
+
+	l, err := storage.GetFolded(ctx, u.Storer, in)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.To, nil
+}
+
+// owns indicates whether the agent in the context owns the link at the URL. Links in storage that does not record who
+// owns them (see storage.Authenticator) are owned by nobody.
+func (u URL) owns(ctx context.Context, from *url.URL) bool {
+	a, ok := u.Storer.(storage.Authenticator)
+
+	return ok && a.Owns(ctx, from)
+}
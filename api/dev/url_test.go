@@ -6,15 +6,18 @@ import (
 	"fmt"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/andrewhowdencom/x40.link/api/dev"
 	gendev "github.com/andrewhowdencom/x40.link/api/gen/dev"
 	"github.com/andrewhowdencom/x40.link/storage"
+	"github.com/andrewhowdencom/x40.link/storage/memory"
 	"github.com/andrewhowdencom/x40.link/storage/test"
 	"github.com/andrewhowdencom/x40.link/uid"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func TestEnricher(t *testing.T) {
@@ -181,6 +184,28 @@ func TestGetURL(t *testing.T) {
 			},
 			code: codes.OK,
 		},
+		{
+			name: "equivalent url",
+			str: func() storage.Storer {
+				test := test.New()
+				if err := test.Put(
+					context.Background(),
+					&url.URL{Host: "example.local", Path: "/foo"},
+					&url.URL{Scheme: "https", Host: "example.local", Path: "/"},
+				); err != nil {
+					panic("problem setting up test case: " + err.Error())
+				}
+
+				return test
+			}(),
+			req: &gendev.GetRequest{
+				Url: "https://EXAMPLE.local:443/foo?utm_source=mail",
+			},
+			resp: &gendev.Response{
+				Url: "https://example.local/",
+			},
+			code: codes.OK,
+		},
 	} {
 		tc := tc
 
@@ -284,6 +309,244 @@ func TestNew(t *testing.T) {
 			},
 			code: codes.OK,
 		},
+		{
+			name: "expiry in the past",
+			str:  test.New(),
+			en:   func(_, _ *url.URL) error { return nil },
+			req: &gendev.NewRequest{
+				On: &gendev.RedirectOn{
+					Host: "example.local",
+					Path: "/",
+				},
+				SendTo:    "https://example.local/2",
+				ExpiresAt: timestamppb.New(time.Now().Add(-time.Hour)),
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "expiry in the future",
+			str:  test.New(),
+			en:   func(_, _ *url.URL) error { return nil },
+			req: &gendev.NewRequest{
+				On: &gendev.RedirectOn{
+					Host: "example.local",
+					Path: "/",
+				},
+				SendTo:    "https://example.local/2",
+				ExpiresAt: timestamppb.New(time.Now().Add(time.Hour)),
+			},
+			resp: &gendev.Response{
+				Url: "//example.local/",
+			},
+			code: codes.OK,
+		},
+		{
+			name: "unsupported status code",
+			str:  test.New(),
+			en:   func(_, _ *url.URL) error { return nil },
+			req: &gendev.NewRequest{
+				On: &gendev.RedirectOn{
+					Host: "example.local",
+					Path: "/",
+				},
+				SendTo:     "https://example.local/2",
+				StatusCode: 200,
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "supported status code",
+			str:  test.New(),
+			en:   func(_, _ *url.URL) error { return nil },
+			req: &gendev.NewRequest{
+				On: &gendev.RedirectOn{
+					Host: "example.local",
+					Path: "/",
+				},
+				SendTo:     "https://example.local/2",
+				StatusCode: 308,
+			},
+			resp: &gendev.Response{
+				Url: "//example.local/",
+			},
+			code: codes.OK,
+		},
+		{
+			name: "unsupported query policy",
+			str:  test.New(),
+			en:   func(_, _ *url.URL) error { return nil },
+			req: &gendev.NewRequest{
+				On: &gendev.RedirectOn{
+					Host: "example.local",
+					Path: "/",
+				},
+				SendTo: "https://example.local/2",
+				Query:  "keep",
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "supported query policy",
+			str:  test.New(),
+			en:   func(_, _ *url.URL) error { return nil },
+			req: &gendev.NewRequest{
+				On: &gendev.RedirectOn{
+					Host: "example.local",
+					Path: "/",
+				},
+				SendTo: "https://example.local/2",
+				Query:  "merge",
+			},
+			resp: &gendev.Response{
+				Url: "//example.local/",
+			},
+			code: codes.OK,
+		},
+		{
+			name: "user chosen path taken",
+			str: func() storage.Storer {
+				str := test.New()
+				test.Must(str.Put(
+					context.Background(),
+					&url.URL{Host: "example.local", Path: "/"},
+					&url.URL{Scheme: "https", Host: "example.local", Path: "/1"},
+				))
+
+				return str
+			}(),
+			en: func(_, _ *url.URL) error { return nil },
+			req: &gendev.NewRequest{
+				On: &gendev.RedirectOn{
+					Host: "example.local",
+					Path: "/",
+				},
+				SendTo: "https://example.local/2",
+			},
+			code: codes.AlreadyExists,
+		},
+		{
+			name: "generated path collides, then succeeds",
+			str: func() storage.Storer {
+				str := test.New()
+				test.Must(str.Put(
+					context.Background(),
+					&url.URL{Host: "x40.local", Path: "/1"},
+					&url.URL{Scheme: "https", Host: "example.local", Path: "/1"},
+				))
+
+				return str
+			}(),
+			en: func() func(from *url.URL, to *url.URL) error {
+				i := 0
+
+				return func(from, _ *url.URL) error {
+					i++
+					from.Host = "x40.local"
+					from.Path = fmt.Sprintf("/%d", i)
+
+					return nil
+				}
+			}(),
+			req: &gendev.NewRequest{
+				SendTo: "https://example.local/2",
+			},
+			resp: &gendev.Response{
+				Url: "//x40.local/2",
+			},
+			code: codes.OK,
+		},
+		{
+			name: "generated path always collides",
+			str: func() storage.Storer {
+				str := test.New()
+				test.Must(str.Put(
+					context.Background(),
+					&url.URL{Host: "x40.local", Path: "/6SCxiHS"},
+					&url.URL{Scheme: "https", Host: "example.local", Path: "/1"},
+				))
+
+				return str
+			}(),
+			en: (&dev.URLEnricher{
+				Domain: "x40.local",
+				Path:   uid.New(uid.TypeStatic),
+			}).Enrich,
+			req: &gendev.NewRequest{
+				SendTo: "https://example.local/2",
+			},
+			code: codes.AlreadyExists,
+		},
+		{
+			name: "wildcard destination without a prefix",
+			str:  test.New(),
+			en:   func(_, _ *url.URL) error { return nil },
+			req: &gendev.NewRequest{
+				On: &gendev.RedirectOn{
+					Host: "example.local",
+					Path: "/docs",
+				},
+				SendTo: "https://docs.example.local/*",
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "wildcard prefix",
+			str:  test.New(),
+			en:   func(_, _ *url.URL) error { return nil },
+			req: &gendev.NewRequest{
+				On: &gendev.RedirectOn{
+					Host: "example.local",
+					Path: "/docs/*",
+				},
+				SendTo: "https://docs.example.local/*",
+			},
+			resp: &gendev.Response{
+				Url: "//example.local/docs",
+			},
+			code: codes.OK,
+		},
+		{
+			name: "template",
+			str:  test.New(),
+			en:   func(_, _ *url.URL) error { return nil },
+			req: &gendev.NewRequest{
+				On: &gendev.RedirectOn{
+					Host: "example.local",
+					Path: "/gh/{repo}",
+				},
+				SendTo: "https://github.com/org/{repo}",
+			},
+			resp: &gendev.Response{
+				Url: "//example.local/gh/%7Brepo%7D",
+			},
+			code: codes.OK,
+		},
+		{
+			name: "template with placeholder not captured",
+			str:  test.New(),
+			en:   func(_, _ *url.URL) error { return nil },
+			req: &gendev.NewRequest{
+				On: &gendev.RedirectOn{
+					Host: "example.local",
+					Path: "/gh/{repo}",
+				},
+				SendTo: "https://github.com/{org}/{repo}",
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "template placeholder part of a segment",
+			str:  test.New(),
+			en:   func(_, _ *url.URL) error { return nil },
+			req: &gendev.NewRequest{
+				On: &gendev.RedirectOn{
+					Host: "example.local",
+					Path: "/gh-{repo}",
+				},
+				SendTo: "https://github.com/org/{repo}",
+			},
+			code: codes.InvalidArgument,
+		},
 		{
 			name: "enricher fails",
 			str:  test.New(),
@@ -321,3 +584,183 @@ func TestNew(t *testing.T) {
 		})
 	}
 }
+
+// TestNewPrefix validates that a prefix is able to be requested either with the flag or with a wildcard, and that
+// the wildcard is not stored as part of either URL.
+func TestNewPrefix(t *testing.T) {
+	t.Parallel()
+
+	for _, req := range []*gendev.NewRequest{
+		{On: &gendev.RedirectOn{Host: "example.local", Path: "/docs"}, SendTo: "https://docs.example.local", Prefix: true},
+		{On: &gendev.RedirectOn{Host: "example.local", Path: "/docs/*"}, SendTo: "https://docs.example.local/*"},
+	} {
+		str := test.New()
+		srv := &dev.URL{Storer: str, Enricher: func(_, _ *url.URL) error { return nil }}
+
+		_, err := srv.New(context.Background(), req)
+		assert.Nil(t, err)
+
+		l, err := storage.GetLink(context.Background(), str, &url.URL{Host: "example.local", Path: "/docs"})
+		assert.Nil(t, err)
+		assert.True(t, l.Prefix)
+		assert.Equal(t, &url.URL{Scheme: "https", Host: "docs.example.local"}, l.To)
+	}
+}
+
+// TestNewRootPrefix validates that a prefix at the root of the host is created there, rather than at a generated path.
+func TestNewRootPrefix(t *testing.T) {
+	t.Parallel()
+
+	str := test.New()
+	srv := &dev.URL{Storer: str, Enricher: (&dev.URLEnricher{
+		Domain: "example.local",
+		Path:   uid.New(uid.TypeStatic),
+	}).Enrich}
+
+	res, err := srv.New(context.Background(), &gendev.NewRequest{
+		On:     &gendev.RedirectOn{Host: "example.local", Path: "/*"},
+		SendTo: "https://docs.example.local/*",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "//example.local", res.Url)
+
+	l, err := storage.GetLink(context.Background(), str, &url.URL{Host: "example.local"})
+	assert.Nil(t, err)
+	assert.True(t, l.Prefix)
+	assert.Equal(t, &url.URL{Scheme: "https", Host: "docs.example.local"}, l.To)
+
+	// Having chosen the path, a collision is not retried at a generated one.
+	_, err = srv.New(context.Background(), &gendev.NewRequest{
+		On:     &gendev.RedirectOn{Host: "example.local", Path: "/*"},
+		SendTo: "https://k3s/*",
+	})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+}
+
+// TestNewNormalize validates that links are created under the normalized form of their URL, and that the URL in the
+// response is the one that was stored.
+func TestNewNormalize(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		slash    storage.SlashPolicy
+		req      *gendev.NewRequest
+		expected string
+	}{
+		{
+			req:      &gendev.NewRequest{On: &gendev.RedirectOn{Host: "EXAMPLE.local:443", Path: "/foo/"}, SendTo: "https://k3s"},
+			expected: "//example.local/foo/",
+		},
+		{
+			slash:    storage.SlashStrip,
+			req:      &gendev.NewRequest{On: &gendev.RedirectOn{Host: "EXAMPLE.local:443", Path: "/foo/"}, SendTo: "https://k3s"},
+			expected: "//example.local/foo",
+		},
+	} {
+		str := test.New()
+		srv := &dev.URL{Storer: str, TrailingSlash: tc.slash, Enricher: func(_, _ *url.URL) error { return nil }}
+
+		resp, err := srv.New(context.Background(), tc.req)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expected, resp.GetUrl())
+
+		u, _ := url.Parse(tc.expected)
+		_, err = str.Get(context.Background(), u)
+		assert.Nil(t, err, tc.expected)
+	}
+}
+
+// TestNewFolded validates that, on the folded hosts, links that differ from an existing link only by case are rejected
+// and links are looked up without regard to case.
+func TestNewFolded(t *testing.T) {
+	t.Parallel()
+
+	str := memory.NewHashTable()
+	assert.Nil(t, str.Put(context.Background(), &url.URL{Host: "folded.local", Path: "/AbC"}, &url.URL{Host: "k3s"}))
+	assert.Nil(t, str.Put(context.Background(), &url.URL{Host: "exact.local", Path: "/AbC"}, &url.URL{Host: "k3s"}))
+
+	srv := &dev.URL{
+		Storer:      str,
+		FoldedHosts: storage.FoldedHosts{"folded.local"},
+		Enricher:    func(_, _ *url.URL) error { return nil },
+	}
+
+	for _, tc := range []struct {
+		host, path string
+		code       codes.Code
+	}{
+		{host: "folded.local", path: "/abc", code: codes.AlreadyExists},
+		{host: "folded.local", path: "/ABC", code: codes.AlreadyExists},
+		{host: "folded.local", path: "/abd", code: codes.OK},
+		{host: "exact.local", path: "/abc", code: codes.OK},
+	} {
+		_, err := srv.New(context.Background(), &gendev.NewRequest{
+			On:     &gendev.RedirectOn{Host: tc.host, Path: tc.path},
+			SendTo: "https://k3s",
+		})
+
+		assert.Equal(t, tc.code, status.Code(err), "%s%s", tc.host, tc.path)
+	}
+
+	resp, err := srv.Get(context.Background(), &gendev.GetRequest{Url: "https://folded.local/ABC"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, "//k3s", resp.GetUrl())
+	}
+
+	_, err = srv.Get(context.Background(), &gendev.GetRequest{Url: "https://exact.local/ABC"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+// TestNewOwner validates that a link at a URL the caller chose is updated where the caller owns it, and is otherwise
+// left as it is.
+func TestNewOwner(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name  string
+		agent string
+		host  string
+		path  string
+
+		code codes.Code
+		to   string
+	}{
+		{name: "owner", agent: "alice", host: "exact.local", path: "/foo", to: "https://k3s/2"},
+		{name: "somebody else", agent: "bob", host: "exact.local", path: "/foo", code: codes.AlreadyExists, to: "https://k3s/1"},
+		{name: "no agent", host: "exact.local", path: "/foo", code: codes.AlreadyExists, to: "https://k3s/1"},
+		{name: "owner in another case", agent: "alice", host: "folded.local", path: "/FOO", code: codes.AlreadyExists, to: "https://k3s/1"},
+	} {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.WithValue(context.Background(), storage.CtxKeyAgent, "alice")
+			from := &url.URL{Host: tc.host, Path: "/foo"}
+
+			str := memory.NewHashTable()
+			assert.Nil(t, str.Put(ctx, from, &url.URL{Scheme: "https", Host: "k3s", Path: "/1"}))
+
+			srv := &dev.URL{
+				Storer:      str,
+				FoldedHosts: storage.FoldedHosts{"folded.local"},
+				Enricher:    func(_, _ *url.URL) error { return nil },
+			}
+
+			ctx = context.Background()
+			if tc.agent != "" {
+				ctx = context.WithValue(ctx, storage.CtxKeyAgent, tc.agent)
+			}
+
+			_, err := srv.New(ctx, &gendev.NewRequest{
+				On:     &gendev.RedirectOn{Host: tc.host, Path: tc.path},
+				SendTo: "https://k3s/2",
+			})
+			assert.Equal(t, tc.code, status.Code(err))
+
+			to, err := str.Get(context.Background(), from)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.to, to.String())
+		})
+	}
+}
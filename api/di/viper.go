@@ -7,6 +7,7 @@ import (
 
 	"github.com/andrewhowdencom/x40.link/api/auth/jwts"
 	"github.com/andrewhowdencom/x40.link/cfg"
+	"github.com/andrewhowdencom/x40.link/storage"
 	"google.golang.org/grpc"
 )
 
@@ -32,3 +33,15 @@ func OptsFromViper() ([]grpc.ServerOption, error) {
 
 	return opts, nil
 }
+
+// SlashPolicyFromViper reads the trailing slash policy from viper, such that the API creates links with the same
+// policy as the server looks them up.
+func SlashPolicyFromViper() storage.SlashPolicy {
+	return storage.SlashPolicy(cfg.ServerTrailingSlash.Value())
+}
+
+// FoldedHostsFromViper reads the hosts on which links are matched without regard to case from viper, such that the API
+// rejects links that the server would not be able to tell apart.
+func FoldedHostsFromViper() storage.FoldedHosts {
+	return cfg.ServerCaseInsensitiveHosts.List()
+}
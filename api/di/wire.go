@@ -4,13 +4,14 @@ package di
 
 import (
 	"github.com/andrewhowdencom/x40.link/api"
-	str "github.com/andrewhowdencom/x40.link/storage/di"
+	"github.com/andrewhowdencom/x40.link/storage"
 	"github.com/google/wire"
 	"google.golang.org/grpc"
 )
 
-func WireGRPCServer() (*grpc.Server, error) {
-	wire.Build(api.NewGRPCMux, OptsFromViper, str.WireStorage)
+// WireGRPCServer generates the gRPC server, serving the links in the storage.
+func WireGRPCServer(str storage.Storer) (*grpc.Server, error) {
+	wire.Build(api.NewGRPCMux, OptsFromViper, SlashPolicyFromViper, FoldedHostsFromViper)
 
 	return &grpc.Server{}, nil
 }
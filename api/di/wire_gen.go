@@ -8,21 +8,20 @@ package di
 
 import (
 	"github.com/andrewhowdencom/x40.link/api"
-	"github.com/andrewhowdencom/x40.link/storage/di"
+	"github.com/andrewhowdencom/x40.link/storage"
 	"google.golang.org/grpc"
 )
 
 // Injectors from wire.go:
 
-func WireGRPCServer() (*grpc.Server, error) {
-	storer, err := di.WireStorage()
-	if err != nil {
-		return nil, err
-	}
+// WireGRPCServer generates the gRPC server, serving the links in the storage.
+func WireGRPCServer(str storage.Storer) (*grpc.Server, error) {
+	slashPolicy := SlashPolicyFromViper()
+	foldedHosts := FoldedHostsFromViper()
 	v, err := OptsFromViper()
 	if err != nil {
 		return nil, err
 	}
-	server := api.NewGRPCMux(storer, v...)
+	server := api.NewGRPCMux(str, slashPolicy, foldedHosts, v...)
 	return server, nil
 }
@@ -3,6 +3,7 @@ package cfg
 
 import (
 	"errors"
+	"strings"
 	"sync"
 
 	"github.com/spf13/pflag"
@@ -41,6 +42,20 @@ func (b *Bool) Value() bool {
 	return viper.GetBool(b.Path)
 }
 
+// Int is a configuration entry that is an integer value
+type Int struct {
+	V
+}
+
+// Value returns the value of the configuration
+func (i *Int) Value() int {
+	if !viper.IsSet(i.Path) {
+		return i.Default.(int)
+	}
+
+	return viper.GetInt(i.Path)
+}
+
 // String is the string implementation
 type String struct {
 	V
@@ -55,6 +70,19 @@ func (s String) Value() string {
 	return viper.GetString(s.Path)
 }
 
+// List returns the value of the configuration split by commas, with the space around each entry trimmed and empty
+// entries omitted.
+func (s String) List() []string {
+	l := []string{}
+	for _, e := range strings.Split(s.Value(), ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			l = append(l, e)
+		}
+	}
+
+	return l
+}
+
 var (
 	// ErrMissingOptions can be used by packages to indicate that whatever option they were looking for isn't
 	// present in the configuration, or in the expected format.
@@ -90,15 +118,36 @@ var (
 	OAuth2DeviceAuthorizationEndpoint = &String{V: V{Path: "oauth2.device-authorization.url", Default: "https://x40.eu.auth0.com/oauth/device/code", Usage: "The URL for the device flow", mu: &sync.Mutex{}}}
 	OAuth2TokenURL                    = &String{V: V{Path: "oauth2.token.url", Default: "https://x40.eu.auth0.com/oauth/token", Usage: "The URL that can be used to exchange auth for tokens", mu: &sync.Mutex{}}}
 
-	ServerListenAddress = &String{V: V{Path: "server.listen-address", Default: "localhost:80", Usage: "The address on which to listen to incoming requests", mu: &sync.Mutex{}}}
-	ServerAPIGRPCHost   = &String{V: V{Path: "server.api.grpc.host", Default: "", Usage: "The host on which to listen to GRPC requests (* means all)", mu: &sync.Mutex{}}}
-	ServerH2CEnabled    = &Bool{V: V{Path: "server.protocol.h2c.enabled", Default: true, Usage: "Whether to enable the HTTP/2 Cleartext (with prior knowledge)", mu: &sync.Mutex{}}}
+	ServerListenAddress          = &String{V: V{Path: "server.listen-address", Default: "localhost:80", Usage: "The address on which to listen to incoming requests", mu: &sync.Mutex{}}}
+	ServerAPIGRPCHost            = &String{V: V{Path: "server.api.grpc.host", Default: "", Usage: "The host on which to listen to GRPC requests (* means all)", mu: &sync.Mutex{}}}
+	ServerH2CEnabled             = &Bool{V: V{Path: "server.protocol.h2c.enabled", Default: true, Usage: "Whether to enable the HTTP/2 Cleartext (with prior knowledge)", mu: &sync.Mutex{}}}
+	ServerRedirectStatus         = &Int{V: V{Path: "server.redirect.status", Default: 307, Usage: "The HTTP status code to redirect with, where the link does not specify one", mu: &sync.Mutex{}}}
+	ServerRedirectQuery          = &String{V: V{Path: "server.redirect.query", Default: "drop", Usage: "How the query string is forwarded to the destination (drop, append or merge), where the link does not specify", mu: &sync.Mutex{}}}
+	ServerRedirectQueryBlocklist = &String{V: V{Path: "server.redirect.query-blocklist", Default: "", Usage: "The query parameters never forwarded to the destination, separated by commas. A trailing * matches any suffix (e.g. utm_*)", mu: &sync.Mutex{}}}
+	ServerTrailingSlash          = &String{V: V{Path: "server.trailing-slash", Default: "keep", Usage: "Whether short links are created and looked up with their trailing slash (keep), or without it (strip)", mu: &sync.Mutex{}}}
+	ServerCaseInsensitiveHosts   = &String{V: V{Path: "server.case-insensitive-hosts", Default: "", Usage: "The hosts on which short links are matched without regard to case, separated by commas (* means all)", mu: &sync.Mutex{}}}
 
 	// Storage* is configuration related to the link storage logic.
 	StorageYamlFile         = &V{Path: "storage.yaml.file", Default: "", Usage: "The source file to read URLs from", mu: &sync.Mutex{}}
+	StorageYamlStrict       = &Bool{V: V{Path: "storage.yaml.strict", Default: false, Usage: "Whether to reject the YAML file entirely if any of its rows are invalid", mu: &sync.Mutex{}}}
+	StorageYamlFormat       = &String{V: V{Path: "storage.yaml.format", Default: "", Usage: "The format of the storage file (yaml, json, toml or csv). Defaults to the file extension", mu: &sync.Mutex{}}}
 	StorageHashMap          = &V{Path: "storage.hash-map", Default: false, Usage: "Whether to use an in-memory hash map as URL storage", mu: &sync.Mutex{}}
+	StorageHashMapLayout    = &String{V: V{Path: "storage.hash-map.layout", Default: "hash-table", Usage: "The layout of the in-memory storage (hash-table or radix-trie). The radix trie keeps the links in order", mu: &sync.Mutex{}}}
 	StorageBoltDBFile       = &V{Path: "storage.boltdb.file", Default: "", Usage: "The source file to use with boldDB backed URL storage", mu: &sync.Mutex{}}
-	StorageFirestoreProject = &V{Path: "storage.firestore.project", Default: "", Usage: "The Google Cloud project to use the default firebase storage for", mu: &sync.Mutex{}}
+	StorageSQLiteFile       = &V{Path: "storage.sqlite.file", Default: "", Usage: "The source file to use with SQLite backed URL storage", mu: &sync.Mutex{}}
+	StoragePostgresDSN      = &V{Path: "storage.postgres.dsn", Default: "", Usage: "The connection string to use with PostgreSQL backed URL storage", mu: &sync.Mutex{}}
+	StorageFirestoreProject = &V{Path: "storage.firestore.project", Default: "", Usage: "The Google Cloud project to use the default firebase storage for. Where FIRESTORE_EMULATOR_HOST is set, the emulator is used instead", mu: &sync.Mutex{}}
+	StorageLayers           = &String{V: V{Path: "storage.layers", Default: "", Usage: "The storage to layer, in order, separated by commas (e.g. yaml,boltdb). Defaults to all configured storage", mu: &sync.Mutex{}}}
+	StorageCacheSize        = &Int{V: V{Path: "storage.cache.size", Default: 0, Usage: "The number of links to cache in memory, in front of the storage (0 disables caching)", mu: &sync.Mutex{}}}
+	StorageCacheTTL         = &String{V: V{Path: "storage.cache.ttl", Default: "1m", Usage: "How long to cache links that were found", mu: &sync.Mutex{}}}
+	StorageCacheNegativeTTL = &String{V: V{Path: "storage.cache.negative-ttl", Default: "10s", Usage: "How long to cache links that were not found (0 disables)", mu: &sync.Mutex{}}}
+	StorageCacheStats       = &String{V: V{Path: "storage.cache.stats", Default: "5m", Usage: "How often to log how many reads the cache served and missed (0 disables)", mu: &sync.Mutex{}}}
+	StorageReaperInterval   = &String{V: V{Path: "storage.reaper.interval", Default: "1h", Usage: "How often to purge expired links from storage (0 disables purging)", mu: &sync.Mutex{}}}
+
+	// Link* is configuration related to the links created by the client.
+	LinkExpires = &String{V: V{Path: "link.expires", Default: "", Usage: "When the link stops working, as an RFC 3339 time or a duration from now", mu: &sync.Mutex{}}}
+	LinkStatus  = &Int{V: V{Path: "link.status", Default: 0, Usage: "The HTTP status code to redirect with (301, 302, 307 or 308)", mu: &sync.Mutex{}}}
+	LinkQuery   = &String{V: V{Path: "link.query", Default: "", Usage: "How the query string is forwarded to the destination (drop, append or merge)", mu: &sync.Mutex{}}}
 
 	Timeout = &String{V: V{Path: "timeout", Default: "1m", Usage: "The fallback timeout across the application", mu: &sync.Mutex{}}}
 )
@@ -115,6 +164,8 @@ func (v *V) AddFlagTo(fs *pflag.FlagSet) {
 		fs.StringP(v.Path, v.Short, v.Default.(string), v.Usage)
 	case bool:
 		fs.BoolP(v.Path, v.Short, v.Default.(bool), v.Usage)
+	case int:
+		fs.IntP(v.Path, v.Short, v.Default.(int), v.Usage)
 	default:
 		panic("unsupported conversion to flag: " + v.Path)
 	}
@@ -47,6 +47,15 @@ func TestAddFlagTo(t *testing.T) {
 			},
 			panic: "unsupported conversion to flag: example.path",
 		},
+		{
+			name: "int",
+			v: V{
+				Path:    "example.path",
+				Default: 307,
+				Usage:   "configures the example number",
+				mu:      &sync.Mutex{},
+			},
+		},
 		{
 			name: "bool",
 			v: V{
@@ -82,6 +91,8 @@ func TestAddFlagTo(t *testing.T) {
 				assert.Equal(t, tc.v.Default, flag.DefValue)
 			case bool:
 				assert.Equal(t, fmt.Sprintf("%t", tc.v.Default), flag.DefValue)
+			case int:
+				assert.Equal(t, fmt.Sprintf("%d", tc.v.Default), flag.DefValue)
 			}
 
 			assert.Equal(t, tc.v.Usage, flag.Usage)
@@ -113,3 +124,20 @@ func TestFlagToConfiguration(t *testing.T) {
 	assert.Equal(t, "bar", viper.GetString(v.Path))
 
 }
+
+func TestStringList(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		value    string
+		expected []string
+	}{
+		{value: "", expected: []string{}},
+		{value: "x40", expected: []string{"x40"}},
+		{value: "x40, k3s ,,", expected: []string{"x40", "k3s"}},
+	} {
+		s := &String{V: V{Path: "example.list." + tc.value, Default: tc.value, mu: &sync.Mutex{}}}
+
+		assert.Equal(t, tc.expected, s.List(), tc.value)
+	}
+}
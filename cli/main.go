@@ -21,6 +21,7 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // Flag sets.
@@ -61,6 +62,23 @@ var (
 		return fs
 	}()
 
+	// linkFlagSet describes the link being created, and so is only attached to the root command.
+	linkFlagSet = func() *pflag.FlagSet {
+		fs := &pflag.FlagSet{}
+
+		for _, f := range []interface {
+			AddFlagTo(*pflag.FlagSet)
+		}{
+			cfg.LinkExpires,
+			cfg.LinkStatus,
+			cfg.LinkQuery,
+		} {
+			f.AddFlagTo(fs)
+		}
+
+		return fs
+	}()
+
 	// urlFlagSet is preserved as a composition of the two for the existing root command,
 	// which is auth-required. New commands that don't need auth should attach only
 	// apiFlagSet.
@@ -93,6 +111,18 @@ Generate a URL on a specific domain, registered on x40.link:
 
     @ https://source.domain/path https://my.destination.url/path
 
+Generate a URL that stops working after a week:
+
+    @ --link.expires 168h https://my.destination.url/path
+
+Generate a URL that redirects permanently:
+
+    @ --link.status 308 https://my.destination.url/path
+
+Generate a URL that passes its query string (e.g. ?utm_source=mail) on to the destination:
+
+    @ --link.query merge https://my.destination.url/path
+
 Or, look up the destination of an existing short link:
 
     @ resolve https://source.domain/path
@@ -151,6 +181,18 @@ func DoURL(_ *cobra.Command, args []string) error {
 		}
 	}
 
+	if v := viper.GetString(cfg.LinkExpires.Path); v != "" {
+		exp, err := parseExpiry(v, time.Now())
+		if err != nil {
+			return fmt.Errorf("%w: %s", sysexits.Usage, err)
+		}
+
+		req.ExpiresAt = timestamppb.New(exp)
+	}
+
+	req.StatusCode = int32(viper.GetInt(cfg.LinkStatus.Path))
+	req.Query = viper.GetString(cfg.LinkQuery.Path)
+
 	ts, err := auth.TokenSource()
 	if err != nil {
 		return fmt.Errorf("%w: %s", sysexits.Software, err)
@@ -179,6 +221,21 @@ func DoURL(_ *cobra.Command, args []string) error {
 	return nil
 }
 
+// parseExpiry converts the user supplied expiry into a time. The expiry can either be a RFC 3339 timestamp, or a
+// duration (e.g. 72h) relative to now.
+func parseExpiry(in string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(in); err == nil {
+		return now.Add(d), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, in)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expiry is neither a duration nor a RFC 3339 time: %s", in)
+	}
+
+	return t, nil
+}
+
 // DoResolve is the cobra command handler for the "resolve" subcommand. It builds
 // a gRPC client (without per-RPC credentials, since the Get RPC is public) and
 // delegates the actual call to doResolveWithClient for testability.
@@ -244,6 +301,7 @@ func classifyResolveError(err error) error {
 
 func init() {
 	Root.Flags().AddFlagSet(urlFlagSet)
+	Root.Flags().AddFlagSet(linkFlagSet)
 	Root.AddCommand(resolveCmd)
 	resolveCmd.Flags().AddFlagSet(apiFlagSet)
 }
@@ -4,9 +4,10 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
-	gendev "github.com/andrewhowdencom/x40.link/api/gen/dev"
 	"github.com/andrewhowdencom/sysexits"
+	gendev "github.com/andrewhowdencom/x40.link/api/gen/dev"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -153,3 +154,45 @@ func TestDoResolveWithClient(t *testing.T) {
 		})
 	}
 }
+
+func TestParseExpiry(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name string
+
+		input string
+
+		expected time.Time
+		err      bool
+	}{
+		{
+			name:     "duration",
+			input:    "72h",
+			expected: time.Date(2024, time.March, 4, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "timestamp",
+			input:    "2024-06-01T00:00:00Z",
+			expected: time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "neither",
+			input: "next tuesday",
+			err:   true,
+		},
+	} {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			exp, err := parseExpiry(tc.input, now)
+
+			assert.Equal(t, tc.err, err != nil)
+			assert.True(t, tc.expected.Equal(exp))
+		})
+	}
+}
@@ -19,4 +19,5 @@ code!`,
 
 func init() {
 	Root.AddCommand(serveCmd)
+	Root.AddCommand(storageCmd)
 }
@@ -18,12 +18,18 @@ var (
 
 var (
 	serveFlagSet = &pflag.FlagSet{}
+
+	// storageFlagSet configures the storage in the same way for each command that uses it. The flags are shared,
+	// rather than copied, so that they're bound to the configuration only once.
+	storageFlagSet = &pflag.FlagSet{}
 )
 
 var storageFlags = []string{
 	cfg.StorageHashMap.Path,
 	cfg.StorageYamlFile.Path,
 	cfg.StorageBoltDBFile.Path,
+	cfg.StorageSQLiteFile.Path,
+	cfg.StoragePostgresDSN.Path,
 	cfg.StorageFirestoreProject.Path,
 }
 
@@ -38,12 +44,33 @@ func init() {
 	for _, f := range []interface {
 		AddFlagTo(fs *pflag.FlagSet)
 	}{
-		// Storage Flags
+		// Storage
 		cfg.StorageYamlFile,
+		cfg.StorageYamlStrict,
+		cfg.StorageYamlFormat,
 		cfg.StorageHashMap,
+		cfg.StorageHashMapLayout,
 		cfg.StorageBoltDBFile,
+		cfg.StorageSQLiteFile,
+		cfg.StoragePostgresDSN,
 		cfg.StorageFirestoreProject,
+		cfg.StorageLayers,
+		cfg.StorageCacheSize,
+		cfg.StorageCacheTTL,
+		cfg.StorageCacheNegativeTTL,
+		cfg.StorageCacheStats,
+		cfg.StorageReaperInterval,
+
+		// The hosts matched without regard to case are part of the storage, as links written to them by any command
+		// must not differ from another only by case.
+		cfg.ServerCaseInsensitiveHosts,
+	} {
+		f.AddFlagTo(storageFlagSet)
+	}
 
+	for _, f := range []interface {
+		AddFlagTo(fs *pflag.FlagSet)
+	}{
 		// Authentication
 		cfg.AuthX40,
 
@@ -58,14 +85,18 @@ func init() {
 
 		cfg.ServerAPIGRPCHost,
 		cfg.ServerH2CEnabled,
+		cfg.ServerRedirectStatus,
+		cfg.ServerRedirectQuery,
+		cfg.ServerRedirectQueryBlocklist,
+		cfg.ServerTrailingSlash,
 	} {
 		f.AddFlagTo(serveFlagSet)
 	}
 
 	// Bind the flag set to the command, and ensure it validated.
+	serveCmd.Flags().AddFlagSet(storageFlagSet)
 	serveCmd.Flags().AddFlagSet(serveFlagSet)
 	serveCmd.MarkFlagsOneRequired(storageFlags...)
-	serveCmd.MarkFlagsMutuallyExclusive(storageFlags...)
 
 }
 
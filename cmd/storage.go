@@ -0,0 +1,270 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/andrewhowdencom/sysexits"
+	"github.com/andrewhowdencom/x40.link/cfg"
+	"github.com/andrewhowdencom/x40.link/server"
+	"github.com/andrewhowdencom/x40.link/storage"
+	"github.com/andrewhowdencom/x40.link/storage/catalogue"
+	"github.com/andrewhowdencom/x40.link/storage/di"
+	"github.com/andrewhowdencom/x40.link/storage/transfer"
+	"github.com/spf13/cobra"
+)
+
+// storageCmd groups the commands that work with link storage directly, rather than through the server.
+var storageCmd = &cobra.Command{
+	Use:   "storage",
+	Short: "Work with link storage directly",
+}
+
+// validateFormat overrides the format of the file passed to validate, which is otherwise derived from its extension.
+var validateFormat string
+
+// validateCmd checks a storage file without starting the server.
+var validateCmd = &cobra.Command{
+	Use:   "validate <file>",
+	Short: "Validate a file of links (YAML, JSON, TOML or CSV), reporting every invalid row",
+	Example: `  # Validate the catalogue before deploying it
+  x40.link storage validate links.yaml
+
+  # Validate a catalogue whose extension does not match its format
+  x40.link storage validate --format csv links.txt`,
+	Args: cobra.ExactArgs(1),
+	RunE: RunValidate,
+}
+
+// exportCmd writes every link in the configured storage to a file, or stdout.
+var exportCmd = &cobra.Command{
+	Use:   "export [file]",
+	Short: "Export every link, with its owner and metadata, from the configured storage",
+	Example: `  # Back up the links held in Firestore
+  x40.link storage export --storage.firestore.project=x40-link links.jsonl
+
+  # Move the links from BoltDB to Firestore
+  x40.link storage export --storage.boltdb.file=links.db | \
+    x40.link storage import --storage.firestore.project=x40-link`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: RunExport,
+}
+
+// importFlags are the flags that modify the behaviour of an import.
+var importFlags = struct {
+	conflict string
+	dryRun   bool
+}{}
+
+// importCmd writes links, as exported by exportCmd, to the configured storage.
+var importCmd = &cobra.Command{
+	Use:   "import [file]",
+	Short: "Import links, as written by export, into the configured storage",
+	Example: `  # Check what restoring a back up would change, without changing anything
+  x40.link storage import --storage.boltdb.file=links.db --dry-run links.jsonl
+
+  # Restore a back up, replacing any links that have since changed
+  x40.link storage import --storage.boltdb.file=links.db --conflict=overwrite links.jsonl`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: RunImport,
+}
+
+// backupCmd writes a snapshot of the configured storage to a file, or stdout.
+var backupCmd = &cobra.Command{
+	Use:   "backup [file]",
+	Short: "Write a consistent snapshot of the configured storage, in its native format",
+	Long: `Write a consistent snapshot of the configured storage, in its native format. The snapshot is able to be
+restored by starting the server with it in place of the original.
+
+Only storage that holds its own data (e.g. BoltDB) is able to be backed up. Where the server is already running with
+the storage, the snapshot is instead available from the server at ` + server.PathBackup + `, to those with permission to
+take it.`,
+	Example: `  # Back up a BoltDB database
+  x40.link storage backup --storage.boltdb.file=urls.db urls.backup.db
+
+  # Take a snapshot from a running server
+  curl --header "Authorization: Bearer $TOKEN" --output urls.backup.db https://x40.link` + server.PathBackup,
+	Args: cobra.MaximumNArgs(1),
+	RunE: RunBackup,
+}
+
+func init() {
+	backupCmd.Flags().AddFlagSet(storageFlagSet)
+	backupCmd.MarkFlagsOneRequired(storageFlags...)
+
+	storageCmd.AddCommand(backupCmd)
+
+	exportCmd.Flags().AddFlagSet(storageFlagSet)
+	exportCmd.MarkFlagsOneRequired(storageFlags...)
+
+	importCmd.Flags().StringVar(&importFlags.conflict, "conflict", string(transfer.Skip), "What to do with links that are already stored (skip, overwrite or fail)")
+	importCmd.Flags().BoolVar(&importFlags.dryRun, "dry-run", false, "Report what would be imported, without writing anything")
+	importCmd.Flags().AddFlagSet(storageFlagSet)
+	importCmd.MarkFlagsOneRequired(storageFlags...)
+
+	storageCmd.AddCommand(exportCmd)
+	storageCmd.AddCommand(importCmd)
+
+	validateCmd.Flags().StringVar(&validateFormat, "format", "", "The format of the file (yaml, json, toml or csv). Defaults to the file extension")
+
+	storageCmd.AddCommand(validateCmd)
+}
+
+// RunValidate implements the storage validate command
+func RunValidate(cmd *cobra.Command, args []string) error {
+	format, err := catalogue.FormatFor(args[0])
+	if validateFormat != "" {
+		format, err = catalogue.ParseFormat(validateFormat)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", sysexits.Usage, err)
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("%w: %s", sysexits.NoInput, err)
+	}
+
+	defer func() { _ = f.Close() }()
+
+	err = catalogue.Validate(f, format)
+
+	var verr *catalogue.ValidationError
+	if errors.As(err, &verr) {
+		for _, p := range verr.Problems {
+			cmd.Printf("%s:%d: %s\n", args[0], p.Line, p.Message)
+		}
+
+		return fmt.Errorf("%w: %d invalid rows", sysexits.DataErr, len(verr.Problems))
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", sysexits.DataErr, err)
+	}
+
+	cmd.Printf("%s: ok\n", args[0])
+
+	return nil
+}
+
+// RunExport implements the storage export command
+func RunExport(cmd *cobra.Command, args []string) error {
+	str, err := di.ResolveStorage()
+	if err != nil {
+		return fmt.Errorf("%w: %s", sysexits.Software, err)
+	}
+
+	w := cmd.OutOrStdout()
+	if len(args) > 0 && args[0] != "-" {
+		f, err := os.Create(args[0])
+		if err != nil {
+			return fmt.Errorf("%w: %s", sysexits.CantCreat, err)
+		}
+
+		defer func() { _ = f.Close() }()
+		w = f
+	}
+
+	n, err := transfer.Export(cmd.Context(), str, w)
+	if err != nil {
+		return fmt.Errorf("%w: exported %d links before failing: %s", sysexits.Software, n, err)
+	}
+
+	cmd.PrintErrf("exported %d links\n", n)
+
+	return nil
+}
+
+// RunImport implements the storage import command
+func RunImport(cmd *cobra.Command, args []string) error {
+	policy, err := transfer.ParsePolicy(importFlags.conflict)
+	if err != nil {
+		return fmt.Errorf("%w: %s", sysexits.Usage, err)
+	}
+
+	opts := []transfer.Option{
+		transfer.WithPolicy(policy),
+		transfer.WithFoldedHosts(cfg.ServerCaseInsensitiveHosts.List()),
+	}
+	if importFlags.dryRun {
+		opts = append(opts, transfer.WithDryRun())
+	}
+
+	str, err := di.ResolveStorage()
+	if err != nil {
+		return fmt.Errorf("%w: %s", sysexits.Software, err)
+	}
+
+	r := cmd.InOrStdin()
+	if len(args) > 0 && args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return fmt.Errorf("%w: %s", sysexits.NoInput, err)
+		}
+
+		defer func() { _ = f.Close() }()
+		r = f
+	}
+
+	sum, err := transfer.Import(cmd.Context(), str, r, opts...)
+	if sum != nil {
+		for _, f := range sum.Failed {
+			cmd.PrintErrf("failed: %s\n", f)
+		}
+
+		prefix := ""
+		if importFlags.dryRun {
+			prefix = "dry run: "
+		}
+
+		cmd.PrintErrf(
+			"%sread %d links: %d created, %d overwritten, %d skipped, %d failed\n",
+			prefix, sum.Read, sum.Created, sum.Overwritten, sum.Skipped, len(sum.Failed),
+		)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", sysexits.DataErr, err)
+	}
+
+	if len(sum.Failed) > 0 {
+		return fmt.Errorf("%w: %d links failed to import", sysexits.DataErr, len(sum.Failed))
+	}
+
+	return nil
+}
+
+// RunBackup implements the storage backup command
+func RunBackup(cmd *cobra.Command, args []string) error {
+	str, err := di.ResolveStorage()
+	if err != nil {
+		return fmt.Errorf("%w: %s", sysexits.Software, err)
+	}
+
+	b, ok := storage.AsBackuper(str)
+	if !ok {
+		return fmt.Errorf("%w: %s", sysexits.Usage, "storage does not back up")
+	}
+
+	w := cmd.OutOrStdout()
+	if len(args) > 0 && args[0] != "-" {
+		f, err := os.Create(args[0])
+		if err != nil {
+			return fmt.Errorf("%w: %s", sysexits.CantCreat, err)
+		}
+
+		defer func() { _ = f.Close() }()
+		w = f
+	}
+
+	n, err := b.Backup(w)
+	if err != nil {
+		return fmt.Errorf("%w: %s", sysexits.IOErr, err)
+	}
+
+	cmd.PrintErrf("wrote %d bytes\n", n)
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package cmd_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andrewhowdencom/sysexits"
+	"github.com/andrewhowdencom/x40.link/cmd"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunValidate(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	for _, tc := range []struct {
+		name string
+		file string
+		in   string
+
+		out  string
+		exit sysexits.Sysexit
+	}{
+		{
+			name: "valid",
+			in:   "- from: //x40/foo\n  to: //k3s/bar\n",
+			out:  "ok",
+			exit: sysexits.OK,
+		},
+		{
+			name: "invalid",
+			in:   "- from: //x40/foo\n  to: //k3s/bar\n- from: //x40/foo\n  to: //k3s/baz\n",
+			out:  ":3: duplicate from",
+			exit: sysexits.DataErr,
+		},
+		{
+			name: "csv",
+			file: "links.csv",
+			in:   "from,to\n//x40/foo,//k3s/bar\n/no-host,//k3s/bar\n",
+			out:  ":3: from \"/no-host\" has no host",
+			exit: sysexits.DataErr,
+		},
+		{
+			name: "unsupported",
+			file: "links.txt",
+			in:   "//x40/foo -> //k3s/bar",
+			exit: sysexits.Usage,
+		},
+		{
+			name: "missing",
+			exit: sysexits.NoInput,
+		},
+	} {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if tc.file == "" {
+				tc.file = tc.name + ".yaml"
+			}
+
+			p := filepath.Join(dir, tc.file)
+			if tc.in != "" {
+				assert.Nil(t, os.WriteFile(p, []byte(tc.in), 0600))
+			}
+
+			out := &bytes.Buffer{}
+			c := &cobra.Command{
+				Args:          cobra.ExactArgs(1),
+				RunE:          cmd.RunValidate,
+				SilenceUsage:  true,
+				SilenceErrors: true,
+			}
+			c.SetOut(out)
+			c.SetArgs([]string{p})
+
+			assert.ErrorIs(t, cmd.Execute(c), tc.exit)
+			assert.Contains(t, out.String(), tc.out)
+		})
+	}
+}
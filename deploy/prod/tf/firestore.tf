@@ -0,0 +1,23 @@
+# Firestore only maintains single field indexes for queries against a single collection by default. The storage queries
+# the links with a path across every host, through the "id" collection group, so the fields it queries that way need an
+# index with the collection group scope as well. See storage/firestore.
+#
+# Overriding a field replaces its default indexes, so the collection scope is kept alongside.
+resource "google_firestore_field" "id" {
+  for_each = toset(["expires", "owner"])
+
+  collection = "id"
+  field      = each.value
+
+  index_config {
+    indexes {
+      order       = "ASCENDING"
+      query_scope = "COLLECTION"
+    }
+
+    indexes {
+      order       = "ASCENDING"
+      query_scope = "COLLECTION_GROUP"
+    }
+  }
+}
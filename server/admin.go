@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/andrewhowdencom/x40.link/api"
+	"github.com/andrewhowdencom/x40.link/api/auth"
+	"github.com/andrewhowdencom/x40.link/api/auth/jwts"
+	"github.com/andrewhowdencom/x40.link/storage"
+	"github.com/go-chi/chi/v5"
+	"google.golang.org/grpc/metadata"
+	"schneider.vip/problem"
+)
+
+// Path* are the paths at which the administrative endpoints are served. They're prefixed such that they're unlikely
+// to collide with a short link.
+const (
+	PathBackup = "/_admin/backup"
+)
+
+// Validator validates the credentials supplied with a request to a given method, returning the context with the agent
+// that supplied them. Implemented by the (JWT) interceptor that authenticates the gRPC API.
+type Validator interface {
+	ValidateCtx(ctx context.Context, method string) (context.Context, error)
+}
+
+type adminHandler struct {
+	str storage.Backuper
+	val Validator
+}
+
+// WithBackup serves a snapshot of the storage at PathBackup, to those with permission to take it.
+func WithBackup(str storage.Backuper, val Validator) Option {
+	return func(srv *http.Server) error {
+		if val == nil {
+			return ErrMissingValidator
+		}
+
+		mux := srv.Handler.(*chi.Mux)
+		ah := &adminHandler{str: str, val: val}
+
+		mux.Get(PathBackup, ah.Backup)
+
+		return nil
+	}
+}
+
+// authenticate validates the credentials supplied with the request, in the same way that they are validated when
+// supplied to the gRPC API.
+func (a *adminHandler) authenticate(r *http.Request, method string) error {
+	md := metadata.MD{}
+	if v := r.Header.Get("Authorization"); v != "" {
+		md.Set(auth.MetaKeyAuthorization, v)
+	}
+
+	_, err := a.val.ValidateCtx(metadata.NewIncomingContext(r.Context(), md), method)
+
+	return err
+}
+
+// authStatus returns the HTTP status code for a request whose credentials were rejected: 403 where they are valid, but
+// do not grant permission to the method, and 401 otherwise.
+func authStatus(err error) int {
+	if errors.Is(err, jwts.ErrMissingPermission) {
+		return http.StatusForbidden
+	}
+
+	return http.StatusUnauthorized
+}
+
+// Backup streams a snapshot of the storage to the client.
+func (a *adminHandler) Backup(w http.ResponseWriter, r *http.Request) {
+	if err := a.authenticate(r, api.MethodAdminBackup); err != nil {
+		WithError(r, problem.New(
+			problem.Status(authStatus(err)),
+			problem.WrapSilent(err),
+		))
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="x40.link.backup"`)
+
+	n, err := a.str.Backup(w)
+	if err == nil {
+		return
+	}
+
+	// Once the snapshot has started, the status has already been sent. Aborting the connection is the only way to
+	// indicate to the client that the snapshot is incomplete, rather than it appearing to succeed.
+	if n > 0 {
+		panic(http.ErrAbortHandler)
+	}
+
+	w.Header().Del("Content-Type")
+	w.Header().Del("Content-Disposition")
+
+	WithError(r, problem.New(
+		problem.Status(http.StatusInternalServerError),
+		problem.WrapSilent(err),
+	))
+}
@@ -0,0 +1,117 @@
+package server_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andrewhowdencom/x40.link/api"
+	"github.com/andrewhowdencom/x40.link/api/auth/jwts"
+	"github.com/andrewhowdencom/x40.link/server"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// backuper is a storage that writes a fixed snapshot.
+type backuper struct {
+	err error
+}
+
+func (b backuper) Backup(w io.Writer) (int64, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	n, err := w.Write([]byte("snapshot"))
+
+	return int64(n), err
+}
+
+func TestNewServer_WithBackup(t *testing.T) {
+	t.Parallel()
+
+	key := []byte("not-very-secret")
+
+	icept, err := jwts.NewServerInterceptor(
+		jwts.WithStaticKey(key),
+		jwts.WithParser(jwt.NewParser()),
+		jwts.WithAddedPermissions(api.AdminPermissions()),
+	)
+	assert.Nil(t, err)
+
+	token := func(permissions ...string) string {
+		tok, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"sub":         "admin",
+			"exp":         jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			"permissions": permissions,
+		}).SignedString(key)
+		assert.Nil(t, err)
+
+		return "Bearer " + tok
+	}
+
+	for _, tc := range []struct {
+		name string
+		str  backuper
+		auth string
+
+		status int
+		body   string
+	}{
+		{
+			name:   "permitted",
+			auth:   token(api.AdminPermissions()[api.MethodAdminBackup]),
+			status: http.StatusOK,
+			body:   "snapshot",
+		},
+		{
+			name:   "missing authorization",
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "invalid token",
+			auth:   "Bearer not-a-token",
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "missing permission",
+			auth:   token("api.x40.link/scopes/x40.dev.url.ManageURLs.New"),
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "backup fails",
+			str:    backuper{err: errors.New("disk on fire")},
+			auth:   token(api.AdminPermissions()[api.MethodAdminBackup]),
+			status: http.StatusInternalServerError,
+		},
+	} {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv, err := server.New(server.WithBackup(tc.str, icept))
+			assert.Nil(t, err)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, server.PathBackup, nil)
+			if tc.auth != "" {
+				req.Header.Set("Authorization", tc.auth)
+			}
+
+			srv.Handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.status, w.Result().StatusCode)
+			if tc.body != "" {
+				assert.Equal(t, tc.body, w.Body.String())
+			}
+		})
+	}
+
+	// Without a validator, anybody would be able to take a backup.
+	_, err = server.New(server.WithBackup(backuper{}, nil))
+	assert.ErrorIs(t, err, server.ErrFailedToApplyOption)
+}
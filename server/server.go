@@ -18,10 +18,17 @@ import (
 // Option is a function type that modifies the behavior of the server
 type Option func(*http.Server) error
 
+// StorageOption is a function type that modifies the behavior of the handler that redirects links from storage
+type StorageOption func(*strHandler) error
+
 // Err* are sentinel errors
 var (
 	ErrFailedToApplyOption = errors.New("failed to apply option")
 	ErrFailedToStart       = errors.New("failed to start server")
+	ErrInvalidStatus       = errors.New("unsupported redirect status code")
+	ErrInvalidQueryPolicy  = errors.New("unsupported query policy")
+	ErrInvalidSlashPolicy  = errors.New("unsupported trailing slash policy")
+	ErrMissingValidator    = errors.New("administrative endpoints require a validator")
 )
 
 var defaultOptions = []Option{
@@ -67,12 +74,21 @@ func WithListenAddress(addr string) Option {
 }
 
 // WithStorage allows starting the service with a specific storage engine.
-func WithStorage(str storage.Storer) Option {
+func WithStorage(str storage.Storer, opts ...StorageOption) Option {
 	return func(srv *http.Server) error {
 		mux := srv.Handler.(*chi.Mux)
 
 		sh := &strHandler{
-			str: str,
+			str:    str,
+			status: http.StatusTemporaryRedirect,
+			query:  storage.QueryDrop,
+			slash:  storage.SlashKeep,
+		}
+
+		for _, opt := range opts {
+			if err := opt(sh); err != nil {
+				return err
+			}
 		}
 
 		mux.Get("/*", sh.Redirect)
@@ -81,6 +97,66 @@ func WithStorage(str storage.Storer) Option {
 	}
 }
 
+// WithDefaultStatus sets the HTTP status code with which to redirect links that do not specify their own.
+func WithDefaultStatus(code int) StorageOption {
+	return func(sh *strHandler) error {
+		if code == 0 || !storage.ValidStatus(code) {
+			return fmt.Errorf("%w: %d", ErrInvalidStatus, code)
+		}
+
+		sh.status = code
+
+		return nil
+	}
+}
+
+// WithDefaultQuery sets how the query string is forwarded to the destination of links that do not specify.
+func WithDefaultQuery(p storage.QueryPolicy) StorageOption {
+	return func(sh *strHandler) error {
+		if p == storage.QueryDefault || !storage.ValidQuery(p) {
+			return fmt.Errorf("%w: %q", ErrInvalidQueryPolicy, p)
+		}
+
+		sh.query = p
+
+		return nil
+	}
+}
+
+// WithQueryBlocklist sets the query parameters that are never forwarded to the destination, whatever the policy of the
+// link. See storage.QueryPolicy.Forward.
+func WithQueryBlocklist(params ...string) StorageOption {
+	return func(sh *strHandler) error {
+		sh.blocklist = append(sh.blocklist, params...)
+
+		return nil
+	}
+}
+
+// WithTrailingSlash sets what is done with the trailing slash of the URL before it is looked up. It should match the
+// policy with which links are created. See storage.Normalize.
+func WithTrailingSlash(p storage.SlashPolicy) StorageOption {
+	return func(sh *strHandler) error {
+		if !storage.ValidSlash(p) {
+			return fmt.Errorf("%w: %q", ErrInvalidSlashPolicy, p)
+		}
+
+		sh.slash = p
+
+		return nil
+	}
+}
+
+// WithCaseInsensitiveHosts sets the hosts on which links are matched without regard to case, where there is no link
+// in the exact case of the URL. It should match the hosts with which links are created. See storage.FoldedHosts.
+func WithCaseInsensitiveHosts(hosts ...string) StorageOption {
+	return func(sh *strHandler) error {
+		sh.folded = append(sh.folded, hosts...)
+
+		return nil
+	}
+}
+
 // WithH2C allows piping the connection to a HTTP/2 server, which will hijack the request to use the HTTP/2 protocol
 // but over the initially supplied connection.
 func WithH2C() Option {
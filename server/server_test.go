@@ -9,6 +9,8 @@ import (
 	"testing"
 
 	"github.com/andrewhowdencom/x40.link/server"
+	"github.com/andrewhowdencom/x40.link/storage"
+	"github.com/andrewhowdencom/x40.link/storage/memory"
 	"github.com/andrewhowdencom/x40.link/storage/test"
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
@@ -104,3 +106,136 @@ func TestNewServer_WithStorage(t *testing.T) {
 	assert.Equal(t, http.StatusTemporaryRedirect, w.Result().StatusCode)
 	assert.Equal(t, "//test/bar", w.Header().Get("Location"))
 }
+
+func TestNewServer_WithDefaultStatus(t *testing.T) {
+	t.Parallel()
+
+	str := test.New()
+	assert.Nil(t, str.Put(context.Background(), &url.URL{Host: "test", Path: "/foo"}, &url.URL{Host: "test", Path: "/bar"}))
+
+	srv, err := server.New(server.WithStorage(str, server.WithDefaultStatus(http.StatusMovedPermanently)))
+	assert.Nil(t, err)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/foo", nil)
+	req.Host = "test"
+
+	srv.Handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusMovedPermanently, w.Result().StatusCode)
+
+	// Status codes that are not redirects are rejected.
+	_, err = server.New(server.WithStorage(str, server.WithDefaultStatus(http.StatusOK)))
+	assert.ErrorIs(t, err, server.ErrFailedToApplyOption)
+}
+
+func TestNewServer_WithQuery(t *testing.T) {
+	t.Parallel()
+
+	str := test.New()
+	assert.Nil(t, str.Put(context.Background(), &url.URL{Host: "test", Path: "/foo"}, &url.URL{Host: "test", Path: "/bar", RawQuery: "ref=x40"}))
+	assert.Nil(t, str.PutLink(context.Background(), &storage.Link{
+		From:  &url.URL{Host: "test", Path: "/drop"},
+		To:    &url.URL{Host: "test", Path: "/bar"},
+		Query: storage.QueryDrop,
+	}))
+
+	srv, err := server.New(server.WithStorage(str,
+		server.WithDefaultQuery(storage.QueryMerge),
+		server.WithQueryBlocklist("token", "utm_*"),
+	))
+	assert.Nil(t, err)
+
+	for _, tc := range []struct {
+		target   string
+		expected string
+	}{
+		{target: "/foo", expected: "//test/bar?ref=x40"},
+		{target: "/foo?q=1&ref=mail&token=s3cr3t&utm_source=mail", expected: "//test/bar?ref=x40&q=1"},
+		{target: "/drop?q=1", expected: "//test/bar"},
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, tc.target, nil)
+		req.Host = "test"
+
+		srv.Handler.ServeHTTP(w, req)
+
+		assert.Equal(t, tc.expected, w.Header().Get("Location"), tc.target)
+	}
+
+	// Policies that are not supported, or that defer to the default, are rejected.
+	for _, p := range []storage.QueryPolicy{storage.QueryDefault, "keep"} {
+		_, err = server.New(server.WithStorage(str, server.WithDefaultQuery(p)))
+		assert.ErrorIs(t, err, server.ErrFailedToApplyOption)
+	}
+}
+
+func TestNewServer_WithTrailingSlash(t *testing.T) {
+	t.Parallel()
+
+	str := test.New()
+	assert.Nil(t, str.Put(context.Background(), &url.URL{Host: "test", Path: "/foo"}, &url.URL{Host: "test", Path: "/bar"}))
+
+	for _, tc := range []struct {
+		policy   storage.SlashPolicy
+		host     string
+		target   string
+		expected int
+	}{
+		{policy: storage.SlashKeep, host: "test", target: "/foo", expected: http.StatusTemporaryRedirect},
+		{policy: storage.SlashKeep, host: "TEST:80", target: "/foo", expected: http.StatusTemporaryRedirect},
+		{policy: storage.SlashKeep, host: "test", target: "/foo/", expected: http.StatusNotFound},
+		{policy: storage.SlashStrip, host: "test", target: "/foo/", expected: http.StatusTemporaryRedirect},
+	} {
+		srv, err := server.New(server.WithStorage(str, server.WithTrailingSlash(tc.policy)))
+		assert.Nil(t, err)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, tc.target, nil)
+		req.Host = tc.host
+
+		srv.Handler.ServeHTTP(w, req)
+
+		assert.Equal(t, tc.expected, w.Result().StatusCode, "%s %s%s", tc.policy, tc.host, tc.target)
+	}
+
+	// Policies that are not supported are rejected.
+	_, err := server.New(server.WithStorage(str, server.WithTrailingSlash("redirect")))
+	assert.ErrorIs(t, err, server.ErrFailedToApplyOption)
+}
+
+func TestNewServer_WithCaseInsensitiveHosts(t *testing.T) {
+	t.Parallel()
+
+	str := memory.NewHashTable()
+	for _, host := range []string{"folded", "exact"} {
+		assert.Nil(t, str.Put(context.Background(), &url.URL{Host: host, Path: "/AbC"}, &url.URL{Host: host, Path: "/bar"}))
+	}
+
+	assert.Nil(t, str.Put(context.Background(), &url.URL{Host: "folded", Path: "/abc"}, &url.URL{Host: "folded", Path: "/lower"}))
+
+	srv, err := server.New(server.WithStorage(str, server.WithCaseInsensitiveHosts("folded")))
+	assert.Nil(t, err)
+
+	for _, tc := range []struct {
+		host     string
+		target   string
+		status   int
+		expected string
+	}{
+		{host: "folded", target: "/AbC", status: http.StatusTemporaryRedirect, expected: "//folded/bar"},
+		{host: "folded", target: "/ABC", status: http.StatusTemporaryRedirect, expected: "//folded/bar"},
+		{host: "folded", target: "/abc", status: http.StatusTemporaryRedirect, expected: "//folded/lower"},
+		{host: "exact", target: "/AbC", status: http.StatusTemporaryRedirect, expected: "//exact/bar"},
+		{host: "exact", target: "/abc", status: http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, tc.target, nil)
+		req.Host = tc.host
+
+		srv.Handler.ServeHTTP(w, req)
+
+		assert.Equal(t, tc.status, w.Result().StatusCode, "%s%s", tc.host, tc.target)
+		assert.Equal(t, tc.expected, w.Header().Get("Location"), "%s%s", tc.host, tc.target)
+	}
+}
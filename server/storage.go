@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/andrewhowdencom/x40.link/storage"
 	"schneider.vip/problem"
@@ -11,16 +12,45 @@ import (
 
 type strHandler struct {
 	str storage.Storer
+
+	// status is the HTTP status code used to redirect links that do not specify their own.
+	status int
+
+	// query is how the query string is forwarded to the destination of links that do not specify.
+	query storage.QueryPolicy
+
+	// blocklist is the query parameters that are never forwarded. See storage.QueryPolicy.Forward.
+	blocklist []string
+
+	// slash is what is done with the trailing slash of the URL before it is looked up.
+	slash storage.SlashPolicy
+
+	// folded is the hosts on which links are matched without regard to case.
+	folded storage.FoldedHosts
 }
 
 // Redirect receives a request, and if it matches a storage, responds.
 func (o *strHandler) Redirect(w http.ResponseWriter, r *http.Request) {
-	lookup := &url.URL{
+	lookup := storage.Normalize(&url.URL{
 		Host: r.Host,
 		Path: r.URL.Path,
+	}, storage.WithTrailingSlash(o.slash))
+
+	l, err := storage.GetLink(r.Context(), o.str, lookup)
+
+	// Where there is no link at the URL itself, it may differ from one only by case, match a template or, failing that,
+	// be beneath a prefix link.
+	if errors.Is(err, storage.ErrNotFound) && o.folded.Contains(lookup) {
+		l, err = storage.GetFolded(r.Context(), o.str, lookup)
 	}
 
-	red, err := o.str.Get(r.Context(), lookup)
+	if errors.Is(err, storage.ErrNotFound) {
+		l, err = storage.GetTemplate(r.Context(), o.str, lookup)
+	}
+
+	if errors.Is(err, storage.ErrNotFound) {
+		l, err = storage.GetPrefix(r.Context(), o.str, lookup)
+	}
 
 	if errors.Is(err, storage.ErrNotFound) {
 		WithError(r, problem.New(
@@ -32,9 +62,29 @@ func (o *strHandler) Redirect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err == nil && l.Expired(time.Now()) {
+		WithError(r, problem.New(
+			problem.Status(http.StatusGone),
+			problem.Custom("url", lookup.String()),
+			problem.Custom("expired", l.Expires.Format(time.RFC3339)),
+		))
+
+		return
+	}
+
 	if err == nil {
-		w.Header().Add("Location", red.String())
-		w.WriteHeader(http.StatusTemporaryRedirect)
+		code := l.Status
+		if code == 0 {
+			code = o.status
+		}
+
+		policy := l.Query
+		if policy == storage.QueryDefault {
+			policy = o.query
+		}
+
+		w.Header().Add("Location", policy.Forward(l.Destination(lookup), r.URL.RawQuery, o.blocklist).String())
+		w.WriteHeader(code)
 		return
 	}
 
@@ -7,8 +7,10 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/andrewhowdencom/x40.link/storage"
+	"github.com/andrewhowdencom/x40.link/storage/memory"
 	"github.com/andrewhowdencom/x40.link/storage/test"
 	"github.com/stretchr/testify/assert"
 	"schneider.vip/problem"
@@ -56,6 +58,263 @@ func TestStoreHandler_Get(t *testing.T) {
 			},
 			err: nil,
 		},
+		{
+			name: "everything ok, record specifies status",
+
+			req: &http.Request{
+				Host: "s3k",
+				URL: &url.URL{
+					Path: "/foo",
+				},
+			},
+			storage: func() storage.Storer {
+				str := test.New()
+				test.Must(str.PutLink(context.Background(), &storage.Link{
+					From:   &url.URL{Host: "s3k", Path: "/foo"},
+					To:     &url.URL{Scheme: "https", Host: "andrewhowden.com", Path: "/"},
+					Status: http.StatusPermanentRedirect,
+				}))
+
+				return str
+			}(),
+
+			statusCode: http.StatusPermanentRedirect,
+			headers: http.Header{
+				"Location": []string{"https://andrewhowden.com/"},
+			},
+			err: nil,
+		},
+		{
+			name: "prefix, path passed through",
+
+			req: &http.Request{
+				Host: "s3k",
+				URL: &url.URL{
+					Path: "/docs/guide/intro",
+				},
+			},
+			storage: func() storage.Storer {
+				str := test.New()
+				test.Must(str.PutLink(context.Background(), &storage.Link{
+					From:   &url.URL{Host: "s3k", Path: "/docs"},
+					To:     &url.URL{Scheme: "https", Host: "docs.andrewhowden.com"},
+					Prefix: true,
+				}))
+				test.Must(str.PutLink(context.Background(), &storage.Link{
+					From:   &url.URL{Host: "s3k", Path: "/docs/api"},
+					To:     &url.URL{Scheme: "https", Host: "api.andrewhowden.com", Path: "/v1/"},
+					Prefix: true,
+				}))
+
+				return str
+			}(),
+
+			statusCode: http.StatusTemporaryRedirect,
+			headers: http.Header{
+				"Location": []string{"https://docs.andrewhowden.com/guide/intro"},
+			},
+			err: nil,
+		},
+		{
+			name: "prefix, link itself",
+
+			req: &http.Request{
+				Host: "s3k",
+				URL: &url.URL{
+					Path: "/docs",
+				},
+			},
+			storage: func() storage.Storer {
+				str := test.New()
+				test.Must(str.PutLink(context.Background(), &storage.Link{
+					From:   &url.URL{Host: "s3k", Path: "/docs"},
+					To:     &url.URL{Scheme: "https", Host: "docs.andrewhowden.com"},
+					Prefix: true,
+				}))
+				test.Must(str.PutLink(context.Background(), &storage.Link{
+					From:   &url.URL{Host: "s3k", Path: "/docs/api"},
+					To:     &url.URL{Scheme: "https", Host: "api.andrewhowden.com", Path: "/v1/"},
+					Prefix: true,
+				}))
+
+				return str
+			}(),
+
+			statusCode: http.StatusTemporaryRedirect,
+			headers: http.Header{
+				"Location": []string{"https://docs.andrewhowden.com"},
+			},
+			err: nil,
+		},
+		{
+			name: "prefix, longest wins",
+
+			req: &http.Request{
+				Host: "s3k",
+				URL: &url.URL{
+					Path: "/docs/api/users",
+				},
+			},
+			storage: func() storage.Storer {
+				str := test.New()
+				test.Must(str.PutLink(context.Background(), &storage.Link{
+					From:   &url.URL{Host: "s3k", Path: "/docs"},
+					To:     &url.URL{Scheme: "https", Host: "docs.andrewhowden.com"},
+					Prefix: true,
+				}))
+				test.Must(str.PutLink(context.Background(), &storage.Link{
+					From:   &url.URL{Host: "s3k", Path: "/docs/api"},
+					To:     &url.URL{Scheme: "https", Host: "api.andrewhowden.com", Path: "/v1/"},
+					Prefix: true,
+				}))
+
+				return str
+			}(),
+
+			statusCode: http.StatusTemporaryRedirect,
+			headers: http.Header{
+				"Location": []string{"https://api.andrewhowden.com/v1/users"},
+			},
+			err: nil,
+		},
+		{
+			name: "prefix, not on a path boundary",
+
+			req: &http.Request{
+				Host: "s3k",
+				URL: &url.URL{
+					Path: "/docsfoo",
+				},
+			},
+			storage: func() storage.Storer {
+				str := test.New()
+				test.Must(str.PutLink(context.Background(), &storage.Link{
+					From:   &url.URL{Host: "s3k", Path: "/docs"},
+					To:     &url.URL{Scheme: "https", Host: "docs.andrewhowden.com"},
+					Prefix: true,
+				}))
+				test.Must(str.PutLink(context.Background(), &storage.Link{
+					From:   &url.URL{Host: "s3k", Path: "/docs/api"},
+					To:     &url.URL{Scheme: "https", Host: "api.andrewhowden.com", Path: "/v1/"},
+					Prefix: true,
+				}))
+
+				return str
+			}(),
+			headers: http.Header{},
+			err: problem.New(
+				problem.Status(http.StatusNotFound),
+				problem.Custom("url", "//s3k/docsfoo"),
+			),
+		},
+		{
+			name: "template, segment substituted",
+
+			req: &http.Request{
+				Host: "s3k",
+				URL: &url.URL{
+					Path: "/gh/dotfiles",
+				},
+			},
+			storage: func() storage.Storer {
+				str := memory.NewHashTable()
+				test.Must(str.Put(
+					context.Background(),
+					&url.URL{Host: "s3k", Path: "/gh/{repo}"},
+					&url.URL{Scheme: "https", Host: "github.com", Path: "/andrewhowdencom/{repo}"},
+				))
+				test.Must(str.Put(
+					context.Background(),
+					&url.URL{Host: "s3k", Path: "/gh/x40.link"},
+					&url.URL{Scheme: "https", Host: "x40.link"},
+				))
+				test.Must(str.PutLink(context.Background(), &storage.Link{
+					From:   &url.URL{Host: "s3k", Path: "/gh"},
+					To:     &url.URL{Scheme: "https", Host: "github.com"},
+					Prefix: true,
+				}))
+
+				return str
+			}(),
+
+			statusCode: http.StatusTemporaryRedirect,
+			headers: http.Header{
+				"Location": []string{"https://github.com/andrewhowdencom/dotfiles"},
+			},
+			err: nil,
+		},
+		{
+			name: "template, exact link wins",
+
+			req: &http.Request{
+				Host: "s3k",
+				URL: &url.URL{
+					Path: "/gh/x40.link",
+				},
+			},
+			storage: func() storage.Storer {
+				str := memory.NewHashTable()
+				test.Must(str.Put(
+					context.Background(),
+					&url.URL{Host: "s3k", Path: "/gh/{repo}"},
+					&url.URL{Scheme: "https", Host: "github.com", Path: "/andrewhowdencom/{repo}"},
+				))
+				test.Must(str.Put(
+					context.Background(),
+					&url.URL{Host: "s3k", Path: "/gh/x40.link"},
+					&url.URL{Scheme: "https", Host: "x40.link"},
+				))
+				test.Must(str.PutLink(context.Background(), &storage.Link{
+					From:   &url.URL{Host: "s3k", Path: "/gh"},
+					To:     &url.URL{Scheme: "https", Host: "github.com"},
+					Prefix: true,
+				}))
+
+				return str
+			}(),
+
+			statusCode: http.StatusTemporaryRedirect,
+			headers: http.Header{
+				"Location": []string{"https://x40.link"},
+			},
+			err: nil,
+		},
+		{
+			name: "template, prefix where no template matches",
+
+			req: &http.Request{
+				Host: "s3k",
+				URL: &url.URL{
+					Path: "/gh/dotfiles/issues",
+				},
+			},
+			storage: func() storage.Storer {
+				str := memory.NewHashTable()
+				test.Must(str.Put(
+					context.Background(),
+					&url.URL{Host: "s3k", Path: "/gh/{repo}"},
+					&url.URL{Scheme: "https", Host: "github.com", Path: "/andrewhowdencom/{repo}"},
+				))
+				test.Must(str.Put(
+					context.Background(),
+					&url.URL{Host: "s3k", Path: "/gh/x40.link"},
+					&url.URL{Scheme: "https", Host: "x40.link"},
+				))
+				test.Must(str.PutLink(context.Background(), &storage.Link{
+					From:   &url.URL{Host: "s3k", Path: "/gh"},
+					To:     &url.URL{Scheme: "https", Host: "github.com"},
+					Prefix: true,
+				}))
+
+				return str
+			}(),
+
+			statusCode: http.StatusTemporaryRedirect,
+			headers: http.Header{
+				"Location": []string{"https://github.com/dotfiles/issues"},
+			},
+			err: nil,
+		},
 		{
 			name: "record missing",
 
@@ -72,6 +331,32 @@ func TestStoreHandler_Get(t *testing.T) {
 				problem.Custom("url", "//s3k/foo"),
 			),
 		},
+		{
+			name: "record expired",
+
+			req: &http.Request{
+				Host: "s3k",
+				URL: &url.URL{
+					Path: "/foo",
+				},
+			},
+			storage: func() storage.Storer {
+				str := test.New()
+				test.Must(str.PutLink(context.Background(), &storage.Link{
+					From:    &url.URL{Host: "s3k", Path: "/foo"},
+					To:      &url.URL{Scheme: "https", Host: "andrewhowden.com", Path: "/"},
+					Expires: time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC),
+				}))
+
+				return str
+			}(),
+			headers: http.Header{},
+			err: problem.New(
+				problem.Status(http.StatusGone),
+				problem.Custom("url", "//s3k/foo"),
+				problem.Custom("expired", "2020-01-01T00:00:00Z"),
+			),
+		},
 		{
 			name: "storage failure",
 
@@ -96,7 +381,7 @@ func TestStoreHandler_Get(t *testing.T) {
 			// Bootstrap
 			w := httptest.NewRecorder()
 
-			handler := &strHandler{str: tc.storage}
+			handler := &strHandler{str: tc.storage, status: http.StatusTemporaryRedirect}
 
 			handler.Redirect(w, tc.req)
 
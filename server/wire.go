@@ -7,8 +7,10 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/andrewhowdencom/x40.link/api/auth/jwts"
 	apidi "github.com/andrewhowdencom/x40.link/api/di"
 	"github.com/andrewhowdencom/x40.link/cfg"
+	"github.com/andrewhowdencom/x40.link/storage"
 	strdi "github.com/andrewhowdencom/x40.link/storage/di"
 	"github.com/google/wire"
 )
@@ -29,20 +31,37 @@ func ResolveOptions() ([]Option, error) {
 		opts = append(opts, WithH2C())
 	}
 
-	server, err := apidi.WireGRPCServer()
+	// The storage is generated once, and shared by the API and the redirects.
+	str, err := strdi.WireStorage()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDependencyFailure, err)
+	}
+
+	server, err := apidi.WireGRPCServer(str)
 	if err != nil && !errors.Is(err, cfg.ErrMissingOptions) {
 		return nil, ErrDependencyFailure
 	} else if err == nil {
 		opts = append(opts, WithGRPC(cfg.ServerAPIGRPCHost.Value(), server))
 	}
 
-	storage, err := strdi.WireStorage()
-	if err != nil {
+	opts = append(opts, WithStorage(str,
+		WithDefaultStatus(cfg.ServerRedirectStatus.Value()),
+		WithDefaultQuery(storage.QueryPolicy(cfg.ServerRedirectQuery.Value())),
+		WithQueryBlocklist(cfg.ServerRedirectQueryBlocklist.List()...),
+		WithTrailingSlash(storage.SlashPolicy(cfg.ServerTrailingSlash.Value())),
+		WithCaseInsensitiveHosts(cfg.ServerCaseInsensitiveHosts.List()...),
+	))
+
+	// Backups are only served where there is authentication to restrict who is able to take them.
+	icept, err := jwts.WireServerInterceptor()
+	if err != nil && !errors.Is(err, cfg.ErrMissingOptions) {
 		return nil, fmt.Errorf("%w: %s", ErrDependencyFailure, err)
+	} else if err == nil {
+		if b, ok := storage.AsBackuper(str); ok {
+			opts = append(opts, WithBackup(b, icept))
+		}
 	}
 
-	opts = append(opts, WithStorage(storage))
-
 	return opts, nil
 }
 
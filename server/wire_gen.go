@@ -9,8 +9,10 @@ package server
 import (
 	"errors"
 	"fmt"
+	"github.com/andrewhowdencom/x40.link/api/auth/jwts"
 	"github.com/andrewhowdencom/x40.link/api/di"
 	"github.com/andrewhowdencom/x40.link/cfg"
+	"github.com/andrewhowdencom/x40.link/storage"
 	di2 "github.com/andrewhowdencom/x40.link/storage/di"
 	"net/http"
 )
@@ -47,19 +49,36 @@ func ResolveOptions() ([]Option, error) {
 		opts = append(opts, WithH2C())
 	}
 
-	server, err := di.WireGRPCServer()
+	// The storage is generated once, and shared by the API and the redirects.
+	str, err := di2.WireStorage()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDependencyFailure, err)
+	}
+
+	server, err := di.WireGRPCServer(str)
 	if err != nil && !errors.Is(err, cfg.ErrMissingOptions) {
 		return nil, ErrDependencyFailure
 	} else if err == nil {
 		opts = append(opts, WithGRPC(cfg.ServerAPIGRPCHost.Value(), server))
 	}
 
-	storage, err := di2.WireStorage()
-	if err != nil {
+	opts = append(opts, WithStorage(str,
+		WithDefaultStatus(cfg.ServerRedirectStatus.Value()),
+		WithDefaultQuery(storage.QueryPolicy(cfg.ServerRedirectQuery.Value())),
+		WithQueryBlocklist(cfg.ServerRedirectQueryBlocklist.List()...),
+		WithTrailingSlash(storage.SlashPolicy(cfg.ServerTrailingSlash.Value())),
+		WithCaseInsensitiveHosts(cfg.ServerCaseInsensitiveHosts.List()...),
+	))
+
+	// Backups are only served where there is authentication to restrict who is able to take them.
+	icept, err := jwts.WireServerInterceptor()
+	if err != nil && !errors.Is(err, cfg.ErrMissingOptions) {
 		return nil, fmt.Errorf("%w: %s", ErrDependencyFailure, err)
+	} else if err == nil {
+		if b, ok := storage.AsBackuper(str); ok {
+			opts = append(opts, WithBackup(b, icept))
+		}
 	}
 
-	opts = append(opts, WithStorage(storage))
-
 	return opts, nil
 }
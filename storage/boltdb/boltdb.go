@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/url"
 	"time"
 
@@ -17,6 +18,7 @@ var (
 	ErrFailedToSetupDatabase = errors.New("failed to setup backing database")
 	ErrFailedToTX            = errors.New("failed to complete database transaction")
 	ErrDataCorrupt           = errors.New("data returned from the database corrupted")
+	ErrSchemaTooNew          = errors.New("database schema is newer than supported")
 
 	txBucketName = []byte("short-links")
 )
@@ -57,6 +59,12 @@ func New(path string, opts ...Option) (*BoltDB, error) {
 		return nil, fmt.Errorf("%w: %s", ErrFailedToSetupDatabase, err)
 	}
 
+	if err := migrate(n); err != nil {
+		_ = n.Close()
+
+		return nil, fmt.Errorf("%w: %w", ErrFailedToSetupDatabase, err)
+	}
+
 	return &BoltDB{
 		db: n,
 	}, nil
@@ -73,8 +81,20 @@ func WithFileLockWait(dur time.Duration) Option {
 }
 
 // Get returns a URL, given another input URL
-func (b *BoltDB) Get(_ context.Context, in *url.URL) (*url.URL, error) {
-	var u *url.URL
+func (b *BoltDB) Get(ctx context.Context, in *url.URL) (*url.URL, error) {
+	l, err := b.GetLink(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.To, nil
+}
+
+// GetLink returns a link, complete with its metadata, given the input URL
+func (b *BoltDB) GetLink(_ context.Context, in *url.URL) (*storage.Link, error) {
+	in = storage.Normalize(in)
+
+	var l *storage.Link
 
 	if err := b.db.View(func(tx *bbolt.Tx) error {
 		// If there's no bucket created, no put operations can have been run. Ergo, the key cannot exist.
@@ -89,31 +109,382 @@ func (b *BoltDB) Get(_ context.Context, in *url.URL) (*url.URL, error) {
 		}
 
 		var err error
-		u, err = url.Parse(string(v))
+		l, err = decode(in, v)
+
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// GetFolded returns a link whose URL differs from the input URL only by case, according to the index of folded URLs.
+// See storage.Folder.
+func (b *BoltDB) GetFolded(_ context.Context, in *url.URL) (*storage.Link, error) {
+	in = storage.Normalize(in)
+
+	var l *storage.Link
+
+	if err := b.db.View(func(tx *bbolt.Tx) error {
+		k := folded(tx, in)
+		if k == nil {
+			return storage.ErrNotFound
+		}
+
+		// The index is written in the same transaction as the links, so a link in the index is also in the bucket.
+		b := tx.Bucket(txBucketName)
+		if b == nil {
+			return ErrDataCorrupt
+		}
+
+		v := b.Get(k)
+		if v == nil {
+			return ErrDataCorrupt
+		}
+
+		from, err := url.Parse(string(k))
 		if err != nil {
 			return ErrDataCorrupt
 		}
 
-		return nil
+		l, err = decode(from, v)
+
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Templates returns the templates on the host, according to the index of templates. See storage.Templater.
+func (b *BoltDB) Templates(_ context.Context, host string) ([]*storage.Link, error) {
+	found := []*storage.Link{}
+
+	if err := b.db.View(func(tx *bbolt.Tx) error {
+		t := templates(tx, host)
+		if t == nil {
+			return nil
+		}
+
+		// The index is written in the same transaction as the links, so a link in the index is also in the bucket.
+		b := tx.Bucket(txBucketName)
+		if b == nil {
+			return ErrDataCorrupt
+		}
+
+		return t.ForEach(func(k, _ []byte) error {
+			v := b.Get(k)
+			if v == nil {
+				return ErrDataCorrupt
+			}
+
+			from, err := url.Parse(string(k))
+			if err != nil {
+				return ErrDataCorrupt
+			}
+
+			l, err := decode(from, v)
+			if err != nil {
+				return err
+			}
+
+			found = append(found, l)
+
+			return nil
+		})
 	}); err != nil {
 		return nil, err
 	}
 
-	return u, nil
+	return found, nil
 }
 
 // Put saves a URL to the datastore
-func (b *BoltDB) Put(_ context.Context, f *url.URL, t *url.URL) error {
+func (b *BoltDB) Put(ctx context.Context, f *url.URL, t *url.URL) error {
+	return b.PutLink(ctx, &storage.Link{From: f, To: t})
+}
+
+// PutLink saves a link, complete with its metadata, to the datastore
+func (b *BoltDB) PutLink(ctx context.Context, l *storage.Link) error {
+	l = storage.NormalizeLink(l)
+
 	return b.db.Update(func(tx *bbolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists(txBucketName)
 		if err != nil {
 			return fmt.Errorf("%w: %s", ErrFailedToTX, err)
 		}
 
-		if err := b.Put([]byte(f.String()), []byte(t.String())); err != nil {
+		// The existing record is required to check that it is owned by the agent, to retain the time at which it
+		// was created, and to remove it from the index of its owner.
+		var existing *storage.Link
+		if v := b.Get([]byte(l.From.String())); v != nil {
+			existing, err = decode(l.From, v)
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := storage.Authorize(ctx, existing); err != nil {
+			return err
+		}
+
+		return put(tx, b, existing, storage.Stamp(ctx, l, existing))
+	})
+}
+
+// Create saves a URL to the datastore, but only if there is not already a URL stored at that address. The check and
+// the write happen in the same transaction, so there is no opportunity for another writer to race between them.
+func (b *BoltDB) Create(ctx context.Context, l *storage.Link) error {
+	return b.create(ctx, l, false)
+}
+
+// CreateFolded saves a URL to the datastore, but only if there is neither a URL at that address, nor one that differs
+// from it only by case. The index of folded URLs is checked in the same transaction as the link is written. See
+// storage.FoldedCreator.
+func (b *BoltDB) CreateFolded(ctx context.Context, l *storage.Link) error {
+	return b.create(ctx, l, true)
+}
+
+// create saves a URL to the datastore, but only if there is not already a URL at that address, nor (where folded) one
+// that differs from it only by case.
+func (b *BoltDB) create(ctx context.Context, l *storage.Link, fold bool) error {
+	l = storage.NormalizeLink(l)
+
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(txBucketName)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrFailedToTX, err)
+		}
+
+		if v := b.Get([]byte(l.From.String())); v != nil {
+			return storage.ErrAlreadyExists
+		}
+
+		if fold && folded(tx, l.From) != nil {
+			return storage.ErrCaseCollision
+		}
+
+		return put(tx, b, nil, storage.Stamp(ctx, l, nil))
+	})
+}
+
+// put encodes the link and writes it to the bucket, replacing the existing link (if there is one) in the index of
+// owners.
+func put(tx *bbolt.Tx, b *bbolt.Bucket, existing *storage.Link, l *storage.Link) error {
+	v, err := encode(l)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrFailedToTX, err)
+	}
+
+	if err := b.Put([]byte(l.From.String()), v); err != nil {
+		return fmt.Errorf("%w: %s", ErrFailedToTX, err)
+	}
+
+	if err := index(tx, existing, l); err != nil {
+		return fmt.Errorf("%w: %s", ErrFailedToTX, err)
+	}
+
+	return nil
+}
+
+// Delete removes a URL from the datastore. Only the owner of the link is able to remove it.
+func (b *BoltDB) Delete(ctx context.Context, in *url.URL) error {
+	in = storage.Normalize(in)
+
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		// As with Get, if there is no bucket there cannot be a record to delete.
+		b := tx.Bucket(txBucketName)
+		if b == nil {
+			return storage.ErrNotFound
+		}
+
+		v := b.Get([]byte(in.String()))
+		if v == nil {
+			return storage.ErrNotFound
+		}
+
+		existing, err := decode(in, v)
+		if err != nil {
+			return err
+		}
+
+		if err := storage.Authorize(ctx, existing); err != nil {
+			return err
+		}
+
+		if err := b.Delete([]byte(in.String())); err != nil {
+			return fmt.Errorf("%w: %s", ErrFailedToTX, err)
+		}
+
+		if err := unindex(tx, existing); err != nil {
 			return fmt.Errorf("%w: %s", ErrFailedToTX, err)
 		}
 
 		return nil
 	})
 }
+
+// Purge removes the links in the datastore that have expired. The whole bucket is scanned, as there is (as yet) no
+// index by expiry.
+func (b *BoltDB) Purge(_ context.Context, before time.Time) (int, error) {
+	n := 0
+
+	if err := b.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(txBucketName)
+		if b == nil {
+			return nil
+		}
+
+		// Deleting keys while iterating with a cursor can cause the cursor to skip keys, so the expired keys are
+		// first collected, and then deleted.
+		expired := []*storage.Link{}
+		if err := b.ForEach(func(k, v []byte) error {
+			from, err := url.Parse(string(k))
+			if err != nil {
+				return ErrDataCorrupt
+			}
+
+			l, err := decode(from, v)
+			if err != nil {
+				return err
+			}
+
+			if l.Expired(before) {
+				expired = append(expired, l)
+			}
+
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, l := range expired {
+			if err := b.Delete([]byte(l.From.String())); err != nil {
+				return fmt.Errorf("%w: %s", ErrFailedToTX, err)
+			}
+
+			if err := unindex(tx, l); err != nil {
+				return fmt.Errorf("%w: %s", ErrFailedToTX, err)
+			}
+		}
+
+		n = len(expired)
+
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// Owns validates whether the agent in the context owns the link, according to the index of owners.
+func (b *BoltDB) Owns(ctx context.Context, u *url.URL) bool {
+	u = storage.Normalize(u)
+
+	agent, ok := ctx.Value(storage.CtxKeyAgent).(string)
+	if !ok || agent == "" {
+		return false
+	}
+
+	var found bool
+
+	_ = b.db.View(func(tx *bbolt.Tx) error {
+		found = owns(tx, agent, u)
+
+		return nil
+	})
+
+	return found
+}
+
+// Backup writes a consistent snapshot of the whole database to w. The snapshot is taken within a read transaction, so
+// writes are able to continue while it is being written. The snapshot is itself a BoltDB database, so can be restored
+// by starting with it in place of the original file.
+func (b *BoltDB) Backup(w io.Writer) (int64, error) {
+	var n int64
+
+	if err := b.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+
+		return err
+	}); err != nil {
+		return n, fmt.Errorf("%w: %s", ErrFailedToTX, err)
+	}
+
+	return n, nil
+}
+
+// List pages through the URLs in the datastore. BoltDB stores its keys in byte-sorted order, so the bucket cursor can
+// seek directly to where the previous page ended. Where the links are filtered by owner, the index of owners is used
+// in place of the links.
+func (b *BoltDB) List(_ context.Context, opts storage.ListOptions) (*storage.Page, error) {
+	page := &storage.Page{Links: []*storage.Link{}}
+
+	if err := b.db.View(func(tx *bbolt.Tx) error {
+		// If there's no bucket created, no put operations can have been run. Ergo, there is nothing to list.
+		b := tx.Bucket(txBucketName)
+		if b == nil {
+			return nil
+		}
+
+		// Where the links are filtered by owner, only the links in the index of that owner need to be read. The index
+		// is keyed by the link, so is in the same order as the links themselves.
+		keys := b
+		if opts.Owner != "" {
+			keys = owned(tx, opts.Owner)
+			if keys == nil {
+				return nil
+			}
+		}
+
+		c := keys.Cursor()
+
+		k, _ := c.First()
+		if opts.Cursor != "" {
+			k, _ = c.Seek([]byte(opts.Cursor))
+
+			// Seek positions the cursor at the key, if it (still) exists. The page starts after it.
+			if k != nil && string(k) == opts.Cursor {
+				k, _ = c.Next()
+			}
+		}
+
+		for ; k != nil; k, _ = c.Next() {
+			from, err := url.Parse(string(k))
+			if err != nil {
+				return ErrDataCorrupt
+			}
+
+			v := b.Get(k)
+			if v == nil {
+				return ErrDataCorrupt
+			}
+
+			l, err := decode(from, v)
+			if err != nil {
+				return err
+			}
+
+			if !opts.Matches(l) {
+				continue
+			}
+
+			if len(page.Links) == opts.Size() {
+				page.Next = page.Links[len(page.Links)-1].From.String()
+				break
+			}
+
+			page.Links = append(page.Links, l)
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
@@ -0,0 +1,299 @@
+package boltdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/andrewhowdencom/x40.link/storage"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/bbolt"
+)
+
+// legacy creates a database in the format written before the schema was versioned, in which the value was only the
+// destination URL.
+func legacy(t *testing.T, p string, values map[string]string) {
+	db, err := bbolt.Open(p, 0600, nil)
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(txBucketName)
+		if err != nil {
+			return err
+		}
+
+		for k, v := range values {
+			if err := b.Put([]byte(k), []byte(v)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}))
+
+	assert.Nil(t, db.Close())
+}
+
+// TestMigrations validates that databases written by earlier versions of the storage are upgraded when opened.
+func TestMigrations(t *testing.T) {
+	t.Parallel()
+
+	p := path.Join(t.TempDir(), "legacy.db")
+	legacy(t, p, map[string]string{
+		"//x40/foo": "https://andrewhowden.com/",
+		"//x40/bar": `{"to":"https://andrewhowden.com/bar","owner":"alice","created":"2024-01-01T00:00:00Z","updated":"2024-01-01T00:00:00Z"}`,
+	})
+
+	db, err := New(p)
+	assert.Nil(t, err)
+
+	foo := &url.URL{Host: "x40", Path: "/foo"}
+	bar := &url.URL{Host: "x40", Path: "/bar"}
+
+	assert.Nil(t, db.db.View(func(tx *bbolt.Tx) error {
+		// The schema version is recorded.
+		assert.Equal(t, uint64(len(migrations)), binary.BigEndian.Uint64(tx.Bucket(metaBucketName).Get(versionKey)))
+
+		// Values written as a plain URL are rewritten as records.
+		assert.Contains(t, string(tx.Bucket(txBucketName).Get([]byte("//x40/foo"))), `"to":"https://andrewhowden.com/"`)
+
+		// Links with an owner are indexed.
+		assert.NotNil(t, tx.Bucket(ownerBucketName).Bucket([]byte("alice")).Get([]byte("//x40/bar")))
+
+		return nil
+	}))
+
+	l, err := db.GetLink(context.Background(), foo)
+	assert.Nil(t, err)
+	assert.Equal(t, &url.URL{Scheme: "https", Host: "andrewhowden.com", Path: "/"}, l.To)
+	assert.Empty(t, l.Owner)
+
+	assert.True(t, db.Owns(context.WithValue(context.Background(), storage.CtxKeyAgent, "alice"), bar))
+	assert.False(t, db.Owns(context.WithValue(context.Background(), storage.CtxKeyAgent, "bob"), bar))
+	assert.False(t, db.Owns(context.Background(), foo))
+
+	// Opening an up to date database again changes nothing.
+	assert.Nil(t, db.db.Close())
+
+	db, err = New(p)
+	assert.Nil(t, err)
+
+	l, err = db.GetLink(context.Background(), bar)
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", l.Owner)
+}
+
+// TestMigrateNormalize validates that keys written before they were normalized are rewritten in their normalized form,
+// and that where several keys collide, the most recently updated link wins.
+func TestMigrateNormalize(t *testing.T) {
+	t.Parallel()
+
+	p := path.Join(t.TempDir(), "denormal.db")
+	legacy(t, p, map[string]string{
+		"//x40/already":      `{"to":"https://andrewhowden.com/already","created":"2024-01-01T00:00:00Z","updated":"2024-01-01T00:00:00Z"}`,
+		"//X40/Upper":        `{"to":"https://andrewhowden.com/upper","owner":"alice","created":"2024-01-01T00:00:00Z","updated":"2024-01-01T00:00:00Z"}`,
+		"//x40:443/collides": `{"to":"https://andrewhowden.com/newer","created":"2024-01-01T00:00:00Z","updated":"2024-06-01T00:00:00Z"}`,
+		"//X40/collides":     `{"to":"https://andrewhowden.com/older","owner":"bob","created":"2024-01-01T00:00:00Z","updated":"2024-03-01T00:00:00Z"}`,
+		"//x40/collides":     `{"to":"https://andrewhowden.com/oldest","created":"2024-01-01T00:00:00Z","updated":"2024-01-01T00:00:00Z"}`,
+	})
+
+	db, err := New(p)
+	assert.Nil(t, err)
+
+	keys := []string{}
+	assert.Nil(t, db.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(txBucketName).ForEach(func(k, _ []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	}))
+	assert.Equal(t, []string{"//x40/Upper", "//x40/already", "//x40/collides"}, keys)
+
+	for path, to := range map[string]string{
+		"/Upper":    "https://andrewhowden.com/upper",
+		"/already":  "https://andrewhowden.com/already",
+		"/collides": "https://andrewhowden.com/newer",
+	} {
+		res, err := db.Get(context.Background(), &url.URL{Host: "x40", Path: path})
+		assert.Nil(t, err, path)
+		assert.Equal(t, to, res.String(), path)
+	}
+
+	// The index follows the links that were moved, and forgets those that were dropped.
+	assert.True(t, db.Owns(context.WithValue(context.Background(), storage.CtxKeyAgent, "alice"), &url.URL{Host: "x40", Path: "/Upper"}))
+	assert.Nil(t, db.db.View(func(tx *bbolt.Tx) error {
+		assert.Nil(t, tx.Bucket(ownerBucketName).Bucket([]byte("bob")))
+		return nil
+	}))
+}
+
+// TestMigrateFolded validates that links written before they were indexed by their folded URL are added to the index.
+func TestMigrateFolded(t *testing.T) {
+	t.Parallel()
+
+	p := path.Join(t.TempDir(), "unfolded.db")
+	legacy(t, p, map[string]string{
+		"//x40/AbC": `{"to":"https://andrewhowden.com/abc","created":"2024-01-01T00:00:00Z","updated":"2024-01-01T00:00:00Z"}`,
+		"//x40/abd": `{"to":"https://andrewhowden.com/abd","created":"2024-01-01T00:00:00Z","updated":"2024-01-01T00:00:00Z"}`,
+	})
+
+	db, err := bbolt.Open(p, 0600, nil)
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Update(func(tx *bbolt.Tx) error {
+		assert.Nil(t, tx.Bucket(foldedBucketName))
+
+		return migrations[3](tx)
+	}))
+
+	assert.Nil(t, db.View(func(tx *bbolt.Tx) error {
+		assert.Equal(t, []byte("//x40/AbC"), folded(tx, &url.URL{Host: "x40", Path: "/ABC"}))
+		assert.Equal(t, []byte("//x40/abd"), folded(tx, &url.URL{Host: "x40", Path: "/AbD"}))
+		assert.Nil(t, folded(tx, &url.URL{Host: "x40", Path: "/abe"}))
+
+		return nil
+	}))
+
+	assert.Nil(t, db.Close())
+}
+
+// TestSchemaTooNew validates that databases written by a later version of the storage are not opened, rather than
+// risking misreading them.
+func TestSchemaTooNew(t *testing.T) {
+	t.Parallel()
+
+	p := path.Join(t.TempDir(), "future.db")
+
+	db, err := bbolt.Open(p, 0600, nil)
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(metaBucketName)
+		if err != nil {
+			return err
+		}
+
+		return b.Put(versionKey, binary.BigEndian.AppendUint64(nil, uint64(len(migrations)+1)))
+	}))
+	assert.Nil(t, db.Close())
+
+	_, err = New(p)
+	assert.ErrorIs(t, err, ErrSchemaTooNew)
+}
+
+// TestOwnerIndex validates that the index of owners follows the links as they're written, replaced and removed.
+func TestOwnerIndex(t *testing.T) {
+	t.Parallel()
+
+	db, err := New(path.Join(t.TempDir(), "index.db"))
+	assert.Nil(t, err)
+
+	foo := &url.URL{Host: "x40", Path: "/foo"}
+	alice := context.WithValue(context.Background(), storage.CtxKeyAgent, "alice")
+
+	// Links without an owner are not indexed.
+	assert.Nil(t, db.Create(context.Background(), &storage.Link{From: foo, To: &url.URL{Host: "k3s"}}))
+	assert.False(t, db.Owns(alice, foo))
+
+	// Without an agent, the owner is taken from the link (e.g. as it is imported).
+	assert.Nil(t, db.PutLink(context.Background(), &storage.Link{From: foo, To: &url.URL{Host: "k3s"}, Owner: "alice"}))
+	assert.True(t, db.Owns(alice, foo))
+
+	page, err := db.List(context.Background(), storage.ListOptions{Owner: "alice"})
+	assert.Nil(t, err)
+	assert.Len(t, page.Links, 1)
+
+	// Deleting the link removes it from the index, along with the owner who no longer owns anything.
+	assert.Nil(t, db.Delete(alice, foo))
+	assert.False(t, db.Owns(alice, foo))
+
+	page, err = db.List(context.Background(), storage.ListOptions{Owner: "alice"})
+	assert.Nil(t, err)
+	assert.Empty(t, page.Links)
+
+	assert.Nil(t, db.db.View(func(tx *bbolt.Tx) error {
+		assert.Nil(t, tx.Bucket(ownerBucketName).Bucket([]byte("alice")))
+		return nil
+	}))
+}
+
+// TestIndexEmptyName validates that a link whose name in the index is empty (as is the folded URL of an empty URL) is
+// not indexed, rather than failing, as a bucket is not able to be named by the empty string.
+func TestIndexEmptyName(t *testing.T) {
+	t.Parallel()
+
+	db, err := New(path.Join(t.TempDir(), "index.db"))
+	assert.Nil(t, err)
+
+	l := &storage.Link{From: &url.URL{}, To: &url.URL{Host: "k3s"}}
+	assert.Equal(t, "", storage.Fold(l.From))
+
+	assert.Nil(t, db.db.Update(func(tx *bbolt.Tx) error {
+		if err := add(tx, foldedBucketName, storage.Fold(l.From), l); err != nil {
+			return err
+		}
+
+		assert.Nil(t, folded(tx, l.From))
+
+		return remove(tx, foldedBucketName, storage.Fold(l.From), l)
+	}))
+}
+
+// TestBackup validates that the snapshot is a database that can be opened in place of the original.
+func TestBackup(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	db, err := New(path.Join(dir, "original.db"))
+	assert.Nil(t, err)
+
+	from := &url.URL{Host: "x40", Path: "/foo"}
+	assert.Nil(t, db.Put(context.Background(), from, &url.URL{Host: "k3s", Path: "/bar"}))
+
+	buf := &bytes.Buffer{}
+	n, err := db.Backup(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+
+	// Writes after the snapshot are not part of it.
+	assert.Nil(t, db.Put(context.Background(), &url.URL{Host: "x40", Path: "/later"}, &url.URL{Host: "k3s"}))
+
+	assert.Nil(t, os.WriteFile(path.Join(dir, "restored.db"), buf.Bytes(), 0600))
+
+	restored, err := New(path.Join(dir, "restored.db"))
+	assert.Nil(t, err)
+
+	to, err := restored.Get(context.Background(), from)
+	assert.Nil(t, err)
+	assert.Equal(t, &url.URL{Host: "k3s", Path: "/bar"}, to)
+
+	_, err = restored.Get(context.Background(), &url.URL{Host: "x40", Path: "/later"})
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+// TestMigrateTemplates validates that templates written before they were indexed by their host are added to the
+// index, and that links that are not templates are not.
+func TestMigrateTemplates(t *testing.T) {
+	t.Parallel()
+
+	p := path.Join(t.TempDir(), "unindexed.db")
+	legacy(t, p, map[string]string{
+		"//x40/gh/%7Brepo%7D": `{"to":"https://github.com/%7Brepo%7D","created":"2024-01-01T00:00:00Z","updated":"2024-01-01T00:00:00Z"}`,
+		"//x40/gh":            `{"to":"https://github.com","created":"2024-01-01T00:00:00Z","updated":"2024-01-01T00:00:00Z"}`,
+	})
+
+	db, err := New(p)
+	assert.Nil(t, err)
+
+	found, err := db.Templates(context.Background(), "x40")
+	assert.Nil(t, err)
+	assert.Len(t, found, 1)
+	assert.Equal(t, "/gh/{repo}", found[0].From.Path)
+
+	assert.Nil(t, db.db.Close())
+}
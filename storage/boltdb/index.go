@@ -0,0 +1,155 @@
+package boltdb
+
+import (
+	"net/url"
+
+	"github.com/andrewhowdencom/x40.link/storage"
+	"go.etcd.io/bbolt"
+)
+
+// ownerBucketName is the bucket that indexes links by their owner. It holds a bucket for each owner, in which the keys
+// are the links that they own. Links without an owner are not indexed.
+var ownerBucketName = []byte("owners")
+
+// foldedBucketName is the bucket that indexes links by their folded URL (see storage.Fold), so that they are able to be
+// found without regard to case. It holds a bucket for each folded URL, in which the keys are the links that fold to it.
+var foldedBucketName = []byte("folded")
+
+// templateBucketName is the bucket that indexes link templates by their host (see storage.Templater), so that the
+// templates that a URL could match are found without scanning every link on the host. It holds a bucket for each host,
+// in which the keys are the templates on it. Links that are not templates are not indexed.
+var templateBucketName = []byte("templates")
+
+// index records the link against its owner, its folded URL and (if it is a template) its host, first removing the
+// existing link (if there is one) from the indexes. Expected to be called in the same transaction as the link is
+// written.
+func index(tx *bbolt.Tx, existing *storage.Link, l *storage.Link) error {
+	if existing != nil {
+		if err := unindex(tx, existing); err != nil {
+			return err
+		}
+	}
+
+	if err := add(tx, foldedBucketName, storage.Fold(l.From), l); err != nil {
+		return err
+	}
+
+	if l.IsTemplate() {
+		if err := add(tx, templateBucketName, l.From.Host, l); err != nil {
+			return err
+		}
+	}
+
+	if l.Owner == "" {
+		return nil
+	}
+
+	return add(tx, ownerBucketName, l.Owner, l)
+}
+
+// unindex removes the link from the indexes.
+func unindex(tx *bbolt.Tx, l *storage.Link) error {
+	if err := remove(tx, foldedBucketName, storage.Fold(l.From), l); err != nil {
+		return err
+	}
+
+	if err := remove(tx, templateBucketName, l.From.Host, l); err != nil {
+		return err
+	}
+
+	if l.Owner == "" {
+		return nil
+	}
+
+	return remove(tx, ownerBucketName, l.Owner, l)
+}
+
+// add records the link in the bucket of the index with the given name. A bucket is not able to be named by the empty
+// string, so links that would be indexed under it (such as a link with an empty URL, whose folded URL is empty) are
+// not indexed.
+func add(tx *bbolt.Tx, idx []byte, name string, l *storage.Link) error {
+	if name == "" {
+		return nil
+	}
+
+	i, err := tx.CreateBucketIfNotExists(idx)
+	if err != nil {
+		return err
+	}
+
+	b, err := i.CreateBucketIfNotExists([]byte(name))
+	if err != nil {
+		return err
+	}
+
+	return b.Put([]byte(l.From.String()), []byte{})
+}
+
+// remove removes the link from the bucket of the index with the given name. The bucket is removed with its last link,
+// so that the index does not accumulate (for example) owners who no longer own anything.
+func remove(tx *bbolt.Tx, idx []byte, name string, l *storage.Link) error {
+	i := tx.Bucket(idx)
+	if i == nil {
+		return nil
+	}
+
+	b := i.Bucket([]byte(name))
+	if b == nil {
+		return nil
+	}
+
+	if err := b.Delete([]byte(l.From.String())); err != nil {
+		return err
+	}
+
+	if k, _ := b.Cursor().First(); k == nil {
+		return i.DeleteBucket([]byte(name))
+	}
+
+	return nil
+}
+
+// folded returns the key of the first link whose URL folds to the same URL as the supplied URL, or nil if there is
+// none.
+func folded(tx *bbolt.Tx, u *url.URL) []byte {
+	i := tx.Bucket(foldedBucketName)
+	if i == nil {
+		return nil
+	}
+
+	b := i.Bucket([]byte(storage.Fold(u)))
+	if b == nil {
+		return nil
+	}
+
+	k, _ := b.Cursor().First()
+
+	return k
+}
+
+// templates returns the bucket of templates on the host, or nil if there are none.
+func templates(tx *bbolt.Tx, host string) *bbolt.Bucket {
+	i := tx.Bucket(templateBucketName)
+	if i == nil {
+		return nil
+	}
+
+	return i.Bucket([]byte(host))
+}
+
+// owned returns the bucket of links owned by the agent, or nil if they own nothing.
+func owned(tx *bbolt.Tx, agent string) *bbolt.Bucket {
+	owners := tx.Bucket(ownerBucketName)
+	if owners == nil || agent == "" {
+		return nil
+	}
+
+	return owners.Bucket([]byte(agent))
+}
+
+// owns indicates whether the agent owns the link, according to the index.
+func owns(tx *bbolt.Tx, agent string, u *url.URL) bool {
+	b := owned(tx, agent)
+
+	return b != nil && b.Get([]byte(u.String())) != nil
+}
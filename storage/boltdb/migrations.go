@@ -0,0 +1,319 @@
+package boltdb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/andrewhowdencom/x40.link/storage/frozen"
+	"go.etcd.io/bbolt"
+)
+
+var (
+	// metaBucketName is the bucket that describes the database itself, such as the version of its schema.
+	metaBucketName = []byte("meta")
+
+	// versionKey is the key in the meta bucket at which the schema version is stored.
+	versionKey = []byte("schema-version")
+)
+
+// migrations are the functions that bring the schema up to date, in the order in which they must be applied. The
+// schema version stored in the database is the number of migrations that have been applied to it. Databases created
+// before the schema was versioned have no version, and are treated as version 0.
+//
+// Migrations that have been released must never be modified; instead, append another. So that what a migration does
+// is not changed along with the rest of the storage, each works on the values as they were at its version, rather
+// than through the helpers (such as encode, or index) that read and write the values as they are now. Keys are derived
+// by the rules of the frozen package, rather than those of the storage package, for the same reason.
+var migrations = []func(tx *bbolt.Tx) error{
+	// 1: Earlier versions wrote only the destination URL as the value. Rewrite those values as records.
+	func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(txBucketName)
+		if err != nil {
+			return err
+		}
+
+		legacy := map[string][]byte{}
+		if err := b.ForEach(func(k, v []byte) error {
+			if len(v) > 0 && v[0] != '{' {
+				legacy[string(k)] = v
+			}
+
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		// The record as it was at this version.
+		type record struct {
+			To      string    `json:"to"`
+			Created time.Time `json:"created"`
+			Updated time.Time `json:"updated"`
+		}
+
+		for k, v := range legacy {
+			to, err := url.Parse(string(v))
+			if err != nil {
+				return fmt.Errorf("%w: %s", ErrDataCorrupt, k)
+			}
+
+			r, err := json.Marshal(record{To: to.String()})
+			if err != nil {
+				return err
+			}
+
+			if err := b.Put([]byte(k), r); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+
+	// 2: Index the links by their owner, so that ownership can be checked (and links listed by owner) without
+	// scanning every link.
+	func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(txBucketName)
+		if err != nil {
+			return err
+		}
+
+		owners, err := tx.CreateBucketIfNotExists(ownerBucketName)
+		if err != nil {
+			return err
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			r := struct {
+				Owner string `json:"owner"`
+			}{}
+
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("%w: %s", ErrDataCorrupt, k)
+			}
+
+			if r.Owner == "" {
+				return nil
+			}
+
+			o, err := owners.CreateBucketIfNotExists([]byte(r.Owner))
+			if err != nil {
+				return err
+			}
+
+			return o.Put(k, []byte{})
+		})
+	},
+
+	// 3: Keys were written as they were supplied, so the same link written in different ways (such as with the host in
+	// upper case, or with a default port) was stored more than once. Rewrite each key in its normalized form (see
+	// frozen.Normalize); where several keys normalize to the same one, the link most recently updated is kept.
+	func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(txBucketName)
+		if err != nil {
+			return err
+		}
+
+		owners, err := tx.CreateBucketIfNotExists(ownerBucketName)
+		if err != nil {
+			return err
+		}
+
+		// The parts of the record as it was at this version that are needed to move it.
+		type record struct {
+			Owner   string    `json:"owner"`
+			Updated time.Time `json:"updated"`
+		}
+
+		read := func(k, v []byte) (record, error) {
+			r := record{}
+			if err := json.Unmarshal(v, &r); err != nil {
+				return r, fmt.Errorf("%w: %s", ErrDataCorrupt, k)
+			}
+
+			return r, nil
+		}
+
+		// owned adds (or removes) the key from the index of the owner of the record.
+		owned := func(r record, k []byte, add bool) error {
+			if r.Owner == "" {
+				return nil
+			}
+
+			o, err := owners.CreateBucketIfNotExists([]byte(r.Owner))
+			if err != nil {
+				return err
+			}
+
+			if add {
+				return o.Put(k, []byte{})
+			}
+
+			if err := o.Delete(k); err != nil {
+				return err
+			}
+
+			if k, _ := o.Cursor().First(); k == nil {
+				return owners.DeleteBucket([]byte(r.Owner))
+			}
+
+			return nil
+		}
+
+		// The bucket is not able to be modified as it is iterated, so the links to move are collected first.
+		type move struct {
+			from, to string
+			v        []byte
+		}
+
+		moved := []move{}
+		if err := b.ForEach(func(k, v []byte) error {
+			from, err := url.Parse(string(k))
+			if err != nil {
+				return fmt.Errorf("%w: %s", ErrDataCorrupt, k)
+			}
+
+			if to := frozen.Normalize(from).String(); to != string(k) {
+				moved = append(moved, move{from: string(k), to: to, v: append([]byte{}, v...)})
+			}
+
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, m := range moved {
+			r, err := read([]byte(m.from), m.v)
+			if err != nil {
+				return err
+			}
+
+			if err := b.Delete([]byte(m.from)); err != nil {
+				return err
+			}
+
+			if err := owned(r, []byte(m.from), false); err != nil {
+				return err
+			}
+
+			if v := b.Get([]byte(m.to)); v != nil {
+				existing, err := read([]byte(m.to), v)
+				if err != nil {
+					return err
+				}
+
+				if existing.Updated.After(r.Updated) {
+					continue
+				}
+
+				if err := owned(existing, []byte(m.to), false); err != nil {
+					return err
+				}
+			}
+
+			if err := b.Put([]byte(m.to), m.v); err != nil {
+				return err
+			}
+
+			if err := owned(r, []byte(m.to), true); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+
+	// 4: Index the links by their folded URL, so that they are able to be found without regard to case (see
+	// storage.Folder, and frozen.Fold).
+	func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(txBucketName)
+		if err != nil {
+			return err
+		}
+
+		folded, err := tx.CreateBucketIfNotExists(foldedBucketName)
+		if err != nil {
+			return err
+		}
+
+		return b.ForEach(func(k, _ []byte) error {
+			from, err := url.Parse(string(k))
+			if err != nil {
+				return fmt.Errorf("%w: %s", ErrDataCorrupt, k)
+			}
+
+			f, err := folded.CreateBucketIfNotExists([]byte(frozen.Fold(from)))
+			if err != nil {
+				return err
+			}
+
+			return f.Put(k, []byte{})
+		})
+	},
+
+	// 5: Index the link templates by their host, so that the templates a URL could match are found without scanning
+	// the host (see storage.Templater, and frozen.IsTemplate).
+	func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(txBucketName)
+		if err != nil {
+			return err
+		}
+
+		hosts, err := tx.CreateBucketIfNotExists(templateBucketName)
+		if err != nil {
+			return err
+		}
+
+		return b.ForEach(func(k, _ []byte) error {
+			from, err := url.Parse(string(k))
+			if err != nil {
+				return fmt.Errorf("%w: %s", ErrDataCorrupt, k)
+			}
+
+			if !frozen.IsTemplate(from.Path) {
+				return nil
+			}
+
+			h, err := hosts.CreateBucketIfNotExists([]byte(from.Host))
+			if err != nil {
+				return err
+			}
+
+			return h.Put(k, []byte{})
+		})
+	},
+}
+
+// migrate applies the migrations that have not yet been applied to the database. The version is updated in the same
+// transaction as the migrations are applied, so that a failed migration leaves the database as it was.
+func migrate(db *bbolt.DB) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucketName)
+		if err != nil {
+			return err
+		}
+
+		var version uint64
+		if v := meta.Get(versionKey); v != nil {
+			if len(v) != 8 {
+				return fmt.Errorf("%w: %s", ErrDataCorrupt, "invalid schema version")
+			}
+
+			version = binary.BigEndian.Uint64(v)
+		}
+
+		if version > uint64(len(migrations)) {
+			return fmt.Errorf("%w: schema version %d is newer than this version supports (%d)", ErrSchemaTooNew, version, len(migrations))
+		}
+
+		for i, m := range migrations[version:] {
+			if err := m(tx); err != nil {
+				return fmt.Errorf("migration %d: %w", int(version)+i+1, err)
+			}
+		}
+
+		return meta.Put(versionKey, binary.BigEndian.AppendUint64(nil, uint64(len(migrations))))
+	})
+}
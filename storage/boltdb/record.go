@@ -0,0 +1,83 @@
+package boltdb
+
+import (
+	"encoding/json"
+	"net/url"
+	"time"
+
+	"github.com/andrewhowdencom/x40.link/storage"
+)
+
+// record is the format in which links are encoded in the database.
+//
+// Earlier versions of this storage wrote only the destination URL as the value. Those values are rewritten as records
+// when the database is migrated (see migrations), so every value read is a record.
+type record struct {
+	To          string    `json:"to"`
+	Owner       string    `json:"owner,omitempty"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+	Description string    `json:"description,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	Status      int       `json:"status,omitempty"`
+
+	// Expires is a pointer so that links which never expire omit it entirely.
+	Expires *time.Time `json:"expires,omitempty"`
+
+	Prefix bool                `json:"prefix,omitempty"`
+	Query  storage.QueryPolicy `json:"query,omitempty"`
+}
+
+// encode converts the link into the value that is stored in the database.
+func encode(l *storage.Link) ([]byte, error) {
+	r := record{
+		To:          l.To.String(),
+		Owner:       l.Owner,
+		Created:     l.Created,
+		Updated:     l.Updated,
+		Description: l.Description,
+		Tags:        l.Tags,
+		Status:      l.Status,
+		Prefix:      l.Prefix,
+		Query:       l.Query,
+	}
+
+	if !l.Expires.IsZero() {
+		r.Expires = &l.Expires
+	}
+
+	return json.Marshal(r)
+}
+
+// decode converts the value stored in the database back into a link.
+func decode(from *url.URL, v []byte) (*storage.Link, error) {
+	r := record{}
+
+	if err := json.Unmarshal(v, &r); err != nil {
+		return nil, ErrDataCorrupt
+	}
+
+	to, err := url.Parse(r.To)
+	if err != nil {
+		return nil, ErrDataCorrupt
+	}
+
+	l := &storage.Link{
+		From:        from,
+		To:          to,
+		Owner:       r.Owner,
+		Created:     r.Created,
+		Updated:     r.Updated,
+		Description: r.Description,
+		Tags:        r.Tags,
+		Status:      r.Status,
+		Prefix:      r.Prefix,
+		Query:       r.Query,
+	}
+
+	if r.Expires != nil {
+		l.Expires = *r.Expires
+	}
+
+	return l, nil
+}
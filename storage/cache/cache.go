@@ -0,0 +1,407 @@
+// Package cache implements a read-through cache in front of any other storage implementation, so that redirects for
+// frequently used links do not need to query the (potentially remote) storage.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andrewhowdencom/x40.link/storage"
+)
+
+// Log is the logger for the library. Uses the default structured logger, but can be overridden to disable the output
+// for this package.
+var Log = slog.Default()
+
+// Err* are sentinel errors
+var (
+	ErrInvalidOption = errors.New("invalid cache option")
+)
+
+// Default* are the values used where the cache is not configured otherwise.
+const (
+	DefaultSize        = 1000
+	DefaultTTL         = time.Minute
+	DefaultNegativeTTL = 10 * time.Second
+)
+
+// Option modifies the cache, allowing the user to set some property of it.
+type Option func(c *Cache) error
+
+// WithSize sets the maximum number of entries in the cache. Once full, the least recently used entry is evicted.
+func WithSize(n int) Option {
+	return func(c *Cache) error {
+		if n <= 0 {
+			return fmt.Errorf("%w: size must be positive, got %d", ErrInvalidOption, n)
+		}
+
+		c.size = n
+
+		return nil
+	}
+}
+
+// WithTTL sets how long a link is served from the cache before it is fetched from the storage again.
+func WithTTL(d time.Duration) Option {
+	return func(c *Cache) error {
+		if d <= 0 {
+			return fmt.Errorf("%w: ttl must be positive, got %s", ErrInvalidOption, d)
+		}
+
+		c.ttl = d
+
+		return nil
+	}
+}
+
+// WithNegativeTTL sets how long the absence of a link is served from the cache before the storage is queried again.
+// Zero disables caching links that are not found.
+func WithNegativeTTL(d time.Duration) Option {
+	return func(c *Cache) error {
+		if d < 0 {
+			return fmt.Errorf("%w: negative ttl must not be negative, got %s", ErrInvalidOption, d)
+		}
+
+		c.negativeTTL = d
+
+		return nil
+	}
+}
+
+// Stats are the counters describing how effective the cache is.
+type Stats struct {
+	// Hits is the number of reads served from the cache, including those served a cached ErrNotFound.
+	Hits uint64
+
+	// Misses is the number of reads that had to query the storage.
+	Misses uint64
+}
+
+// entry is a single (link or not found) result held in the cache, or the templates on a host.
+type entry struct {
+	key       string
+	link      *storage.Link
+	templates []*storage.Link
+	err       error
+	expires   time.Time
+}
+
+// templateKey is the key under which the templates on the host are cached. Keys of links are normalized URLs, which
+// always begin with "//", so the keys are not able to collide.
+func templateKey(host string) string {
+	return "templates:" + host
+}
+
+// Cache is a storage implementation that decorates another with a bounded, least recently used cache of the links
+// that have been read.
+//
+// Writes made through the cache invalidate the entry for that link. Writes made directly to the storage (for
+// example, by another replica) are only visible once the cached entry expires.
+type Cache struct {
+	str storage.Storer
+
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+
+	// gen is incremented on every invalidation, so that reads that raced with a write are not cached.
+	gen uint64
+
+	hits, misses atomic.Uint64
+}
+
+// New creates a new cache in front of the storage.
+func New(str storage.Storer, opts ...Option) (*Cache, error) {
+	c := &Cache{
+		str:         str,
+		size:        DefaultSize,
+		ttl:         DefaultTTL,
+		negativeTTL: DefaultNegativeTTL,
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+	}
+
+	for _, o := range opts {
+		if err := o(c); err != nil {
+			return nil, fmt.Errorf("%w: %s", storage.ErrStorageSetupFailed, err)
+		}
+	}
+
+	return c, nil
+}
+
+// Stats returns the current value of the counters.
+func (c *Cache) Stats() Stats {
+	return Stats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+	}
+}
+
+// Report logs the counters of the cache at every interval, until the context is cancelled. Blocks, so is expected to be
+// run in its own goroutine.
+func Report(ctx context.Context, c *Cache, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s := c.Stats()
+			Log.Info("cache stats", "hits", s.Hits, "misses", s.Misses)
+		}
+	}
+}
+
+// Get returns the URL from the cache, or from the storage if it is not cached
+func (c *Cache) Get(ctx context.Context, u *url.URL) (*url.URL, error) {
+	l, err := c.GetLink(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.To, nil
+}
+
+// GetLink returns the link, complete with its metadata, from the cache or from the storage if it is not cached
+func (c *Cache) GetLink(ctx context.Context, u *url.URL) (*storage.Link, error) {
+	key := storage.Normalize(u).String()
+
+	e, gen, ok := c.lookup(key)
+	if ok {
+		c.hits.Add(1)
+		return e.link, e.err
+	}
+
+	c.misses.Add(1)
+
+	l, err := storage.GetLink(ctx, c.str, u)
+	switch {
+	case err == nil:
+		c.store(&entry{key: key, link: l, expires: time.Now().Add(c.ttl)}, gen)
+	case errors.Is(err, storage.ErrNotFound) && c.negativeTTL > 0:
+		c.store(&entry{key: key, err: err, expires: time.Now().Add(c.negativeTTL)}, gen)
+	}
+
+	return l, err
+}
+
+// Templates returns the templates on the host from the cache, or from the storage if they are not cached. The templates
+// on a host are cached together, as any URL on the host is able to match any of them; writing a link to the host
+// invalidates them.
+func (c *Cache) Templates(ctx context.Context, host string) ([]*storage.Link, error) {
+	key := templateKey(host)
+
+	e, gen, ok := c.lookup(key)
+	if ok {
+		c.hits.Add(1)
+		return e.templates, nil
+	}
+
+	c.misses.Add(1)
+
+	templates, err := storage.Templates(ctx, c.str, host)
+	if err == nil {
+		c.store(&entry{key: key, templates: templates, expires: time.Now().Add(c.ttl)}, gen)
+	}
+
+	return templates, err
+}
+
+// Put writes the URL to the storage, invalidating the cached entry
+func (c *Cache) Put(ctx context.Context, from *url.URL, to *url.URL) error {
+	defer c.invalidate(from)
+
+	return c.str.Put(ctx, from, to)
+}
+
+// PutLink writes the link to the storage, invalidating the cached entry
+func (c *Cache) PutLink(ctx context.Context, l *storage.Link) error {
+	defer c.invalidate(l.From)
+
+	return storage.PutLink(ctx, c.str, l)
+}
+
+// Create writes the link to the storage if there is not already a link there, invalidating the cached entry. Storage
+// that does not support creating links writes the link regardless, as it would without the cache (see storage.Create).
+func (c *Cache) Create(ctx context.Context, l *storage.Link) error {
+	defer c.invalidate(l.From)
+
+	return storage.Create(ctx, c.str, nil, l)
+}
+
+// CreateFolded writes the link to the storage if there is neither a link there, nor one that differs from it only by
+// case, invalidating the cached entry. Storage that does not find links without regard to case creates the link as
+// any other (see Create).
+func (c *Cache) CreateFolded(ctx context.Context, l *storage.Link) error {
+	f, ok := c.str.(storage.FoldedCreator)
+	if !ok {
+		return c.Create(ctx, l)
+	}
+
+	defer c.invalidate(l.From)
+
+	return f.CreateFolded(ctx, l)
+}
+
+// Delete removes the link from the storage, invalidating the cached entry. Storage that does not support deleting
+// links is treated as read-only.
+func (c *Cache) Delete(ctx context.Context, u *url.URL) error {
+	d, ok := c.str.(storage.Deleter)
+	if !ok {
+		return storage.ErrReadOnlyStorage
+	}
+
+	defer c.invalidate(u)
+
+	return d.Delete(ctx, u)
+}
+
+// Purge removes the expired links from the storage, if it supports it. Where links are removed, the whole cache is
+// flushed, as there is no record of which links were removed.
+func (c *Cache) Purge(ctx context.Context, before time.Time) (int, error) {
+	p, ok := c.str.(storage.Purger)
+	if !ok {
+		return 0, nil
+	}
+
+	n, err := p.Purge(ctx, before)
+	if n > 0 {
+		c.flush()
+	}
+
+	return n, err
+}
+
+// GetFolded returns a link whose URL differs from the URL only by case, from the storage. Not cached, as it is only
+// required where a link is not found at the URL itself.
+func (c *Cache) GetFolded(ctx context.Context, u *url.URL) (*storage.Link, error) {
+	return storage.GetFolded(ctx, c.str, u)
+}
+
+// Owns validates whether the agent owns the link. Not cached, as it is only required when writing.
+func (c *Cache) Owns(ctx context.Context, u *url.URL) bool {
+	a, ok := c.str.(storage.Authenticator)
+
+	return ok && a.Owns(ctx, u)
+}
+
+// List pages through the links in the storage. Not cached.
+func (c *Cache) List(ctx context.Context, opts storage.ListOptions) (*storage.Page, error) {
+	l, ok := c.str.(storage.Lister)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", storage.ErrFailed, "storage does not list")
+	}
+
+	return l.List(ctx, opts)
+}
+
+// Backup writes a snapshot of the storage, if it supports it. The cache holds nothing that is not in the storage, so
+// is not part of the snapshot.
+func (c *Cache) Backup(w io.Writer) (int64, error) {
+	b, ok := storage.AsBackuper(c.str)
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", storage.ErrFailed, "storage does not back up")
+	}
+
+	return b.Backup(w)
+}
+
+// CanBackup indicates whether the storage supports writing a snapshot. See storage.BackupChecker
+func (c *Cache) CanBackup() bool {
+	_, ok := storage.AsBackuper(c.str)
+
+	return ok
+}
+
+// lookup returns the cached result for the key, if there is one that has not expired. Also returns the generation
+// of the cache, to be supplied to store should the result need to be fetched from the storage.
+func (c *Cache) lookup(key string) (*entry, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, c.gen, false
+	}
+
+	e := el.Value.(*entry)
+	if !time.Now().Before(e.expires) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+
+		return nil, c.gen, false
+	}
+
+	c.lru.MoveToFront(el)
+
+	return e, c.gen, true
+}
+
+// store adds the entry to the cache, evicting the least recently used entry if the cache is full.
+//
+// The entry is discarded if the cache has been invalidated since the generation in which the entry was looked up, as
+// it may have been read from the storage before the write that invalidated it.
+func (c *Cache) store(e *entry, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+
+	if el, ok := c.entries[e.key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+
+		return
+	}
+
+	c.entries[e.key] = c.lru.PushFront(e)
+
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*entry).key)
+	}
+}
+
+// invalidate removes the cached entry for the URL, and the cached templates on its host, if there are any.
+func (c *Cache) invalidate(u *url.URL) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+
+	u = storage.Normalize(u)
+	for _, key := range []string{u.String(), templateKey(u.Host)} {
+		if el, ok := c.entries[key]; ok {
+			c.lru.Remove(el)
+			delete(c.entries, key)
+		}
+	}
+}
+
+// flush removes all entries from the cache.
+func (c *Cache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
@@ -0,0 +1,242 @@
+package cache_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/andrewhowdencom/x40.link/storage"
+	"github.com/andrewhowdencom/x40.link/storage/cache"
+	"github.com/andrewhowdencom/x40.link/storage/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name string
+		opts []cache.Option
+		err  error
+	}{
+		{
+			name: "defaults",
+		},
+		{
+			name: "all options",
+			opts: []cache.Option{cache.WithSize(10), cache.WithTTL(time.Second), cache.WithNegativeTTL(0)},
+		},
+		{
+			name: "empty cache",
+			opts: []cache.Option{cache.WithSize(0)},
+			err:  storage.ErrStorageSetupFailed,
+		},
+		{
+			name: "zero ttl",
+			opts: []cache.Option{cache.WithTTL(0)},
+			err:  storage.ErrStorageSetupFailed,
+		},
+		{
+			name: "negative negative ttl",
+			opts: []cache.Option{cache.WithNegativeTTL(-time.Second)},
+			err:  storage.ErrStorageSetupFailed,
+		},
+	} {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := cache.New(memory.NewHashTable(), tc.opts...)
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestCache(t *testing.T) {
+	t.Parallel()
+
+	x40 := &url.URL{Host: "x40"}
+	k3s := &url.URL{Host: "k3s"}
+
+	for _, tc := range []struct {
+		name  string
+		opts  []cache.Option
+		run   func(t *testing.T, c *cache.Cache, str storage.Storer)
+		stats cache.Stats
+	}{
+		{
+			name: "serves repeated reads from the cache",
+			run: func(t *testing.T, c *cache.Cache, str storage.Storer) {
+				assert.Nil(t, str.Put(context.Background(), x40, k3s))
+
+				for i := 0; i < 3; i++ {
+					to, err := c.Get(context.Background(), x40)
+					assert.Nil(t, err)
+					assert.Equal(t, k3s, to)
+				}
+			},
+			stats: cache.Stats{Hits: 2, Misses: 1},
+		},
+		{
+			name: "invalidates on write",
+			run: func(t *testing.T, c *cache.Cache, _ storage.Storer) {
+				assert.Nil(t, c.Put(context.Background(), x40, k3s))
+
+				_, err := c.Get(context.Background(), x40)
+				assert.Nil(t, err)
+
+				assert.Nil(t, c.Put(context.Background(), x40, &url.URL{Host: "andrewhowden.com"}))
+
+				to, err := c.Get(context.Background(), x40)
+				assert.Nil(t, err)
+				assert.Equal(t, &url.URL{Host: "andrewhowden.com"}, to)
+			},
+			stats: cache.Stats{Hits: 0, Misses: 2},
+		},
+		{
+			name: "invalidates on delete",
+			run: func(t *testing.T, c *cache.Cache, _ storage.Storer) {
+				assert.Nil(t, c.Put(context.Background(), x40, k3s))
+
+				_, err := c.Get(context.Background(), x40)
+				assert.Nil(t, err)
+
+				assert.Nil(t, c.Delete(context.Background(), x40))
+
+				_, err = c.Get(context.Background(), x40)
+				assert.ErrorIs(t, err, storage.ErrNotFound)
+			},
+			stats: cache.Stats{Hits: 0, Misses: 2},
+		},
+		{
+			name: "caches links that are not found",
+			run: func(t *testing.T, c *cache.Cache, str storage.Storer) {
+				_, err := c.Get(context.Background(), x40)
+				assert.ErrorIs(t, err, storage.ErrNotFound)
+
+				// Written beneath the cache, so it is not visible until the entry expires.
+				assert.Nil(t, str.Put(context.Background(), x40, k3s))
+
+				_, err = c.Get(context.Background(), x40)
+				assert.ErrorIs(t, err, storage.ErrNotFound)
+			},
+			stats: cache.Stats{Hits: 1, Misses: 1},
+		},
+		{
+			name: "negative caching disabled",
+			opts: []cache.Option{cache.WithNegativeTTL(0)},
+			run: func(t *testing.T, c *cache.Cache, str storage.Storer) {
+				_, err := c.Get(context.Background(), x40)
+				assert.ErrorIs(t, err, storage.ErrNotFound)
+
+				assert.Nil(t, str.Put(context.Background(), x40, k3s))
+
+				_, err = c.Get(context.Background(), x40)
+				assert.Nil(t, err)
+			},
+			stats: cache.Stats{Hits: 0, Misses: 2},
+		},
+		{
+			name: "entries expire",
+			opts: []cache.Option{cache.WithTTL(time.Millisecond)},
+			run: func(t *testing.T, c *cache.Cache, str storage.Storer) {
+				assert.Nil(t, str.Put(context.Background(), x40, k3s))
+
+				_, err := c.Get(context.Background(), x40)
+				assert.Nil(t, err)
+
+				time.Sleep(time.Millisecond * 5)
+
+				_, err = c.Get(context.Background(), x40)
+				assert.Nil(t, err)
+			},
+			stats: cache.Stats{Hits: 0, Misses: 2},
+		},
+		{
+			name: "evicts the least recently used entry",
+			opts: []cache.Option{cache.WithSize(1)},
+			run: func(t *testing.T, c *cache.Cache, str storage.Storer) {
+				assert.Nil(t, str.Put(context.Background(), x40, k3s))
+				assert.Nil(t, str.Put(context.Background(), k3s, x40))
+
+				for _, u := range []*url.URL{x40, x40, k3s, x40} {
+					_, err := c.Get(context.Background(), u)
+					assert.Nil(t, err)
+				}
+			},
+			stats: cache.Stats{Hits: 1, Misses: 3},
+		},
+	} {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			str := memory.NewHashTable()
+			c, err := cache.New(str, tc.opts...)
+			assert.Nil(t, err)
+
+			tc.run(t, c, str)
+			assert.Equal(t, tc.stats, c.Stats())
+		})
+	}
+}
+
+// TestReport validates that the counters of the cache are logged.
+// putter hides every extension of the storage, such that it is only able to get and put.
+type putter struct {
+	storage.Storer
+}
+
+// TestCreate validates that links are created in storage that does not support creating them, as they would be
+// without the cache.
+func TestCreate(t *testing.T) {
+	t.Parallel()
+
+	u := &url.URL{Host: "x40", Path: "/foo"}
+
+	c, err := cache.New(putter{Storer: memory.NewHashTable()})
+	assert.Nil(t, err)
+
+	// The miss is cached, so must be invalidated by the create.
+	_, err = c.Get(context.Background(), u)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	assert.Nil(t, c.Create(context.Background(), &storage.Link{From: u, To: &url.URL{Host: "k3s"}}))
+
+	to, err := c.Get(context.Background(), u)
+	assert.Nil(t, err)
+	assert.Equal(t, &url.URL{Host: "k3s"}, to)
+}
+
+func TestReport(t *testing.T) {
+	exist := cache.Log
+	defer func() { cache.Log = exist }()
+
+	b := &bytes.Buffer{}
+	cache.Log = slog.New(slog.NewTextHandler(b, nil))
+
+	c, err := cache.New(memory.NewHashTable())
+	assert.Nil(t, err)
+
+	_, _ = c.Get(context.Background(), &url.URL{Host: "x40"})
+	_, _ = c.Get(context.Background(), &url.URL{Host: "x40"})
+
+	ctx, cxl := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		cache.Report(ctx, c, time.Millisecond)
+		close(done)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cxl()
+	<-done
+
+	assert.Contains(t, b.String(), "hits=1 misses=1")
+}
@@ -0,0 +1,291 @@
+// Package catalogue reads links from files in which they are listed, such as those maintained by hand or exported from
+// a spreadsheet. The same fields are supported in each format:
+//
+//   - from: The short link (required, and must include a host)
+//   - to: The destination of the link (required)
+//   - description: A human readable explanation of the link
+//   - tags: Free form labels for the link
+//   - status: The HTTP status code with which to redirect
+//   - expires: The time (RFC 3339) after which the link no longer redirects
+//   - query: How the query string is forwarded to the destination (drop, append or merge)
+//
+// A from URL ending in a wildcard (e.g. //go.example/docs/*) is a prefix link, which also redirects the paths beneath
+// its own; the rest of the path is appended to the destination (which may also end in a wildcard, for clarity). See
+// storage.Prefixer.
+//
+// A from URL with a path segment in braces (e.g. //go.example/gh/{repo}) is a template, which redirects every URL with
+// any segment in its place; the segment is substituted into the destination wherever the same placeholder appears
+// (e.g. https://github.com/org/{repo}). See storage.Templater.
+//
+// See the documentation of each Format for how the links are laid out in that format.
+package catalogue
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/andrewhowdencom/x40.link/storage"
+)
+
+// Err* are sentinel errors
+var (
+	ErrInvalid           = errors.New("catalogue is invalid")
+	ErrUnsupportedFormat = errors.New("unsupported catalogue format")
+)
+
+// Format is the format in which the catalogue is written.
+type Format string
+
+// The supported formats. Each is named after the file extension by which it is recognised.
+const (
+	// YAML is a list of mappings:
+	//
+	//	- from: //x40/foo
+	//	  to: //x40/bar
+	//	  tags: [bar, foo]
+	YAML Format = "yaml"
+
+	// JSON is an array of objects:
+	//
+	//	[{"from": "//x40/foo", "to": "//x40/bar", "tags": ["bar", "foo"]}]
+	JSON Format = "json"
+
+	// TOML is an array of tables named "links":
+	//
+	//	[[links]]
+	//	from = "//x40/foo"
+	//	to = "//x40/bar"
+	//	tags = ["bar", "foo"]
+	TOML Format = "toml"
+
+	// CSV is a header row naming the fields, followed by a row for each link. Tags are separated by commas within
+	// the (quoted) field:
+	//
+	//	from,to,tags
+	//	//x40/foo,//x40/bar,"bar,foo"
+	CSV Format = "csv"
+)
+
+// decoders read the rows from each format.
+var decoders = map[Format]func(src io.Reader) ([]*record, error){
+	YAML: decodeYAML,
+	JSON: decodeJSON,
+	TOML: decodeTOML,
+	CSV:  decodeCSV,
+}
+
+// ParseFormat returns the format of the given name, if it is supported.
+func ParseFormat(name string) (Format, error) {
+	f := Format(strings.ToLower(name))
+	if f == "yml" {
+		f = YAML
+	}
+
+	if _, ok := decoders[f]; !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedFormat, name)
+	}
+
+	return f, nil
+}
+
+// FormatFor returns the format of the file at the path, according to its extension.
+func FormatFor(path string) (Format, error) {
+	return ParseFormat(strings.TrimPrefix(filepath.Ext(path), "."))
+}
+
+// row is a single link, as written in the catalogue.
+type row struct {
+	From        string    `yaml:"from" json:"from" toml:"from"`
+	To          string    `yaml:"to" json:"to" toml:"to"`
+	Description string    `yaml:"description" json:"description" toml:"description"`
+	Tags        []string  `yaml:"tags" json:"tags" toml:"tags"`
+	Status      int       `yaml:"status" json:"status" toml:"status"`
+	Expires     time.Time `yaml:"expires" json:"expires" toml:"expires"`
+	Query       string    `yaml:"query" json:"query" toml:"query"`
+}
+
+// record is a row, along with where it was found in the catalogue.
+type record struct {
+	row
+
+	// line is the line on which the row starts.
+	line int
+
+	// lines are the lines on which each field is found, where the format is able to supply them.
+	lines map[string]int
+
+	// err is the reason the row could not be decoded, if it could not.
+	err error
+}
+
+// lineOf returns the line on which the field was found, or the line of the row where that is not known.
+func (r *record) lineOf(field string) int {
+	if l, ok := r.lines[field]; ok {
+		return l
+	}
+
+	return r.line
+}
+
+// Problem is a single invalid row in the catalogue.
+type Problem struct {
+	// Line is the line in the file on which the problem is found.
+	Line int
+
+	// Message describes what is wrong with the row.
+	Message string
+}
+
+// String implements fmt.Stringer
+func (p Problem) String() string {
+	return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+}
+
+// ValidationError is the error returned where one or more rows in the catalogue are invalid. It describes each of
+// them.
+type ValidationError struct {
+	Problems []Problem
+}
+
+// Error implements the error interface
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Problems))
+	for _, p := range e.Problems {
+		lines = append(lines, p.String())
+	}
+
+	return fmt.Sprintf("%s: %d invalid rows: %s", ErrInvalid, len(e.Problems), strings.Join(lines, "; "))
+}
+
+// Unwrap allows matching the error against ErrInvalid
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalid
+}
+
+// Validate checks every row of the catalogue, returning a ValidationError describing all of the invalid rows (if
+// there are any). Rows are invalid where:
+//
+//   - The row cannot be decoded (for example, the status is not a number)
+//   - Either URL fails to parse
+//   - The from URL has no host, or there is no to URL
+//   - The status is not one with which a link is able to redirect
+//   - The from URL duplicates that of an earlier row
+//
+// Returns an error wrapping storage.ErrStorageSetupFailed where the file itself cannot be parsed.
+func Validate(src io.Reader, f Format) error {
+	_, problems, err := Parse(src, f)
+	if err != nil {
+		return err
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+// Parse reads the links from the catalogue, returning those that are valid, along with the problems with those that
+// are not. See Validate.
+func Parse(src io.Reader, f Format) ([]*storage.Link, []Problem, error) {
+	decode, ok := decoders[f]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %q", ErrUnsupportedFormat, f)
+	}
+
+	records, err := decode(src)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", storage.ErrStorageSetupFailed, err)
+	}
+
+	links := make([]*storage.Link, 0, len(records))
+	problems := []Problem{}
+	seen := map[string]int{}
+
+	for _, r := range records {
+		l, p := link(r)
+		// Links are stored under their normalized URL, so URLs that normalize to the same one are duplicates.
+		key := ""
+		if p == nil {
+			key = storage.Normalize(l.From).String()
+			if first, ok := seen[key]; ok {
+				p = &Problem{
+					Line:    r.lineOf("from"),
+					Message: fmt.Sprintf("duplicate from %q (first on line %d)", l.From.String(), first),
+				}
+			}
+		}
+
+		if p != nil {
+			problems = append(problems, *p)
+			continue
+		}
+
+		seen[key] = r.lineOf("from")
+		links = append(links, l)
+	}
+
+	return links, problems, nil
+}
+
+// link converts a single record into a link, or describes the problem with the record.
+func link(r *record) (*storage.Link, *Problem) {
+	if r.err != nil {
+		return nil, &Problem{Line: r.line, Message: r.err.Error()}
+	}
+
+	from, err := url.Parse(r.From)
+	if err != nil {
+		return nil, &Problem{Line: r.lineOf("from"), Message: fmt.Sprintf("invalid from: %s", err)}
+	}
+
+	if from.Host == "" {
+		return nil, &Problem{Line: r.lineOf("from"), Message: fmt.Sprintf("from %q has no host", r.From)}
+	}
+
+	if r.To == "" {
+		return nil, &Problem{Line: r.line, Message: "missing to"}
+	}
+
+	to, err := url.Parse(r.To)
+	if err != nil {
+		return nil, &Problem{Line: r.lineOf("to"), Message: fmt.Sprintf("invalid to: %s", err)}
+	}
+
+	if !storage.ValidStatus(r.Status) {
+		return nil, &Problem{Line: r.lineOf("status"), Message: fmt.Sprintf("unsupported status %d", r.Status)}
+	}
+
+	if !storage.ValidQuery(storage.QueryPolicy(r.Query)) {
+		return nil, &Problem{Line: r.lineOf("query"), Message: fmt.Sprintf("unsupported query policy %q", r.Query)}
+	}
+
+	from, prefix := storage.TrimWildcard(from)
+
+	to, wildcard := storage.TrimWildcard(to)
+	if wildcard && !prefix {
+		return nil, &Problem{Line: r.lineOf("to"), Message: fmt.Sprintf("to %q has a wildcard, but from %q does not", r.To, r.From)}
+	}
+
+	l := &storage.Link{
+		From:        from,
+		To:          to,
+		Description: r.Description,
+		Tags:        r.Tags,
+		Status:      r.Status,
+		Expires:     r.Expires,
+		Prefix:      prefix,
+		Query:       storage.QueryPolicy(r.Query),
+	}
+
+	if err := storage.ValidateTemplate(l); err != nil {
+		return nil, &Problem{Line: r.lineOf("from"), Message: err.Error()}
+	}
+
+	return l, nil
+}
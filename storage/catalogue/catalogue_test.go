@@ -0,0 +1,360 @@
+package catalogue_test
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrewhowdencom/x40.link/storage"
+	"github.com/andrewhowdencom/x40.link/storage/catalogue"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatFor(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		path   string
+		format catalogue.Format
+		err    error
+	}{
+		{path: "links.yaml", format: catalogue.YAML},
+		{path: "links.yml", format: catalogue.YAML},
+		{path: "/etc/x40/links.JSON", format: catalogue.JSON},
+		{path: "links.toml", format: catalogue.TOML},
+		{path: "links.csv", format: catalogue.CSV},
+		{path: "links.xml", err: catalogue.ErrUnsupportedFormat},
+		{path: "links", err: catalogue.ErrUnsupportedFormat},
+	} {
+		tc := tc
+
+		t.Run(tc.path, func(t *testing.T) {
+			t.Parallel()
+
+			f, err := catalogue.FormatFor(tc.path)
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.format, f)
+		})
+	}
+}
+
+// TestParse validates that each of the formats is able to express the same link, with all of its metadata.
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	expected := []*storage.Link{
+		{
+			From:        &url.URL{Host: "x40", Path: "/foo"},
+			To:          &url.URL{Host: "k3s", Path: "/bar"},
+			Description: "The bar, by way of foo",
+			Tags:        []string{"bar", "foo"},
+			Status:      301,
+			Expires:     time.Date(2024, 12, 31, 23, 59, 59, 0, time.UTC),
+			Query:       storage.QueryMerge,
+		},
+		{
+			From: &url.URL{Host: "x40", Path: "/baz"},
+			To:   &url.URL{Host: "k3s", Path: "/baz"},
+		},
+	}
+
+	for _, tc := range []struct {
+		format catalogue.Format
+		in     string
+	}{
+		{
+			format: catalogue.YAML,
+			in: `---
+- from: //x40/foo
+  to: //k3s/bar
+  description: The bar, by way of foo
+  tags: [bar, foo]
+  status: 301
+  expires: 2024-12-31T23:59:59Z
+  query: merge
+- from: //x40/baz
+  to: //k3s/baz
+`,
+		},
+		{
+			format: catalogue.JSON,
+			in: `[
+  {
+    "from": "//x40/foo",
+    "to": "//k3s/bar",
+    "description": "The bar, by way of foo",
+    "tags": ["bar", "foo"],
+    "status": 301,
+    "expires": "2024-12-31T23:59:59Z",
+    "query": "merge"
+  },
+  {"from": "//x40/baz", "to": "//k3s/baz"}
+]`,
+		},
+		{
+			format: catalogue.TOML,
+			in: `[[links]]
+from = "//x40/foo"
+to = "//k3s/bar"
+description = "The bar, by way of foo"
+tags = ["bar", "foo"]
+status = 301
+expires = 2024-12-31T23:59:59Z
+query = "merge"
+
+[[links]]
+from = "//x40/baz"
+to = "//k3s/baz"
+`,
+		},
+		{
+			format: catalogue.CSV,
+			in: `from,to,description,tags,status,expires,query
+//x40/foo,//k3s/bar,"The bar, by way of foo","bar,foo",301,2024-12-31T23:59:59Z,merge
+//x40/baz,//k3s/baz,,,,,
+`,
+		},
+	} {
+		tc := tc
+
+		t.Run(string(tc.format), func(t *testing.T) {
+			t.Parallel()
+
+			links, problems, err := catalogue.Parse(bytes.NewBufferString(tc.in), tc.format)
+			assert.Nil(t, err)
+			assert.Empty(t, problems)
+			assert.Len(t, links, len(expected))
+
+			for i, l := range links {
+				assert.Equal(t, expected[i].From, l.From)
+				assert.Equal(t, expected[i].To, l.To)
+				assert.Equal(t, expected[i].Description, l.Description)
+				assert.Equal(t, expected[i].Tags, l.Tags)
+				assert.Equal(t, expected[i].Status, l.Status)
+				assert.True(t, expected[i].Expires.Equal(l.Expires))
+			}
+		})
+	}
+}
+
+func TestParseWildcard(t *testing.T) {
+	t.Parallel()
+
+	links, problems, err := catalogue.Parse(strings.NewReader(`---
+- from: //go.example/docs/*
+  to: https://docs.example.com/*
+- from: //go.example/*
+  to: https://example.com
+- from: //go.example/exact
+  to: https://example.com/exact
+`), catalogue.YAML)
+	assert.Nil(t, err)
+	assert.Empty(t, problems)
+
+	assert.Equal(t, []*storage.Link{
+		{
+			From:   &url.URL{Host: "go.example", Path: "/docs"},
+			To:     &url.URL{Scheme: "https", Host: "docs.example.com"},
+			Prefix: true,
+		},
+		{
+			From:   &url.URL{Host: "go.example"},
+			To:     &url.URL{Scheme: "https", Host: "example.com"},
+			Prefix: true,
+		},
+		{
+			From: &url.URL{Host: "go.example", Path: "/exact"},
+			To:   &url.URL{Scheme: "https", Host: "example.com", Path: "/exact"},
+		},
+	}, links)
+}
+
+// TestParseDuplicateNormalized validates that URLs written differently, but that are stored as the same link, are
+// reported as duplicates.
+func TestParseDuplicateNormalized(t *testing.T) {
+	t.Parallel()
+
+	_, problems, err := catalogue.Parse(strings.NewReader(`---
+- from: //X40.link/a
+  to: https://example.com/first
+- from: //x40.link:443/a
+  to: https://example.com/second
+`), catalogue.YAML)
+	assert.Nil(t, err)
+	assert.Equal(t, []catalogue.Problem{
+		{Line: 4, Message: "duplicate from \"//x40.link:443/a\" (first on line 2)"},
+	}, problems)
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name   string
+		format catalogue.Format
+		in     string
+
+		err      error
+		problems []catalogue.Problem
+	}{
+		{
+			name:   "valid",
+			format: catalogue.YAML,
+			in: `---
+- from: //x40/foo
+  to: //k3s/bar
+  status: 301
+`,
+		},
+		{
+			name:   "empty yaml",
+			format: catalogue.YAML,
+			in:     "---",
+		},
+		{
+			name:   "empty json",
+			format: catalogue.JSON,
+		},
+		{
+			name:   "not a list",
+			format: catalogue.YAML,
+			in:     "I'm not yaml!",
+			err:    storage.ErrStorageSetupFailed,
+		},
+		{
+			name:   "unsupported format",
+			format: catalogue.Format("xml"),
+			err:    catalogue.ErrUnsupportedFormat,
+		},
+		{
+			name:   "yaml, every invalid row reported",
+			format: catalogue.YAML,
+			in: `---
+- from: //x40/foo
+  to: //k3s/bar
+- from: "//	/foo"
+  to: //k3s/bar
+- from: /no-host
+  to: //k3s/bar
+- from: //x40/missing-to
+- from: //x40/foo
+  to: //k3s/baz
+- from: //x40/status
+  to: //k3s/bar
+  status: 200
+- from: //x40/tags
+  to: //k3s/bar
+  tags: not-a-list
+- from: //x40/wildcard
+  to: //k3s/*
+- from: //x40/{template}
+  to: //k3s/{undefined}
+- from: //x40/query
+  to: //k3s/query
+  query: keep
+`,
+			err: catalogue.ErrInvalid,
+			problems: []catalogue.Problem{
+				{Line: 4, Message: "invalid from: parse \"//\\t/foo\": net/url: invalid control character in URL"},
+				{Line: 6, Message: "from \"/no-host\" has no host"},
+				{Line: 8, Message: "missing to"},
+				{Line: 9, Message: "duplicate from \"//x40/foo\" (first on line 2)"},
+				{Line: 13, Message: "unsupported status 200"},
+				{Line: 14, Message: "yaml: unmarshal errors:\n  line 16: cannot unmarshal !!str `not-a-list` into []string"},
+				{Line: 18, Message: "to \"//k3s/*\" has a wildcard, but from \"//x40/wildcard\" does not"},
+				{Line: 19, Message: "invalid link template: destination uses {undefined}, which the url does not capture"},
+				{Line: 23, Message: "unsupported query policy \"keep\""},
+			},
+		},
+		{
+			name:   "json, every invalid row reported",
+			format: catalogue.JSON,
+			in: `[
+  {"from": "//x40/foo", "to": "//k3s/bar"},
+  {"from": "/no-host", "to": "//k3s/bar"},
+  {
+    "from": "//x40/foo",
+    "to": "//k3s/bar"
+  },
+  {"from": "//x40/status", "to": "//k3s/bar", "status": "301"}
+]`,
+			err: catalogue.ErrInvalid,
+			problems: []catalogue.Problem{
+				{Line: 3, Message: "from \"/no-host\" has no host"},
+				{Line: 4, Message: "duplicate from \"//x40/foo\" (first on line 2)"},
+				{Line: 8, Message: "json: cannot unmarshal string into Go struct field row.status of type int"},
+			},
+		},
+		{
+			name:   "json, broken syntax",
+			format: catalogue.JSON,
+			in:     `[{"from": "//x40/foo",}]`,
+			err:    storage.ErrStorageSetupFailed,
+		},
+		{
+			name:   "toml, every invalid row reported",
+			format: catalogue.TOML,
+			in: `[[links]]
+from = "//x40/foo"
+to = "//k3s/bar"
+
+[[links]]
+from = "/no-host"
+to = "//k3s/bar"
+
+[[links]]
+to = "//k3s/bar"
+status = 200
+from = "//x40/status"
+`,
+			err: catalogue.ErrInvalid,
+			problems: []catalogue.Problem{
+				{Line: 6, Message: "from \"/no-host\" has no host"},
+				{Line: 11, Message: "unsupported status 200"},
+			},
+		},
+		{
+			name:   "csv, every invalid row reported",
+			format: catalogue.CSV,
+			in: `from,to,status
+//x40/foo,//k3s/bar,
+/no-host,//k3s/bar,
+//x40/status,//k3s/bar,three hundred and one
+//x40/short,//k3s/bar
+//x40/foo,//k3s/baz,
+`,
+			err: catalogue.ErrInvalid,
+			problems: []catalogue.Problem{
+				{Line: 3, Message: "from \"/no-host\" has no host"},
+				{Line: 4, Message: "invalid status \"three hundred and one\""},
+				{Line: 5, Message: "expected 3 fields, got 2"},
+				{Line: 6, Message: "duplicate from \"//x40/foo\" (first on line 2)"},
+			},
+		},
+		{
+			name:   "csv, unknown column",
+			format: catalogue.CSV,
+			in:     "from,to,colour\n",
+			err:    storage.ErrStorageSetupFailed,
+		},
+	} {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := catalogue.Validate(bytes.NewBufferString(tc.in), tc.format)
+			assert.ErrorIs(t, err, tc.err)
+
+			var verr *catalogue.ValidationError
+			if errors.As(err, &verr) {
+				assert.Equal(t, tc.problems, verr.Problems)
+			} else {
+				assert.Empty(t, tc.problems)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package catalogue
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// csvFields are the columns that are able to appear in the header, and how each is converted into the row.
+var csvFields = map[string]func(r *row, v string) error{
+	"from":        func(r *row, v string) error { r.From = v; return nil },
+	"to":          func(r *row, v string) error { r.To = v; return nil },
+	"description": func(r *row, v string) error { r.Description = v; return nil },
+	"query":       func(r *row, v string) error { r.Query = v; return nil },
+	"tags": func(r *row, v string) error {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				r.Tags = append(r.Tags, t)
+			}
+		}
+
+		return nil
+	},
+	"status": func(r *row, v string) (err error) {
+		if v == "" {
+			return nil
+		}
+
+		r.Status, err = strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid status %q", v)
+		}
+
+		return nil
+	},
+	"expires": func(r *row, v string) (err error) {
+		if v == "" {
+			return nil
+		}
+
+		r.Expires, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return fmt.Errorf("invalid expires %q: %s", v, err)
+		}
+
+		return nil
+	},
+}
+
+// decodeCSV reads the rows from comma separated values. The first row is the header, naming the field in each column.
+func decodeCSV(src io.Reader) ([]*record, error) {
+	rd := csv.NewReader(src)
+
+	// The number of fields is checked against the header, so it can be reported against the row.
+	rd.FieldsPerRecord = -1
+
+	header, err := rd.Read()
+	if errors.Is(err, io.EOF) {
+		// An empty file has no links, rather than being broken.
+		return []*record{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for i, h := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(h))
+
+		if _, ok := csvFields[header[i]]; !ok {
+			return nil, fmt.Errorf("line 1: unknown column %q", h)
+		}
+	}
+
+	records := []*record{}
+	for {
+		values, err := rd.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		line, _ := rd.FieldPos(0)
+		r := &record{line: line, lines: map[string]int{}}
+
+		if len(values) != len(header) {
+			r.err = fmt.Errorf("expected %d fields, got %d", len(header), len(values))
+			records = append(records, r)
+
+			continue
+		}
+
+		for i, v := range values {
+			r.lines[header[i]], _ = rd.FieldPos(i)
+
+			if err := csvFields[header[i]](&r.row, v); err != nil {
+				r.err = err
+				break
+			}
+		}
+
+		records = append(records, r)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,65 @@
+package catalogue
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// decodeJSON reads the rows from a JSON array. The decoder reports only the offset at which each row starts, so the
+// line of each field is not known; problems are reported at the line of the row.
+func decodeJSON(src io.Reader) ([]*record, error) {
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+
+	// An empty file has no links, rather than being broken.
+	if len(bytes.TrimSpace(data)) == 0 {
+		return []*record{}, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	if t, err := dec.Token(); err != nil {
+		return nil, err
+	} else if t != json.Delim('[') {
+		return nil, fmt.Errorf("line 1: expected a list of links")
+	}
+
+	records := []*record{}
+	for dec.More() {
+		r := &record{line: lineAt(data, dec.InputOffset())}
+
+		// The decoder reads the whole row before converting it, so values that fail to convert (e.g. of the wrong
+		// type) are reported against the row, and the decoder moves on to the next. Broken syntax means the rest of
+		// the file cannot be read.
+		var serr *json.SyntaxError
+		if err := dec.Decode(&r.row); errors.As(err, &serr) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("line %d: %s", r.line, err)
+		} else if err != nil {
+			r.err = err
+		}
+
+		records = append(records, r)
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// lineAt returns the line of the first value at or after the offset. Offsets reported by the decoder are
+// immediately after the previous token, so may point at the separator or whitespace before the value.
+func lineAt(data []byte, offset int64) int {
+	i := int(offset)
+	for i < len(data) && bytes.ContainsRune([]byte(" \t\r\n,"), rune(data[i])) {
+		i++
+	}
+
+	return bytes.Count(data[:i], []byte("\n")) + 1
+}
@@ -0,0 +1,75 @@
+package catalogue
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+var (
+	// tomlTable matches the header of each link.
+	tomlTable = regexp.MustCompile(`^\s*\[\[\s*links\s*\]\]`)
+
+	// tomlOther matches the header of any other table.
+	tomlOther = regexp.MustCompile(`^\s*\[`)
+
+	// tomlKey matches a key within the table of a link.
+	tomlKey = regexp.MustCompile(`^\s*([A-Za-z0-9_-]+)\s*=`)
+)
+
+// decodeTOML reads the rows from the "links" array of tables. The decoder does not retain the position of the values
+// it decodes, so the lines are found by scanning the file for the table headers (and the keys beneath them).
+func decodeTOML(src io.Reader) ([]*record, error) {
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := struct {
+		Links []row `toml:"links"`
+	}{}
+
+	if err := toml.Unmarshal(data, &doc); err != nil {
+		var derr *toml.DecodeError
+		if errors.As(err, &derr) {
+			line, _ := derr.Position()
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+
+		return nil, err
+	}
+
+	records := make([]*record, 0, len(doc.Links))
+	for _, r := range doc.Links {
+		records = append(records, &record{row: r, lines: map[string]int{}})
+	}
+
+	// Find the lines of each table, and the keys within them. The tables are in the same order as the rows.
+	i, inside := -1, false
+	s := bufio.NewScanner(bytes.NewReader(data))
+
+	for n := 1; s.Scan(); n++ {
+		switch {
+		case tomlTable.Match(s.Bytes()):
+			i++
+			inside = i < len(records)
+
+			if inside {
+				records[i].line = n
+			}
+		case tomlOther.Match(s.Bytes()):
+			inside = false
+		case inside:
+			if m := tomlKey.FindSubmatch(s.Bytes()); m != nil {
+				records[i].lines[string(m[1])] = n
+			}
+		}
+	}
+
+	return records, nil
+}
@@ -0,0 +1,53 @@
+package catalogue
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	parser "gopkg.in/yaml.v3"
+)
+
+// decodeYAML reads the rows from a YAML list. YAML retains the position of every node, so the line of each field is
+// known.
+func decodeYAML(src io.Reader) ([]*record, error) {
+	doc := &parser.Node{}
+	if err := parser.NewDecoder(src).Decode(doc); err != nil {
+		// An empty file has no links, rather than being broken.
+		if errors.Is(err, io.EOF) {
+			return []*record{}, nil
+		}
+
+		return nil, err
+	}
+
+	seq := doc
+	if seq.Kind == parser.DocumentNode && len(seq.Content) > 0 {
+		seq = seq.Content[0]
+	}
+
+	// An empty document (e.g. only "---") also has no links.
+	if seq.Kind == parser.ScalarNode && seq.Tag == "!!null" {
+		return []*record{}, nil
+	}
+
+	if seq.Kind != parser.SequenceNode {
+		return nil, fmt.Errorf("line %d: expected a list of links", seq.Line)
+	}
+
+	records := make([]*record, 0, len(seq.Content))
+	for _, n := range seq.Content {
+		r := &record{line: n.Line, lines: map[string]int{}}
+		r.err = n.Decode(&r.row)
+
+		if n.Kind == parser.MappingNode {
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				r.lines[n.Content[i].Value] = n.Content[i+1].Line
+			}
+		}
+
+		records = append(records, r)
+	}
+
+	return records, nil
+}
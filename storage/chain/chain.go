@@ -0,0 +1,354 @@
+// Package chain implements storage that layers several other storage implementations on top of each other. For
+// example, a read-only catalogue of links (in YAML) in front of a writable database.
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrewhowdencom/x40.link/storage"
+)
+
+// errShadowed indicates that a write was rejected because a read-only layer already holds the link.
+var errShadowed = fmt.Errorf("%w: %s", storage.ErrReadOnlyStorage, "link is held by a read-only layer")
+
+// Chain is a storage implementation composed of several layers. Reads are served by the first layer that holds the
+// link, and writes go to the first layer that accepts them; that is, the first layer that does not reject them with
+// storage.ErrReadOnlyStorage.
+//
+// Links in a read-only layer shadow links at the same address in the layers beneath it. Given this, writing a link
+// that is held by a read-only layer in front of the writable one is rejected, rather than written somewhere it can
+// never be read.
+type Chain struct {
+	layers []storage.Storer
+}
+
+// New creates a new chain from the layers, in the order in which they should be queried.
+func New(layers ...storage.Storer) *Chain {
+	return &Chain{layers: layers}
+}
+
+// Get returns the URL from the first layer that holds it
+func (c *Chain) Get(ctx context.Context, u *url.URL) (*url.URL, error) {
+	l, err := c.GetLink(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.To, nil
+}
+
+// GetLink returns the link, complete with its metadata, from the first layer that holds it
+func (c *Chain) GetLink(ctx context.Context, u *url.URL) (*storage.Link, error) {
+	for _, layer := range c.layers {
+		l, err := storage.GetLink(ctx, layer, u)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+
+		return l, err
+	}
+
+	return nil, storage.ErrNotFound
+}
+
+// GetFolded returns a link whose URL differs from the URL only by case, from the first layer that holds one.
+func (c *Chain) GetFolded(ctx context.Context, u *url.URL) (*storage.Link, error) {
+	for _, layer := range c.layers {
+		l, err := storage.GetFolded(ctx, layer, u)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+
+		return l, err
+	}
+
+	return nil, storage.ErrNotFound
+}
+
+// Templates returns the templates on the host, from every layer. A template in a layer shadows a template at the same
+// address in the layers beneath it.
+func (c *Chain) Templates(ctx context.Context, host string) ([]*storage.Link, error) {
+	found := []*storage.Link{}
+	seen := map[string]bool{}
+
+	for _, layer := range c.layers {
+		templates, err := storage.Templates(ctx, layer, host)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, l := range templates {
+			if k := l.From.String(); !seen[k] {
+				seen[k] = true
+				found = append(found, l)
+			}
+		}
+	}
+
+	return found, nil
+}
+
+// Put writes the URL to the first writable layer
+func (c *Chain) Put(ctx context.Context, from *url.URL, to *url.URL) error {
+	return c.PutLink(ctx, &storage.Link{From: from, To: to})
+}
+
+// PutLink writes the link, complete with its metadata, to the first writable layer
+func (c *Chain) PutLink(ctx context.Context, l *storage.Link) error {
+	return c.write(ctx, l.From, func(layer storage.Storer) error {
+		return storage.PutLink(ctx, layer, l)
+	})
+}
+
+// Create writes the link to the first writable layer, but only if no layer in front of it already holds a link at the
+// same address. Layers that do not support creating links are treated as read-only.
+func (c *Chain) Create(ctx context.Context, l *storage.Link) error {
+	err := c.write(ctx, l.From, func(layer storage.Storer) error {
+		cr, ok := layer.(storage.Creator)
+		if !ok {
+			return storage.ErrReadOnlyStorage
+		}
+
+		return cr.Create(ctx, l)
+	})
+
+	// A link shadowed by a read-only layer already exists, as far as anyone reading from the chain is concerned.
+	if errors.Is(err, errShadowed) {
+		return storage.ErrAlreadyExists
+	}
+
+	return err
+}
+
+// CreateFolded writes the link to the first writable layer, but only if no layer in front of it (nor the writable layer
+// itself) holds a link at the same address, or at one that differs from it only by case. See storage.FoldedCreator.
+//
+// The read-only layers do not change as they are written to, so they are checked apart from the write; the writable
+// layer is checked as part of it. Writable layers that do not find links without regard to case create the link as
+// any other.
+func (c *Chain) CreateFolded(ctx context.Context, l *storage.Link) error {
+	err := c.write(ctx, l.From, func(layer storage.Storer) error {
+		var err error
+
+		switch w := layer.(type) {
+		case storage.FoldedCreator:
+			err = w.CreateFolded(ctx, l)
+		case storage.Creator:
+			err = w.Create(ctx, l)
+		default:
+			err = storage.ErrReadOnlyStorage
+		}
+
+		if !errors.Is(err, storage.ErrReadOnlyStorage) {
+			return err
+		}
+
+		if f, ferr := storage.GetFolded(ctx, layer, l.From); ferr == nil && f.From.String() != storage.Normalize(l.From).String() {
+			return storage.ErrCaseCollision
+		}
+
+		return err
+	})
+
+	// A link shadowed by a read-only layer already exists, as far as anyone reading from the chain is concerned.
+	if errors.Is(err, errShadowed) {
+		return storage.ErrAlreadyExists
+	}
+
+	return err
+}
+
+// Delete removes the link from the first layer that holds it. Where that layer is read-only, the link cannot be
+// removed.
+func (c *Chain) Delete(ctx context.Context, u *url.URL) error {
+	for _, layer := range c.layers {
+		d, ok := layer.(storage.Deleter)
+		if !ok {
+			continue
+		}
+
+		err := d.Delete(ctx, u)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+
+		// Read-only layers reject the delete whether or not they hold the link, so check which it is.
+		if errors.Is(err, storage.ErrReadOnlyStorage) && !holds(ctx, layer, u) {
+			continue
+		}
+
+		return err
+	}
+
+	return storage.ErrNotFound
+}
+
+// Purge removes expired links from every layer that supports it, returning the total number removed.
+func (c *Chain) Purge(ctx context.Context, before time.Time) (int, error) {
+	n := 0
+
+	for _, layer := range c.layers {
+		p, ok := layer.(storage.Purger)
+		if !ok {
+			continue
+		}
+
+		removed, err := p.Purge(ctx, before)
+		n += removed
+
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// List returns the links of each layer in turn, omitting those that are shadowed by a layer in front. The cursor
+// records both the layer and the position within it, so a page may be shorter than the limit where it finishes one
+// layer and the next begins.
+func (c *Chain) List(ctx context.Context, opts storage.ListOptions) (*storage.Page, error) {
+	i, inner, err := cursor(opts.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &storage.Page{Links: []*storage.Link{}}
+
+	for ; i < len(c.layers); i, inner = i+1, "" {
+		l, ok := c.layers[i].(storage.Lister)
+		if !ok {
+			return nil, fmt.Errorf("%w: layer %d does not list", storage.ErrFailed, i)
+		}
+
+		o := opts
+		o.Cursor = inner
+		o.Limit = opts.Size() - len(page.Links)
+
+		p, err := l.List(ctx, o)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, link := range p.Links {
+			if !c.shadowed(ctx, i, link.From) {
+				page.Links = append(page.Links, link)
+			}
+		}
+
+		if p.Next != "" {
+			page.Next = fmt.Sprintf("%d:%s", i, p.Next)
+			return page, nil
+		}
+
+		if len(page.Links) == opts.Size() {
+			break
+		}
+	}
+
+	if i+1 < len(c.layers) {
+		page.Next = fmt.Sprintf("%d:", i+1)
+	}
+
+	return page, nil
+}
+
+// Backup writes a snapshot of the first layer that supports it. Typically, this is the only writable layer; the other
+// layers (such as a catalogue of links) are maintained elsewhere, so do not need backing up.
+func (c *Chain) Backup(w io.Writer) (int64, error) {
+	for _, layer := range c.layers {
+		if b, ok := storage.AsBackuper(layer); ok {
+			return b.Backup(w)
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %s", storage.ErrFailed, "no layer backs up")
+}
+
+// CanBackup indicates whether any layer supports writing a snapshot. See storage.BackupChecker
+func (c *Chain) CanBackup() bool {
+	for _, layer := range c.layers {
+		if _, ok := storage.AsBackuper(layer); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Owns validates whether the agent owns the link, as held by the first layer that holds it. Links in layers that do
+// not track ownership are owned by nobody.
+func (c *Chain) Owns(ctx context.Context, u *url.URL) bool {
+	for _, layer := range c.layers {
+		if !holds(ctx, layer, u) {
+			continue
+		}
+
+		a, ok := layer.(storage.Authenticator)
+
+		return ok && a.Owns(ctx, u)
+	}
+
+	return false
+}
+
+// write attempts the write against each layer in turn, until one accepts it (or fails for some other reason).
+func (c *Chain) write(ctx context.Context, u *url.URL, fn func(layer storage.Storer) error) error {
+	for _, layer := range c.layers {
+		err := fn(layer)
+		if !errors.Is(err, storage.ErrReadOnlyStorage) {
+			return err
+		}
+
+		if holds(ctx, layer, u) {
+			return errShadowed
+		}
+	}
+
+	return storage.ErrReadOnlyStorage
+}
+
+// shadowed indicates whether a layer in front of the given one also holds a link at the address, such that the link
+// in the given layer is never read.
+func (c *Chain) shadowed(ctx context.Context, layer int, u *url.URL) bool {
+	for _, l := range c.layers[:layer] {
+		if holds(ctx, l, u) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cursor splits a cursor returned by List into the layer, and the cursor within that layer.
+func cursor(c string) (int, string, error) {
+	if c == "" {
+		return 0, "", nil
+	}
+
+	layer, inner, ok := strings.Cut(c, ":")
+	if !ok {
+		return 0, "", fmt.Errorf("%w: invalid cursor %q", storage.ErrFailed, c)
+	}
+
+	i, err := strconv.Atoi(layer)
+	if err != nil || i < 0 {
+		return 0, "", fmt.Errorf("%w: invalid cursor %q", storage.ErrFailed, c)
+	}
+
+	return i, inner, nil
+}
+
+// holds indicates whether the layer holds a link at the address.
+func holds(ctx context.Context, layer storage.Storer, u *url.URL) bool {
+	_, err := layer.Get(ctx, u)
+
+	return err == nil
+}
@@ -0,0 +1,149 @@
+package chain_test
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/andrewhowdencom/x40.link/storage"
+	"github.com/andrewhowdencom/x40.link/storage/chain"
+	"github.com/andrewhowdencom/x40.link/storage/memory"
+	"github.com/andrewhowdencom/x40.link/storage/yaml"
+	"github.com/stretchr/testify/assert"
+)
+
+// catalogue creates a read-only layer with a single "official" link.
+func catalogue(t *testing.T) storage.Storer {
+	y, err := yaml.New(memory.NewHashTable(), bytes.NewBufferString(`
+- from: //x40/official
+  to: //andrewhowden.com
+`))
+	assert.Nil(t, err)
+
+	return y
+}
+
+func TestChain(t *testing.T) {
+	t.Parallel()
+
+	official := &url.URL{Host: "x40", Path: "/official"}
+	mine := &url.URL{Host: "x40", Path: "/mine"}
+
+	for _, tc := range []struct {
+		name string
+		run  func(t *testing.T, c *chain.Chain, writable storage.Storer)
+	}{
+		{
+			name: "reads from the read-only layer",
+			run: func(t *testing.T, c *chain.Chain, _ storage.Storer) {
+				to, err := c.Get(context.Background(), official)
+				assert.Nil(t, err)
+				assert.Equal(t, &url.URL{Host: "andrewhowden.com"}, to)
+			},
+		},
+		{
+			name: "reads from the writable layer",
+			run: func(t *testing.T, c *chain.Chain, writable storage.Storer) {
+				assert.Nil(t, writable.Put(context.Background(), mine, &url.URL{Host: "k3s"}))
+
+				to, err := c.Get(context.Background(), mine)
+				assert.Nil(t, err)
+				assert.Equal(t, &url.URL{Host: "k3s"}, to)
+			},
+		},
+		{
+			name: "not found in any layer",
+			run: func(t *testing.T, c *chain.Chain, _ storage.Storer) {
+				_, err := c.Get(context.Background(), mine)
+				assert.ErrorIs(t, err, storage.ErrNotFound)
+			},
+		},
+		{
+			name: "writes to the first writable layer",
+			run: func(t *testing.T, c *chain.Chain, writable storage.Storer) {
+				assert.Nil(t, c.Put(context.Background(), mine, &url.URL{Host: "k3s"}))
+
+				to, err := writable.Get(context.Background(), mine)
+				assert.Nil(t, err)
+				assert.Equal(t, &url.URL{Host: "k3s"}, to)
+			},
+		},
+		{
+			name: "refuses to write links shadowed by the read-only layer",
+			run: func(t *testing.T, c *chain.Chain, writable storage.Storer) {
+				assert.ErrorIs(t, c.Put(context.Background(), official, &url.URL{Host: "k3s"}), storage.ErrReadOnlyStorage)
+				assert.ErrorIs(t,
+					c.Create(context.Background(), &storage.Link{From: official, To: &url.URL{Host: "k3s"}}),
+					storage.ErrAlreadyExists,
+				)
+
+				_, err := writable.Get(context.Background(), official)
+				assert.ErrorIs(t, err, storage.ErrNotFound)
+			},
+		},
+		{
+			name: "deletes from the layer that holds the link",
+			run: func(t *testing.T, c *chain.Chain, writable storage.Storer) {
+				assert.Nil(t, writable.Put(context.Background(), mine, &url.URL{Host: "k3s"}))
+
+				assert.Nil(t, c.Delete(context.Background(), mine))
+				assert.ErrorIs(t, c.Delete(context.Background(), mine), storage.ErrNotFound)
+				assert.ErrorIs(t, c.Delete(context.Background(), official), storage.ErrReadOnlyStorage)
+			},
+		},
+		{
+			name: "lists every layer, omitting shadowed links",
+			run: func(t *testing.T, c *chain.Chain, writable storage.Storer) {
+				for _, u := range []*url.URL{mine, official, {Host: "x40", Path: "/other"}} {
+					assert.Nil(t, writable.Put(context.Background(), u, &url.URL{Host: "k3s"}))
+				}
+
+				links := []string{}
+				opts := storage.ListOptions{Limit: 1}
+
+				for {
+					page, err := c.List(context.Background(), opts)
+					assert.Nil(t, err)
+
+					for _, l := range page.Links {
+						links = append(links, l.From.String()+" -> "+l.To.String())
+					}
+
+					if page.Next == "" {
+						break
+					}
+
+					opts.Cursor = page.Next
+				}
+
+				assert.Equal(t, []string{
+					"//x40/official -> //andrewhowden.com",
+					"//x40/mine -> //k3s",
+					"//x40/other -> //k3s",
+				}, links)
+			},
+		},
+	} {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			writable := memory.NewHashTable()
+			tc.run(t, chain.New(catalogue(t), writable), writable)
+		})
+	}
+}
+
+func TestChainReadOnly(t *testing.T) {
+	t.Parallel()
+
+	c := chain.New(catalogue(t))
+
+	assert.ErrorIs(t, c.Put(context.Background(), &url.URL{Host: "x40"}, &url.URL{Host: "k3s"}), storage.ErrReadOnlyStorage)
+	assert.ErrorIs(t,
+		c.Create(context.Background(), &storage.Link{From: &url.URL{Host: "x40"}, To: &url.URL{Host: "k3s"}}),
+		storage.ErrReadOnlyStorage,
+	)
+}
@@ -6,13 +6,19 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
-	"cloud.google.com/go/firestore"
 	"github.com/andrewhowdencom/x40.link/cfg"
 	"github.com/andrewhowdencom/x40.link/storage"
 	"github.com/andrewhowdencom/x40.link/storage/boltdb"
+	"github.com/andrewhowdencom/x40.link/storage/cache"
+	"github.com/andrewhowdencom/x40.link/storage/catalogue"
+	"github.com/andrewhowdencom/x40.link/storage/chain"
 	fsdb "github.com/andrewhowdencom/x40.link/storage/firestore"
 	"github.com/andrewhowdencom/x40.link/storage/memory"
+	"github.com/andrewhowdencom/x40.link/storage/postgres"
+	"github.com/andrewhowdencom/x40.link/storage/sqlite"
 	"github.com/andrewhowdencom/x40.link/storage/yaml"
 	"github.com/spf13/viper"
 )
@@ -25,48 +31,215 @@ var (
 // WireStorage generates a storage engine from the Viper based configuration. Fails
 // if there are no configuration values supplied.
 //
+// Each call opens the storage anew, along with its cache and the goroutines that maintain it; where the same storage
+// is used in more than one place (such as by both the gRPC API and the redirect server), it is generated once and
+// supplied to each.
+//
 // Doesn't actually use wire (yet)
 //
 // TODO: Rewrite this with the new configuration format.
 func WireStorage() (storage.Storer, error) {
-	if viper.GetBool(cfg.StorageHashMap.Path) {
-		return memory.NewHashTable(), nil
+	str, err := resolve(true)
+	if err != nil {
+		return nil, err
 	}
 
-	if path := viper.GetString(cfg.StorageYamlFile.Path); path != "" {
-		f, err := os.Open(path)
-		if err != nil {
-			return nil, fmt.Errorf("%w: %s", ErrCannotResolveStorage, err)
-		}
+	str, err = cached(str)
+	if err != nil {
+		return nil, err
+	}
 
-		y, err := yaml.New(memory.NewHashTable(), f)
-		if err != nil {
-			return nil, fmt.Errorf("%w: %s", ErrCannotResolveStorage, err)
-		}
+	if err := reap(str); err != nil {
+		return nil, err
+	}
+
+	return str, nil
+}
 
-		return y, nil
+// ResolveStorage generates a storage engine from the Viper based configuration, as WireStorage does, but without
+// anything that runs in the background: the catalogue is read once rather than watched, and the storage is neither
+// cached nor reaped. It suits commands that use the storage once and exit (such as export), which would otherwise
+// leave goroutines acting on the storage while they run.
+func ResolveStorage() (storage.Storer, error) {
+	return resolve(false)
+}
+
+// cached puts a cache in front of the storage, if one has been configured, and reports how effective it is.
+func cached(str storage.Storer) (storage.Storer, error) {
+	size := cfg.StorageCacheSize.Value()
+	if size <= 0 {
+		return str, nil
 	}
 
-	if path := viper.GetString(cfg.StorageBoltDBFile.Path); path != "" {
-		db, err := boltdb.New(path)
-		if err != nil {
-			return nil, fmt.Errorf("%w: %s", ErrCannotResolveStorage, err)
-		}
+	ttl, err := time.ParseDuration(cfg.StorageCacheTTL.Value())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCannotResolveStorage, err)
+	}
+
+	negative, err := time.ParseDuration(cfg.StorageCacheNegativeTTL.Value())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCannotResolveStorage, err)
+	}
+
+	stats, err := time.ParseDuration(cfg.StorageCacheStats.Value())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCannotResolveStorage, err)
+	}
+
+	c, err := cache.New(str, cache.WithSize(size), cache.WithTTL(ttl), cache.WithNegativeTTL(negative))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCannotResolveStorage, err)
+	}
+
+	if stats > 0 {
+		go cache.Report(context.Background(), c, stats)
+	}
+
+	return c, nil
+}
 
-		return db, nil
+// reap starts purging expired links from the storage in the background, if the storage supports it.
+func reap(str storage.Storer) error {
+	p, ok := str.(storage.Purger)
+	if !ok {
+		return nil
 	}
 
-	if project := viper.GetString(cfg.StorageFirestoreProject.Path); project != "" {
-		client, err := firestore.NewClient(context.Background(), project)
+	every, err := time.ParseDuration(cfg.StorageReaperInterval.Value())
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrCannotResolveStorage, err)
+	}
+
+	if every <= 0 {
+		return nil
+	}
+
+	go storage.Reap(context.Background(), p, every)
+
+	return nil
+}
+
+// engine is a storage engine that is able to be selected through configuration.
+type engine struct {
+	// configured indicates whether the engine has been configured.
+	configured func() bool
+
+	// build creates the storage engine from its configuration, following changes to it where watch is set and the
+	// engine is able to.
+	build func(watch bool) (storage.Storer, error)
+}
+
+// engines are the storage engines, by the name with which they're referred to in cfg.StorageLayers.
+var engines = map[string]engine{
+	"yaml": {
+		configured: func() bool { return viper.GetString(cfg.StorageYamlFile.Path) != "" },
+		build: func(watch bool) (storage.Storer, error) {
+			opts := []yaml.Option{yaml.WithFoldedHosts(cfg.ServerCaseInsensitiveHosts.List())}
+			if cfg.StorageYamlStrict.Value() {
+				opts = append(opts, yaml.WithStrict())
+			}
+
+			if name := cfg.StorageYamlFormat.Value(); name != "" {
+				f, err := catalogue.ParseFormat(name)
+				if err != nil {
+					return nil, fmt.Errorf("%w: %s", storage.ErrStorageSetupFailed, err)
+				}
+
+				opts = append(opts, yaml.WithFormat(f))
+			}
+
+			if !watch {
+				return yaml.Open(viper.GetString(cfg.StorageYamlFile.Path), opts...)
+			}
+
+			return yaml.Watch(context.Background(), viper.GetString(cfg.StorageYamlFile.Path), opts...)
+		},
+	},
+	"hash-map": {
+		configured: func() bool { return viper.GetBool(cfg.StorageHashMap.Path) },
+		build: func(bool) (storage.Storer, error) {
+			str, err := memory.New(memory.Layout(cfg.StorageHashMapLayout.Value()))
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", storage.ErrStorageSetupFailed, err)
+			}
 
+			return str, nil
+		},
+	},
+	"boltdb": {
+		configured: func() bool { return viper.GetString(cfg.StorageBoltDBFile.Path) != "" },
+		build: func(bool) (storage.Storer, error) {
+			return boltdb.New(viper.GetString(cfg.StorageBoltDBFile.Path))
+		},
+	},
+	"sqlite": {
+		configured: func() bool { return viper.GetString(cfg.StorageSQLiteFile.Path) != "" },
+		build: func(bool) (storage.Storer, error) {
+			return sqlite.New(viper.GetString(cfg.StorageSQLiteFile.Path))
+		},
+	},
+	"postgres": {
+		configured: func() bool { return viper.GetString(cfg.StoragePostgresDSN.Path) != "" },
+		build: func(bool) (storage.Storer, error) {
+			return postgres.New(context.Background(), viper.GetString(cfg.StoragePostgresDSN.Path))
+		},
+	},
+	"firestore": {
+		configured: func() bool {
+			return viper.GetString(cfg.StorageFirestoreProject.Path) != "" || os.Getenv(fsdb.EnvEmulatorHost) != ""
+		},
+		build: func(bool) (storage.Storer, error) {
+			return fsdb.New(context.Background(), viper.GetString(cfg.StorageFirestoreProject.Path))
+		},
+	},
+}
+
+// defaultLayers is the order in which the configured engines are layered, where the order is not configured. The
+// read-only catalogue goes in front, so that it is able to be served ahead of any writable storage.
+var defaultLayers = []string{"yaml", "hash-map", "boltdb", "sqlite", "postgres", "firestore"}
+
+// resolve creates the storage engines that have been configured. Where more than one has been configured, they are
+// layered (in the order given by cfg.StorageLayers, or otherwise defaultLayers) into a chain. Where watch is set,
+// engines that are able to follow changes to their configuration do so.
+func resolve(watch bool) (storage.Storer, error) {
+	names := defaultLayers
+	explicit := cfg.StorageLayers.Value() != ""
+
+	if explicit {
+		names = strings.Split(cfg.StorageLayers.Value(), ",")
+	}
+
+	layers := []storage.Storer{}
+	for _, n := range names {
+		n = strings.TrimSpace(n)
+
+		e, ok := engines[n]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown storage layer %q", ErrCannotResolveStorage, n)
+		}
+
+		if !e.configured() {
+			if explicit {
+				return nil, fmt.Errorf("%w: storage layer %q is not configured", ErrCannotResolveStorage, n)
+			}
+
+			continue
+		}
+
+		str, err := e.build(watch)
 		if err != nil {
 			return nil, fmt.Errorf("%w: %s", ErrCannotResolveStorage, err)
 		}
 
-		return fsdb.Firestore{
-			Client: client,
-		}, nil
+		layers = append(layers, str)
 	}
 
-	return nil, fmt.Errorf("%w: %s", ErrCannotResolveStorage, "no valid storage provider supplied")
+	switch len(layers) {
+	case 0:
+		return nil, fmt.Errorf("%w: %s", ErrCannotResolveStorage, "no valid storage provider supplied")
+	case 1:
+		return layers[0], nil
+	default:
+		return chain.New(layers...), nil
+	}
 }
@@ -1,16 +1,23 @@
 // Package firestore implements a storage layer with Google cloud firestore.
+//
+// Links with a path are purged (and listed by owner) across every host through the "id" collection group. Firestore
+// does not index fields for collection group queries by default, so the "expires" and "owner" fields of the "id"
+// collection group require a single field index with the collection group scope; without them, those queries fail with
+// FailedPrecondition. The emulator does not enforce indexes. See deploy/prod/tf/firestore.tf.
 package firestore
 
 import (
 	"context"
 	"fmt"
 	"net/url"
+	"os"
 	"path"
 	"strings"
 	"time"
 
 	"cloud.google.com/go/firestore"
 	"github.com/andrewhowdencom/x40.link/storage"
+	"google.golang.org/api/option"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -22,72 +29,325 @@ type document struct {
 
 	// Owner is the owner of the document
 	Owner string `firestore:"owner"`
+
+	// Created and Updated are when the document was first and last written
+	Created time.Time `firestore:"created"`
+	Updated time.Time `firestore:"updated"`
+
+	// Description, Tags and Status are the metadata describing the link. See storage.Link
+	Description string   `firestore:"description,omitempty"`
+	Tags        []string `firestore:"tags,omitempty"`
+	Status      int      `firestore:"status,omitempty"`
+
+	// Expires is when the link stops redirecting. Omitted where the link never expires.
+	Expires time.Time `firestore:"expires,omitempty"`
+
+	// Prefix is whether the link matches the paths beneath its own. See storage.Prefixer
+	Prefix bool `firestore:"prefix,omitempty"`
+
+	// Query is how the query string is forwarded to the destination. See storage.QueryPolicy
+	Query string `firestore:"query,omitempty"`
+
+	// Folded is the URL of the link, folded so that it is able to be found without regard to case. See storage.Fold
+	Folded string `firestore:"folded"`
+
+	// Template is whether the path of the link has placeholders, so that the templates on a host are able to be
+	// queried. See storage.Templater
+	Template bool `firestore:"template,omitempty"`
+}
+
+// newDocument converts the link into the document stored in firestore
+func newDocument(l *storage.Link) document {
+	return document{
+		To:          l.To.String(),
+		Owner:       l.Owner,
+		Created:     l.Created,
+		Updated:     l.Updated,
+		Description: l.Description,
+		Tags:        l.Tags,
+		Status:      l.Status,
+		Expires:     l.Expires,
+		Prefix:      l.Prefix,
+		Query:       string(l.Query),
+		Folded:      storage.Fold(l.From),
+		Template:    l.IsTemplate(),
+	}
+}
+
+// link converts the document back into a link
+func (d *document) link(from *url.URL) (*storage.Link, error) {
+	to, err := url.Parse(d.To)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", storage.ErrCorrupt, err)
+	}
+
+	return &storage.Link{
+		From:        from,
+		To:          to,
+		Owner:       d.Owner,
+		Created:     d.Created,
+		Updated:     d.Updated,
+		Description: d.Description,
+		Tags:        d.Tags,
+		Status:      d.Status,
+		Expires:     d.Expires,
+		Prefix:      d.Prefix,
+		Query:       storage.QueryPolicy(d.Query),
+	}, nil
 }
 
 // FirestoreCollection is the collection (in practice, path prefix) for accessing URL content.
 const FirestoreCollection = "links"
 
+// idCollection is the collection beneath each host document in which links with a path are stored.
+const idCollection = "id"
+
+// EnvEmulatorHost is the environment variable that, where set, directs the client to the Firestore emulator at the
+// given address (e.g. localhost:8500) rather than to Google Cloud. See
+// https://cloud.google.com/firestore/docs/emulator
+const EnvEmulatorHost = "FIRESTORE_EMULATOR_HOST"
+
+// emulatorProject is the project used with the emulator, where none is supplied. The emulator accepts any project.
+const emulatorProject = "x40-link"
+
 // Firestore is the implementation of Google Cloud firestore backed storage
 type Firestore struct {
 	Client *firestore.Client
 }
 
+// New connects to Firestore in the given project. Where EnvEmulatorHost is set, connects to the emulator instead; the
+// project is then optional.
+func New(ctx context.Context, project string, opts ...option.ClientOption) (*Firestore, error) {
+	if project == "" && os.Getenv(EnvEmulatorHost) != "" {
+		project = emulatorProject
+	}
+
+	client, err := firestore.NewClient(ctx, project, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", storage.ErrFailed, err)
+	}
+
+	return &Firestore{Client: client}, nil
+}
+
 // Get fetches a URL from storage
-func (fs Firestore) Get(_ context.Context, url *url.URL) (*url.URL, error) {
-	ref := fs.Client.Doc(urlToPath(url))
-	doc, err := fs.doc(ref)
+func (fs Firestore) Get(ctx context.Context, url *url.URL) (*url.URL, error) {
+	l, err := fs.GetLink(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.To, nil
+}
+
+// GetLink fetches a link, complete with its metadata, from storage
+func (fs Firestore) GetLink(ctx context.Context, url *url.URL) (*storage.Link, error) {
+	url = storage.Normalize(url)
+
+	snap, err := fs.Client.Doc(urlToPath(url)).Get(ctx)
 	if status.Code(err) == codes.NotFound {
 		return nil, fmt.Errorf("%w: %s", storage.ErrNotFound, "data at path not found")
 	} else if err != nil {
-		return nil, fmt.Errorf("%w: %s", storage.ErrFailed, err)
+		return nil, failed(ctx, err)
 	}
 
-	to, err := url.Parse(doc.To)
+	return decode(snap, url)
+}
+
+// GetFolded fetches a link whose URL differs from the supplied URL only by case, by querying the links on its host by
+// their folded URL. See storage.Folder.
+//
+// Documents written before the folded URL was stored do not have one, so are not found until they are next written.
+func (fs Firestore) GetFolded(ctx context.Context, u *url.URL) (*storage.Link, error) {
+	u = storage.Normalize(u)
+
+	// The host is already in lower case, so a link without a path is only able to differ from it by its path.
+	if u.Path == "" {
+		return fs.GetLink(ctx, u)
+	}
+
+	snaps, err := fs.Client.Collection(path.Join(FirestoreCollection, u.Host, idCollection)).
+		Where("folded", "==", storage.Fold(u)).
+		Limit(1).
+		Documents(ctx).
+		GetAll()
 	if err != nil {
-		return nil, fmt.Errorf("%w: %s", storage.ErrCorrupt, err)
+		return nil, failed(ctx, err)
+	}
+
+	if len(snaps) == 0 {
+		return nil, fmt.Errorf("%w: %s", storage.ErrNotFound, "data at path not found")
+	}
+
+	return decode(snaps[0], refToURL(snaps[0].Ref))
+}
+
+// Templates returns the templates on the host, by querying the links on the host that are flagged as templates. See
+// storage.Templater.
+//
+// Documents written before the flag was stored do not have one, so are not found until they are next written.
+func (fs Firestore) Templates(ctx context.Context, host string) ([]*storage.Link, error) {
+	snaps, err := fs.Client.Collection(path.Join(FirestoreCollection, host, idCollection)).
+		Where("template", "==", true).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return nil, failed(ctx, err)
+	}
+
+	found := make([]*storage.Link, 0, len(snaps))
+	for _, snap := range snaps {
+		l, err := decode(snap, refToURL(snap.Ref))
+		if err != nil {
+			return nil, err
+		}
+
+		found = append(found, l)
 	}
 
-	return to, nil
+	return found, nil
 }
 
 // Put writes a URL into storage
-// TODO: Write tests for all this.
 func (fs Firestore) Put(ctx context.Context, from *url.URL, to *url.URL) error {
-	ref := fs.Client.Doc(urlToPath(from))
-	agent, _ := ctx.Value(storage.CtxKeyAgent).(string)
+	return fs.PutLink(ctx, &storage.Link{From: from, To: to})
+}
 
-	// Fetch the owner from the request (if there is one)
-	owner := ""
-	val, ok := ctx.Value(storage.CtxKeyAgent).(string)
-	if ok && val != "" {
-		owner = val
-	}
+// PutLink writes a link, complete with its metadata, into storage. Where there is already a link at the same address,
+// only its owner is able to replace it.
+//
+// The link is read (to check its owner) and written in the same transaction, so there is no opportunity for another
+// writer to race between the check and the write; Firestore retries the transaction where the link changes after it
+// was read.
+func (fs Firestore) PutLink(ctx context.Context, l *storage.Link) error {
+	l = storage.NormalizeLink(l)
 
-	// See if there is a document already, and if so, see who owns it.
-	doc, err := fs.doc(ref)
-	status, _ := status.FromError(err)
+	ref := fs.Client.Doc(urlToPath(l.From))
 
-	if status.Code() != codes.OK && status.Code() != codes.NotFound {
-		return fmt.Errorf("%w: %s", storage.ErrFailed, err)
-	}
+	return fs.transaction(ctx, func(tx *firestore.Transaction) error {
+		existing, err := get(ctx, tx, ref, l.From)
+		if err != nil {
+			return err
+		}
 
-	if status.Code() != codes.NotFound && doc.Owner != agent {
-		return storage.ErrUnauthorized
-	}
+		if err := storage.Authorize(ctx, existing); err != nil {
+			return err
+		}
 
-	// Try and create the document
-	_, err = ref.Set(context.Background(), document{
-		To:    to.String(),
-		Owner: owner,
+		return tx.Set(ref, newDocument(storage.Stamp(ctx, l, existing)))
 	})
+}
 
-	if err != nil {
-		return err
+// Create writes a URL into storage, but only if there is not already a document at that path. Firestore rejects the
+// creation of a document that already exists, so there is no need to check first.
+func (fs Firestore) Create(ctx context.Context, l *storage.Link) error {
+	l = storage.NormalizeLink(l)
+
+	ref := fs.Client.Doc(urlToPath(l.From))
+
+	_, err := ref.Create(ctx, newDocument(storage.Stamp(ctx, l, nil)))
+
+	if status.Code(err) == codes.AlreadyExists {
+		return storage.ErrAlreadyExists
+	} else if err != nil {
+		return failed(ctx, err)
 	}
 
 	return nil
 }
 
+// CreateFolded writes a URL into storage, but only if there is neither a document at that path, nor one on the same
+// host whose folded URL is the same. The query for the folded URL is made in the same transaction as the document is
+// created, so Firestore retries (or fails) the transaction where such a document is written after the query was made.
+// See storage.FoldedCreator.
+//
+// Documents written before the folded URL was stored do not have one, so do not collide until they are next written.
+func (fs Firestore) CreateFolded(ctx context.Context, l *storage.Link) error {
+	l = storage.NormalizeLink(l)
+
+	ref := fs.Client.Doc(urlToPath(l.From))
+
+	return fs.transaction(ctx, func(tx *firestore.Transaction) error {
+		existing, err := get(ctx, tx, ref, l.From)
+		if err != nil {
+			return err
+		}
+
+		if existing != nil {
+			return storage.ErrAlreadyExists
+		}
+
+		// The host is already in lower case, so a link without a path is only able to differ from it by its path.
+		if l.From.Path != "" {
+			snaps, err := tx.Documents(fs.Client.Collection(path.Join(FirestoreCollection, l.From.Host, idCollection)).
+				Where("folded", "==", storage.Fold(l.From)).
+				Limit(1)).
+				GetAll()
+			if err != nil {
+				return failed(ctx, err)
+			}
+
+			if len(snaps) > 0 {
+				return storage.ErrCaseCollision
+			}
+		}
+
+		return tx.Create(ref, newDocument(storage.Stamp(ctx, l, nil)))
+	})
+}
+
+// Delete removes a URL from storage. Only the owner of the document is able to remove it; as with PutLink, the owner
+// is checked in the same transaction as the document is removed.
+func (fs Firestore) Delete(ctx context.Context, u *url.URL) error {
+	u = storage.Normalize(u)
+
+	ref := fs.Client.Doc(urlToPath(u))
+
+	return fs.transaction(ctx, func(tx *firestore.Transaction) error {
+		existing, err := get(ctx, tx, ref, u)
+		if err != nil {
+			return err
+		}
+
+		if existing == nil {
+			return fmt.Errorf("%w: %s", storage.ErrNotFound, "data at path not found")
+		}
+
+		if err := storage.Authorize(ctx, existing); err != nil {
+			return err
+		}
+
+		return tx.Delete(ref)
+	})
+}
+
+// Purge removes the documents that have expired, from both the host documents and the path documents. Documents
+// that never expire have no expiry field, and so are not matched by the query. Requires the collection group index on
+// "expires"; see the package documentation.
+func (fs Firestore) Purge(ctx context.Context, before time.Time) (int, error) {
+	n := 0
+
+	for _, q := range []firestore.Query{
+		fs.Client.Collection(FirestoreCollection).Query,
+		fs.Client.CollectionGroup(idCollection).Query,
+	} {
+		snaps, err := q.Where("expires", "<=", before).Documents(ctx).GetAll()
+		if err != nil {
+			return n, failed(ctx, err)
+		}
+
+		for _, snap := range snaps {
+			if _, err := snap.Ref.Delete(ctx); err != nil {
+				return n, failed(ctx, err)
+			}
+
+			n++
+		}
+	}
+
+	return n, nil
+}
+
 // Owns implements the interface validating whether a user actually owns this record.
 func (fs Firestore) Owns(ctx context.Context, u *url.URL) bool {
 	// See who is requesting this data
@@ -96,34 +356,153 @@ func (fs Firestore) Owns(ctx context.Context, u *url.URL) bool {
 		return false
 	}
 
-	ref := fs.Client.Doc(urlToPath(u))
-	if ref == nil {
-		return false
+	l, err := fs.GetLink(ctx, u)
+
+	return err == nil && l.Owner == agent
+}
+
+// List pages through the URLs in storage. Links on a host (without a path) are stored as documents in the links
+// collection, with links that have a path stored in an "id" collection beneath the host document. Given this, listing
+// runs in two phases: first through the host documents, and then through the path documents. The cursor is the path
+// of the last document returned, which also indicates the phase from which to continue.
+func (fs Firestore) List(ctx context.Context, opts storage.ListOptions) (*storage.Page, error) {
+	page := &storage.Page{Links: []*storage.Link{}}
+	cursor := opts.Cursor
+
+	// The first phase: documents on the host itself. Skipped if the cursor is already in the second phase.
+	if !strings.Contains(cursor, "/"+idCollection+"/") {
+		q := fs.Client.Collection(FirestoreCollection).Query
+		if opts.Host != "" {
+			q = q.Where(firestore.DocumentID, "==", fs.Client.Doc(path.Join(FirestoreCollection, opts.Host)))
+		}
+
+		done, err := fs.page(ctx, q, cursor, opts, page)
+		if err != nil || done {
+			return page, err
+		}
+
+		cursor = ""
 	}
 
-	doc, err := fs.doc(ref)
-	if err != nil {
-		return false
+	// The second phase: documents with a path, either on all hosts or on a specific one.
+	q := fs.Client.CollectionGroup(idCollection).Query
+	if opts.Host != "" {
+		q = fs.Client.Collection(path.Join(FirestoreCollection, opts.Host, idCollection)).Query
+	}
+
+	if _, err := fs.page(ctx, q, cursor, opts, page); err != nil {
+		return nil, err
 	}
 
-	return doc.Owner == agent
+	return page, nil
 }
 
-func (fs Firestore) doc(ref *firestore.DocumentRef) (*document, error) {
-	ctx, cxl := context.WithTimeout(context.Background(), time.Second*30)
-	defer cxl()
+// page runs the query from the cursor, appending the results to the page until the page is full. Returns whether the
+// page is full (and thus, whether there is a need to continue querying).
+func (fs Firestore) page(
+	ctx context.Context,
+	q firestore.Query,
+	cursor string,
+	opts storage.ListOptions,
+	page *storage.Page,
+) (bool, error) {
+	remaining := opts.Size() - len(page.Links)
+
+	q = q.OrderBy(firestore.DocumentID, firestore.Asc)
+	if opts.Owner != "" {
+		q = q.Where("owner", "==", opts.Owner)
+	}
+
+	if cursor != "" {
+		q = q.StartAfter(fs.Client.Doc(cursor))
+	}
 
-	doc, err := ref.Get(ctx)
+	// Query for a single document more than is needed, so as to know whether there is another page.
+	snaps, err := q.Limit(remaining + 1).Documents(ctx).GetAll()
 	if err != nil {
-		return nil, err
+		return false, failed(ctx, err)
+	}
+
+	for i, snap := range snaps {
+		if i == remaining {
+			page.Next = urlToPath(page.Links[len(page.Links)-1].From)
+			return true, nil
+		}
+
+		l, err := decode(snap, refToURL(snap.Ref))
+		if err != nil {
+			return false, err
+		}
+
+		page.Links = append(page.Links, l)
+	}
+
+	return false, nil
+}
+
+// transaction runs the function in a transaction, which is retried by Firestore where the documents it read are
+// changed before it commits. Errors from the function are returned as they are; those from Firestore itself are
+// wrapped.
+func (fs Firestore) transaction(ctx context.Context, fn func(tx *firestore.Transaction) error) error {
+	var fnErr error
+
+	err := fs.Client.RunTransaction(ctx, func(_ context.Context, tx *firestore.Transaction) error {
+		fnErr = fn(tx)
+
+		return fnErr
+	})
+
+	if fnErr != nil {
+		return fnErr
+	} else if err != nil {
+		return failed(ctx, err)
+	}
+
+	return nil
+}
+
+// get reads the link within the transaction, returning nil (rather than an error) where there is no link.
+func get(ctx context.Context, tx *firestore.Transaction, ref *firestore.DocumentRef, u *url.URL) (*storage.Link, error) {
+	snap, err := tx.Get(ref)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, failed(ctx, err)
 	}
 
-	result := &document{}
-	if err := doc.DataTo(result); err != nil {
+	return decode(snap, u)
+}
+
+// decode converts the document snapshot into a link.
+func decode(snap *firestore.DocumentSnapshot, from *url.URL) (*storage.Link, error) {
+	doc := &document{}
+	if err := snap.DataTo(doc); err != nil {
 		return nil, fmt.Errorf("%w: %s", storage.ErrCorrupt, err)
 	}
 
-	return result, nil
+	return doc.link(from)
+}
+
+// failed wraps an error returned by Firestore. Where the context has been cancelled (or its deadline exceeded), the
+// error of the context is wrapped as well, so that callers are able to tell that the operation was abandoned.
+func failed(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("%w: %w", storage.ErrFailed, ctx.Err())
+	}
+
+	return fmt.Errorf("%w: %s", storage.ErrFailed, err)
+}
+
+// refToURL converts a document reference back into the URL from which its path was derived. The inverse of urlToPath.
+func refToURL(ref *firestore.DocumentRef) *url.URL {
+	if ref.Parent.ID == FirestoreCollection {
+		return &url.URL{Host: ref.ID}
+	}
+
+	return &url.URL{
+		Host: ref.Parent.Parent.ID,
+		Path: strings.Replace(ref.ID, "+", "/", -1),
+	}
 }
 
 // urlToPath converts the URL into a document ID.
@@ -131,7 +510,7 @@ func urlToPath(url *url.URL) string {
 	p := []string{FirestoreCollection, url.Host}
 
 	if url.Path != "" {
-		p = append(p, "id", strings.Replace(url.Path, "/", "+", -1))
+		p = append(p, idCollection, strings.Replace(url.Path, "/", "+", -1))
 	}
 
 	return path.Join(p...)
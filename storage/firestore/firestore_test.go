@@ -0,0 +1,41 @@
+package firestore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/andrewhowdencom/x40.link/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestNewEmulator validates that the project is optional where the emulator is used, but required otherwise. The
+// client connects lazily, so no emulator need be running.
+func TestNewEmulator(t *testing.T) {
+	t.Setenv(EnvEmulatorHost, "")
+
+	_, err := New(context.Background(), "")
+	assert.ErrorIs(t, err, storage.ErrFailed)
+
+	t.Setenv(EnvEmulatorHost, "localhost:8500")
+
+	fs, err := New(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Nil(t, fs.Client.Close())
+}
+
+// TestFailed validates that the error of a context that has ended is able to be told apart from other failures.
+func TestFailed(t *testing.T) {
+	t.Parallel()
+
+	err := failed(context.Background(), errors.New("unavailable"))
+	assert.ErrorIs(t, err, storage.ErrFailed)
+	assert.False(t, errors.Is(err, context.Canceled))
+
+	ctx, cxl := context.WithCancel(context.Background())
+	cxl()
+
+	err = failed(ctx, errors.New("canceled"))
+	assert.ErrorIs(t, err, storage.ErrFailed)
+	assert.ErrorIs(t, err, context.Canceled)
+}
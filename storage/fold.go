@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// ErrCaseCollision is returned where a link is written to a host whose paths are matched without regard to case, and
+// there is already a link at a path that differs from it only by case. It is also an ErrAlreadyExists.
+var ErrCaseCollision = fmt.Errorf("%w: %s", ErrAlreadyExists, "in a different case")
+
+// AllHosts is the entry in FoldedHosts that matches every host.
+const AllHosts = "*"
+
+// FoldedHosts are the hosts on which the paths of links are matched without regard to case, such that //x40/AbC finds
+// the link at //x40/abc. Links on those hosts are still stored (and reported) in the case in which they were written.
+type FoldedHosts []string
+
+// Contains indicates whether the paths of URLs on the host of the URL are matched without regard to case.
+func (h FoldedHosts) Contains(u *url.URL) bool {
+	host := Normalize(u).Host
+
+	return slices.ContainsFunc(h, func(f string) bool {
+		return f == AllHosts || Normalize(&url.URL{Host: f}).Host == host
+	})
+}
+
+// Fold returns the key under which a URL is indexed to be found without regard to case: the normalized URL (see
+// Normalize), as a string, in lower case. Characters outside of ASCII are escaped in the string, so only ASCII letters
+// are folded; this is the same as the lower() function of SQL databases, so they are able to index it themselves.
+func Fold(u *url.URL) string {
+	return strings.ToLower(Normalize(u).String())
+}
+
+// Folder is an extension to the storage interface that finds a link whose URL differs from the supplied URL only by
+// the case of its path, for storage that keeps an index of the folded URL of each link (see Fold). Where more than one
+// link differs only by case (as is possible on a host that was not always folded), which of them is returned is
+// unspecified. Returns ErrNotFound where there is no such link.
+type Folder interface {
+	GetFolded(ctx context.Context, u *url.URL) (*Link, error)
+}
+
+// GetFolded finds a link whose URL differs from the supplied URL only by the case of its path. Where the storage is not
+// a Folder, links are not able to be found without regard to case and ErrNotFound is returned.
+func GetFolded(ctx context.Context, str Storer, u *url.URL) (*Link, error) {
+	f, ok := str.(Folder)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return f.GetFolded(ctx, Normalize(u))
+}
+
+// FoldedCreator is an extension to the storage interface that creates a link only where there is no link at a URL that
+// differs from it only by case, as well as none at its URL. The check is made as part of the same write as the link, so
+// there is no opportunity for another writer to create a link that differs only by case between the two.
+//
+// Every link is checked against, however it was written: links written with PutLink (which does not check), or before
+// the host was folded, are found by GetFolded, and prevent the creation of links that differ from them only by case,
+// the same as those written with CreateFolded.
+//
+// Returns ErrCaseCollision where there is a link at a URL that differs only by case, and ErrAlreadyExists where there
+// is a link at the URL itself.
+type FoldedCreator interface {
+	CreateFolded(ctx context.Context, l *Link) error
+}
+
+// Create writes the link only where there is not already a link at its URL (see Creator), or, where its host is one of
+// the folded hosts, at a URL that differs from it only by case (see FoldedCreator).
+//
+// Storage that is not a FoldedCreator does not find links without regard to case either, so on it links on the folded
+// hosts are created as any other. Storage that is not a Creator is not able to tell whether there is already a link,
+// so the link is written regardless (see PutLink).
+func Create(ctx context.Context, str Storer, hosts FoldedHosts, l *Link) error {
+	if f, ok := str.(FoldedCreator); ok && hosts.Contains(l.From) {
+		return f.CreateFolded(ctx, l)
+	}
+
+	if c, ok := str.(Creator); ok {
+		return c.Create(ctx, l)
+	}
+
+	return PutLink(ctx, str, l)
+}
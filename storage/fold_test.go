@@ -0,0 +1,107 @@
+package storage_test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/andrewhowdencom/x40.link/storage"
+	"github.com/andrewhowdencom/x40.link/storage/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+// lister hides every extension of the storage other than listing, such that the fallbacks are exercised.
+type lister struct {
+	storage.Storer
+	storage.Lister
+}
+
+func TestFold(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		in       string
+		expected string
+	}{
+		{in: "//x40/abc", expected: "//x40/abc"},
+		{in: "https://X40:443/AbC?q=1", expected: "//x40/abc"},
+		{in: "//x40/F%C3%96%C3%96", expected: "//x40/f%c3%96%c3%96"},
+		{in: "//x40/a%20B", expected: "//x40/a%20b"},
+	} {
+		u, err := url.Parse(tc.in)
+		assert.Nil(t, err, tc.in)
+
+		assert.Equal(t, tc.expected, storage.Fold(u), tc.in)
+	}
+}
+
+func TestFoldedHostsContains(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		hosts    storage.FoldedHosts
+		in       *url.URL
+		expected bool
+	}{
+		{hosts: nil, in: &url.URL{Host: "x40"}},
+		{hosts: storage.FoldedHosts{"x40"}, in: &url.URL{Host: "x40", Path: "/AbC"}, expected: true},
+		{hosts: storage.FoldedHosts{"X40:443"}, in: &url.URL{Host: "x40"}, expected: true},
+		{hosts: storage.FoldedHosts{"x40"}, in: &url.URL{Host: "X40:80"}, expected: true},
+		{hosts: storage.FoldedHosts{"x40"}, in: &url.URL{Host: "k3s"}},
+		{hosts: storage.FoldedHosts{storage.AllHosts}, in: &url.URL{Host: "k3s"}, expected: true},
+	} {
+		assert.Equal(t, tc.expected, tc.hosts.Contains(tc.in), "%v %s", tc.hosts, tc.in)
+	}
+}
+
+func TestGetFolded(t *testing.T) {
+	t.Parallel()
+
+	ht := memory.NewHashTable()
+	assert.Nil(t, ht.Put(context.Background(), &url.URL{Host: "x40", Path: "/AbC"}, &url.URL{Host: "k3s"}))
+
+	l, err := storage.GetFolded(context.Background(), ht, &url.URL{Host: "X40", Path: "/abc"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, "//x40/AbC", l.From.String())
+	}
+
+	_, err = storage.GetFolded(context.Background(), ht, &url.URL{Host: "x40", Path: "/abd"})
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	// Storage that does not index the folded URL does not find links without regard to case, even where it lists.
+	_, err = storage.GetFolded(context.Background(), lister{Storer: ht, Lister: ht}, &url.URL{Host: "x40", Path: "/abc"})
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestCreate(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		hosts storage.FoldedHosts
+		path  string
+		err   error
+	}{
+		{hosts: storage.FoldedHosts{"x40"}, path: "/abc", err: storage.ErrCaseCollision},
+		{hosts: storage.FoldedHosts{"x40"}, path: "/AbC", err: storage.ErrAlreadyExists},
+		{hosts: storage.FoldedHosts{"x40"}, path: "/abd"},
+		{hosts: storage.FoldedHosts{"k3s"}, path: "/abc"},
+		{path: "/abc"},
+	} {
+		str := memory.NewHashTable()
+		assert.Nil(t, str.Put(context.Background(), &url.URL{Host: "x40", Path: "/AbC"}, &url.URL{Host: "k3s"}))
+
+		l := &storage.Link{From: &url.URL{Host: "x40", Path: tc.path}, To: &url.URL{Host: "k3s"}}
+
+		assert.ErrorIs(t, storage.Create(context.Background(), str, tc.hosts, l), tc.err, "%v %s", tc.hosts, tc.path)
+	}
+
+	// Storage that is not able to create writes the link regardless.
+	str := lister{Storer: memory.NewHashTable()}
+	l := &storage.Link{From: &url.URL{Host: "x40", Path: "/abc"}, To: &url.URL{Host: "k3s"}}
+
+	assert.Nil(t, storage.Create(context.Background(), str, nil, l))
+	assert.Nil(t, storage.Create(context.Background(), str, nil, l))
+
+	// A collision is also a link that already exists.
+	assert.ErrorIs(t, storage.ErrCaseCollision, storage.ErrAlreadyExists)
+}
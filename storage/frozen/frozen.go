@@ -0,0 +1,59 @@
+// Package frozen holds the rules by which the storage migrations rewrite links, as they were when each migration was
+// written. The rules in the storage package change along with the storage; those here must not, or a database migrated
+// before such a change and one migrated after it would hold different keys for the same links.
+//
+// As with the migrations themselves, a rule that has been released must never be modified; instead, add another.
+package frozen
+
+import (
+	"net"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// defaultPorts are the ports that Normalize drops from the host.
+var defaultPorts = []string{"80", "443"}
+
+// placeholder matches a placeholder (e.g. {repo}) of a link template.
+var placeholder = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Normalize returns the key under which the link at the URL is stored, as storage.Normalize did when keys were first
+// normalized: the host and path alone, with the host in lower case, encoded as punycode where it is internationalized
+// and without a port where it is the default.
+func Normalize(u *url.URL) *url.URL {
+	host, port := strings.TrimSuffix(strings.ToLower(u.Hostname()), "."), u.Port()
+
+	// Hosts that are not valid domain names (such as IP addresses, or names with underscores) are left as they are.
+	if ascii, err := idna.Lookup.ToASCII(host); err == nil {
+		host = ascii
+	}
+
+	if slices.Contains(defaultPorts, port) {
+		port = ""
+	}
+
+	switch {
+	case port != "":
+		host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"):
+		host = "[" + host + "]"
+	}
+
+	return &url.URL{Host: host, Path: u.Path}
+}
+
+// Fold returns the key under which the link at the URL is indexed to be found without regard to case, as storage.Fold
+// did when the index was first built: the normalized URL, as a string, in lower case.
+func Fold(u *url.URL) string {
+	return strings.ToLower(Normalize(u).String())
+}
+
+// IsTemplate indicates whether the path is that of a link template, as storage.Link.IsTemplate did when templates were
+// first flagged: where it has a placeholder anywhere within it.
+func IsTemplate(path string) bool {
+	return placeholder.MatchString(path)
+}
@@ -0,0 +1,73 @@
+package frozen_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/andrewhowdencom/x40.link/storage/frozen"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		in       string
+		expected string
+	}{
+		{in: "//x40.link/Abc", expected: "//x40.link/Abc"},
+		{in: "//X40.LINK/Abc", expected: "//x40.link/Abc"},
+		{in: "https://x40.link:443/Abc?utm_source=mail#top", expected: "//x40.link/Abc"},
+		{in: "//x40.link:80/Abc", expected: "//x40.link/Abc"},
+		{in: "//x40.link:8080/Abc", expected: "//x40.link:8080/Abc"},
+		{in: "//x40.link./Abc", expected: "//x40.link/Abc"},
+		{in: "//BÜCHER.example/Abc", expected: "//xn--bcher-kva.example/Abc"},
+		{in: "//[::1]:443/Abc", expected: "//[::1]/Abc"},
+		{in: "//[::1]:8080/Abc", expected: "//[::1]:8080/Abc"},
+		{in: "//a_b.test/Abc", expected: "//a_b.test/Abc"},
+		{in: "//x40.link/Abc/", expected: "//x40.link/Abc/"},
+	} {
+		u, err := url.Parse(tc.in)
+		assert.Nil(t, err, tc.in)
+
+		assert.Equal(t, tc.expected, frozen.Normalize(u).String(), tc.in)
+	}
+}
+
+func TestFold(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		in       string
+		expected string
+	}{
+		{in: "//x40/abc", expected: "//x40/abc"},
+		{in: "https://X40:443/AbC?q=1", expected: "//x40/abc"},
+		{in: "//x40/F%C3%96%C3%96", expected: "//x40/f%c3%96%c3%96"},
+		{in: "//x40/a%20B", expected: "//x40/a%20b"},
+	} {
+		u, err := url.Parse(tc.in)
+		assert.Nil(t, err, tc.in)
+
+		assert.Equal(t, tc.expected, frozen.Fold(u), tc.in)
+	}
+}
+
+func TestIsTemplate(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		in       string
+		expected bool
+	}{
+		{in: "/gh/{repo}", expected: true},
+		{in: "/gh/{org}/{repo}", expected: true},
+		{in: "/gh/x{repo}", expected: true},
+		{in: "/gh/{_repo1}", expected: true},
+		{in: "/gh/repo"},
+		{in: "/gh/{1repo}"},
+		{in: "/gh/{}"},
+	} {
+		assert.Equal(t, tc.expected, frozen.IsTemplate(tc.in), tc.in)
+	}
+}
@@ -0,0 +1,137 @@
+package memory_test
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/andrewhowdencom/x40.link/storage"
+	"github.com/andrewhowdencom/x40.link/storage/memory"
+)
+
+// layouts are the implementations benchmarked against each other, along with the largest number of links at which
+// each is benchmarked. The linear and binary searches are too slow to fill (or, for the linear search, query) at
+// the larger sizes in a reasonable time.
+//
+// At 1e7 links, the hash table and radix trie each need several gigabytes of memory.
+var layouts = []struct {
+	name string
+	new  func() storage.Storer
+	max  int
+}{
+	{name: "hash table", new: func() storage.Storer { return memory.NewHashTable() }, max: 1e7},
+	{name: "radix trie", new: func() storage.Storer { return memory.NewRadixTrie() }, max: 1e7},
+	{name: "binary search", new: func() storage.Storer { return memory.NewBinarySearch() }, max: 1e4},
+	{name: "linear search", new: func() storage.Storer { return memory.NewLinearSearch() }, max: 1e4},
+}
+
+// sizes are the number of links at which each implementation is benchmarked.
+var sizes = []int{1e3, 1e4, 1e5, 1e6, 1e7}
+
+// dest is the destination of every link; it does not matter for the benchmarks.
+var dest = &url.URL{Host: "andrewhowden.com", Path: "/benchmarks"}
+
+// key generates the URL of the nth link. The links share a host and are spread across a handful of path prefixes,
+// approximating how short links are laid out in practice.
+func key(n int) *url.URL {
+	return &url.URL{Host: "x40", Path: fmt.Sprintf("/%s/%s", strconv.Itoa(n%16), strconv.FormatInt(int64(n)*7919, 36))}
+}
+
+// fill creates a storage with the given number of links.
+func fill(b *testing.B, str storage.Storer, n int) []*url.URL {
+	urls := make([]*url.URL, n)
+
+	for i := range urls {
+		urls[i] = key(i)
+
+		if err := str.Put(context.Background(), urls[i], dest); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	return urls
+}
+
+// BenchmarkGet benchmarks looking up links that exist, in a storage holding a given number of links.
+func BenchmarkGet(b *testing.B) {
+	for _, l := range layouts {
+		for _, n := range sizes {
+			if n > l.max {
+				continue
+			}
+
+			b.Run(fmt.Sprintf("%s+%d", l.name, n), func(b *testing.B) {
+				str := l.new()
+				urls := fill(b, str, n)
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := str.Get(context.Background(), urls[i%n]); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkPut benchmarks writing new links into a storage already holding a given number of links.
+func BenchmarkPut(b *testing.B) {
+	for _, l := range layouts {
+		for _, n := range sizes {
+			if n > l.max {
+				continue
+			}
+
+			b.Run(fmt.Sprintf("%s+%d", l.name, n), func(b *testing.B) {
+				str := l.new()
+				fill(b, str, n)
+
+				urls := make([]*url.URL, b.N)
+				for i := range urls {
+					urls[i] = key(n + i)
+				}
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err := str.Put(context.Background(), urls[i], dest); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkList benchmarks listing a page of links from the middle of a storage holding a given number of links. Only
+// the implementations able to list are benchmarked.
+func BenchmarkList(b *testing.B) {
+	for _, l := range layouts {
+		for _, n := range sizes {
+			if n > l.max {
+				continue
+			}
+
+			b.Run(fmt.Sprintf("%s+%d", l.name, n), func(b *testing.B) {
+				str := l.new()
+
+				lister, ok := str.(storage.Lister)
+				if !ok {
+					b.Skip("storage does not list")
+				}
+
+				urls := fill(b, str, n)
+				opts := storage.ListOptions{Cursor: urls[n/2].String()}
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := lister.List(context.Background(), opts); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
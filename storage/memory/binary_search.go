@@ -3,6 +3,7 @@ package memory
 import (
 	"context"
 	"net/url"
+	"slices"
 	"sync"
 
 	"github.com/andrewhowdencom/x40.link/storage"
@@ -12,8 +13,8 @@ import (
 // than the linear search, on average. Best case, its O(1) if the record is (somehow) in the middle,
 // worst case its O(log(n))
 //
-// TODO: This probably needs to be a linked list, rather than a slice. Slice will be enormously inefficient
-// when we're talking about reallocating that dat.
+// Inserting into the slice means reallocating it, which is enormously inefficient for large data sets. The RadixTrie
+// keeps the data in order without doing so, and should be preferred.
 type BinarySearch struct {
 	idx []tu
 	mu  sync.RWMutex
@@ -67,6 +68,8 @@ func (bs *BinarySearch) find(in *url.URL) (found bool, nearest int) {
 
 // Get returns an URL, given an input URL
 func (bs *BinarySearch) Get(_ context.Context, in *url.URL) (*url.URL, error) {
+	in = storage.Normalize(in)
+
 	bs.mu.RLock()
 	defer bs.mu.RUnlock()
 
@@ -81,15 +84,11 @@ func (bs *BinarySearch) Get(_ context.Context, in *url.URL) (*url.URL, error) {
 // Put writes the record into the set. Takes responsibility for determining the position in which to add the
 // new value, so that the underlying set retains order.
 func (bs *BinarySearch) Put(_ context.Context, f *url.URL, t *url.URL) error {
+	f = storage.Normalize(f)
+
 	bs.mu.Lock()
 	defer bs.mu.Unlock()
 
-	// Special case: If the array is empty, just start it.
-	if len(bs.idx) == 0 {
-		bs.idx = append(bs.idx, tu{from: f, to: t})
-		return nil
-	}
-
 	found, pos := bs.find(f)
 
 	// If the record is already there, update it.
@@ -98,6 +97,37 @@ func (bs *BinarySearch) Put(_ context.Context, f *url.URL, t *url.URL) error {
 		return nil
 	}
 
+	bs.insert(pos, f, t)
+
+	return nil
+}
+
+// Create writes the record into the set, but only if it is not already there.
+func (bs *BinarySearch) Create(_ context.Context, l *storage.Link) error {
+	l = storage.NormalizeLink(l)
+
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	found, pos := bs.find(l.From)
+	if found {
+		return storage.ErrAlreadyExists
+	}
+
+	bs.insert(pos, l.From, l.To)
+
+	return nil
+}
+
+// insert adds a new record to the set, next to the nearest position returned by find. Expects the caller to hold
+// the write lock.
+func (bs *BinarySearch) insert(pos int, f *url.URL, t *url.URL) {
+	// Special case: If the array is empty, just start it.
+	if len(bs.idx) == 0 {
+		bs.idx = append(bs.idx, tu{from: f, to: t})
+		return
+	}
+
 	// If the input is larger than the existing item at that address, we want to put the new input to the
 	// right of that address.
 	//
@@ -116,6 +146,32 @@ func (bs *BinarySearch) Put(_ context.Context, f *url.URL, t *url.URL) error {
 	ni = append(ni, bs.idx[pos:]...)
 
 	bs.idx = ni
+}
+
+// Delete removes the record from the set. As with Put, the remaining records are shifted to retain order.
+func (bs *BinarySearch) Delete(_ context.Context, in *url.URL) error {
+	in = storage.Normalize(in)
+
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	found, pos := bs.find(in)
+	if !found {
+		return storage.ErrNotFound
+	}
+
+	bs.idx = slices.Delete(bs.idx, pos, pos+1)
 
 	return nil
 }
+
+// List pages through the records in the set. As the set is already sorted, the start of each page is found with the
+// same O(log(n)) search as a lookup.
+func (bs *BinarySearch) List(_ context.Context, opts storage.ListOptions) (*storage.Page, error) {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+
+	return list(len(bs.idx), func(i int) *storage.Link {
+		return &storage.Link{From: bs.idx[i].from, To: bs.idx[i].to}
+	}, opts), nil
+}
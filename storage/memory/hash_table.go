@@ -3,7 +3,10 @@ package memory
 import (
 	"context"
 	"net/url"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/andrewhowdencom/x40.link/storage"
 )
@@ -11,8 +14,15 @@ import (
 // HashTable stores the entire dataset within Go's implementation of a hash table (a map). It
 // has O(1) complexity, as it is always looking up something well known within a finite space.
 type HashTable struct {
-	table map[string]*url.URL
-	mu    sync.RWMutex
+	table map[string]*storage.Link
+
+	// folded indexes the keys of the table by their folded URL. See storage.Folder.
+	folded folds
+
+	// templates indexes the keys of the templates in the table by their host. See storage.Templater.
+	templates templates
+
+	mu sync.RWMutex
 }
 
 // NewHashTable initializes a new hash table, with the appropriate default values. It also exposes the hash
@@ -20,14 +30,28 @@ type HashTable struct {
 // and so on.
 func NewHashTable() *HashTable {
 	return &HashTable{
-		table: make(map[string]*url.URL),
-		mu:    sync.RWMutex{},
+		table:     make(map[string]*storage.Link),
+		folded:    folds{},
+		templates: templates{},
+		mu:        sync.RWMutex{},
 	}
 }
 
 // Get fetches a URL. It looks it up in the hashmap by converting it to a string representation (which should be
 // unique), after which it will lookup the corresponding URL.
-func (ht *HashTable) Get(_ context.Context, in *url.URL) (*url.URL, error) {
+func (ht *HashTable) Get(ctx context.Context, in *url.URL) (*url.URL, error) {
+	l, err := ht.GetLink(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.To, nil
+}
+
+// GetLink fetches a link, complete with its metadata, in the same way as Get.
+func (ht *HashTable) GetLink(_ context.Context, in *url.URL) (*storage.Link, error) {
+	in = storage.Normalize(in)
+
 	ht.mu.RLock()
 	defer ht.mu.RUnlock()
 
@@ -38,13 +62,161 @@ func (ht *HashTable) Get(_ context.Context, in *url.URL) (*url.URL, error) {
 	return nil, storage.ErrNotFound
 }
 
+// GetFolded fetches a link whose URL differs from the supplied URL only by case, through the index of folded URLs. See
+// storage.Folder.
+func (ht *HashTable) GetFolded(_ context.Context, in *url.URL) (*storage.Link, error) {
+	in = storage.Normalize(in)
+
+	ht.mu.RLock()
+	defer ht.mu.RUnlock()
+
+	if k, ok := ht.folded.get(in); ok {
+		return ht.table[k], nil
+	}
+
+	return nil, storage.ErrNotFound
+}
+
+// Templates returns the templates on the host, through the index of templates. See storage.Templater.
+func (ht *HashTable) Templates(_ context.Context, host string) ([]*storage.Link, error) {
+	ht.mu.RLock()
+	defer ht.mu.RUnlock()
+
+	found := []*storage.Link{}
+	for _, k := range ht.templates.get(host) {
+		found = append(found, ht.table[k])
+	}
+
+	return found, nil
+}
+
 // Put writes a URL into memory. Designed to be used primarily via "loader" infrastructure, such as the
 // YAML loader.
-func (ht *HashTable) Put(_ context.Context, f *url.URL, t *url.URL) error {
+func (ht *HashTable) Put(ctx context.Context, f *url.URL, t *url.URL) error {
+	return ht.PutLink(ctx, &storage.Link{From: f, To: t})
+}
+
+// PutLink writes a link, complete with its metadata, into memory. Links that already exist are only able to be
+// replaced by their owner.
+func (ht *HashTable) PutLink(ctx context.Context, l *storage.Link) error {
+	l = storage.NormalizeLink(l)
+
 	ht.mu.Lock()
 	defer ht.mu.Unlock()
 
-	ht.table[f.String()] = t
+	existing := ht.table[l.From.String()]
+	if err := storage.Authorize(ctx, existing); err != nil {
+		return err
+	}
+
+	ht.table[l.From.String()] = storage.Stamp(ctx, l, existing)
+	ht.folded.add(l.From)
+	ht.templates.add(l)
 
 	return nil
 }
+
+// Create writes a URL into memory, but only if there is not already a URL at that address.
+func (ht *HashTable) Create(ctx context.Context, l *storage.Link) error {
+	return ht.create(ctx, l, false)
+}
+
+// CreateFolded writes a URL into memory, but only if there is neither a URL at that address, nor one that differs from
+// it only by case. See storage.FoldedCreator.
+func (ht *HashTable) CreateFolded(ctx context.Context, l *storage.Link) error {
+	return ht.create(ctx, l, true)
+}
+
+// create writes a URL into memory, but only if there is not already a URL at that address, nor (where folded) one that
+// differs from it only by case.
+func (ht *HashTable) create(ctx context.Context, l *storage.Link, folded bool) error {
+	l = storage.NormalizeLink(l)
+
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+
+	if _, ok := ht.table[l.From.String()]; ok {
+		return storage.ErrAlreadyExists
+	}
+
+	if _, ok := ht.folded.get(l.From); ok && folded {
+		return storage.ErrCaseCollision
+	}
+
+	ht.table[l.From.String()] = storage.Stamp(ctx, l, nil)
+	ht.folded.add(l.From)
+	ht.templates.add(l)
+
+	return nil
+}
+
+// Delete removes a URL from memory. Only the owner of the link is able to remove it.
+func (ht *HashTable) Delete(ctx context.Context, in *url.URL) error {
+	in = storage.Normalize(in)
+
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+
+	existing, ok := ht.table[in.String()]
+	if !ok {
+		return storage.ErrNotFound
+	}
+
+	if err := storage.Authorize(ctx, existing); err != nil {
+		return err
+	}
+
+	delete(ht.table, in.String())
+	ht.folded.remove(in)
+	ht.templates.remove(in)
+
+	return nil
+}
+
+// Owns validates whether the agent in the context owns the link.
+func (ht *HashTable) Owns(ctx context.Context, u *url.URL) bool {
+	agent, ok := ctx.Value(storage.CtxKeyAgent).(string)
+	if !ok || agent == "" {
+		return false
+	}
+
+	l, err := ht.GetLink(ctx, u)
+
+	return err == nil && l.Owner == agent
+}
+
+// Purge removes the links in memory that have expired.
+func (ht *HashTable) Purge(_ context.Context, before time.Time) (int, error) {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+
+	n := 0
+	for k, v := range ht.table {
+		if v.Expired(before) {
+			delete(ht.table, k)
+			ht.folded.remove(v.From)
+			ht.templates.remove(v.From)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// List pages through the URLs in memory. A hash table has no order of its own, so the links are sorted on each call to
+// provide a stable order in which to page. This makes listing O(n log(n)), rather than the O(1) of the lookup.
+func (ht *HashTable) List(_ context.Context, opts storage.ListOptions) (*storage.Page, error) {
+	ht.mu.RLock()
+	defer ht.mu.RUnlock()
+
+	idx := make([]*storage.Link, 0, len(ht.table))
+	for _, v := range ht.table {
+		idx = append(idx, v)
+	}
+
+	slices.SortFunc(idx, func(a, b *storage.Link) int {
+		return strings.Compare(a.From.String(), b.From.String())
+	})
+
+	return list(len(idx), func(i int) *storage.Link { return idx[i] }, opts), nil
+}
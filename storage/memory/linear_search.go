@@ -3,6 +3,7 @@ package memory
 import (
 	"context"
 	"net/url"
+	"slices"
 	"sync"
 
 	"github.com/andrewhowdencom/x40.link/storage"
@@ -26,6 +27,8 @@ func NewLinearSearch() *LinearSearch {
 
 // Get queries the linear search. It just iterates through the whole slice.
 func (s *LinearSearch) Get(_ context.Context, in *url.URL) (*url.URL, error) {
+	in = storage.Normalize(in)
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -38,14 +41,60 @@ func (s *LinearSearch) Get(_ context.Context, in *url.URL) (*url.URL, error) {
 	return nil, storage.ErrNotFound
 }
 
-// Put writes the URL into storage, appending it to the slice.
+// Put writes the URL into storage, replacing it where it is already in the slice and appending it otherwise.
 func (s *LinearSearch) Put(_ context.Context, f *url.URL, t *url.URL) error {
+	f = storage.Normalize(f)
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	for i := range s.idx {
+		if f.String() == s.idx[i].from.String() {
+			s.idx[i].to = t
+			return nil
+		}
+	}
+
 	s.idx = append(s.idx, tu{
 		from: f, to: t,
 	})
 
 	return nil
 }
+
+// Create checks the whole slice for the URL, and only appends it if it was not found.
+func (s *LinearSearch) Create(_ context.Context, l *storage.Link) error {
+	l = storage.NormalizeLink(l)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, tu := range s.idx {
+		if l.From.String() == tu.from.String() {
+			return storage.ErrAlreadyExists
+		}
+	}
+
+	s.idx = append(s.idx, tu{
+		from: l.From, to: l.To,
+	})
+
+	return nil
+}
+
+// Delete iterates through the slice until it finds the URL, and then removes it.
+func (s *LinearSearch) Delete(_ context.Context, in *url.URL) error {
+	in = storage.Normalize(in)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, tu := range s.idx {
+		if in.String() == tu.from.String() {
+			s.idx = slices.Delete(s.idx, i, i+1)
+			return nil
+		}
+	}
+
+	return storage.ErrNotFound
+}
@@ -1,9 +1,154 @@
 package memory
 
-import "net/url"
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+
+	"github.com/andrewhowdencom/x40.link/storage"
+)
+
+// ErrUnknownLayout is returned where the layout requested of New is not one that it is able to create.
+var ErrUnknownLayout = errors.New("unknown memory layout")
+
+// Layout is the way in which the data set is laid out in memory, and thus the algorithm with which it is searched.
+type Layout string
+
+// The layouts that are able to be selected through New. The remaining implementations are kept as a baseline
+// against which to benchmark, rather than for use.
+const (
+	LayoutHashTable Layout = "hash-table"
+	LayoutRadixTrie Layout = "radix-trie"
+)
+
+// New creates an empty storage with the given layout.
+func New(layout Layout) (storage.Storer, error) {
+	switch layout {
+	case LayoutHashTable:
+		return NewHashTable(), nil
+	case LayoutRadixTrie:
+		return NewRadixTrie(), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownLayout, layout)
+	}
+}
 
 // tu or "tuple". A type to use in array backed storages (e.g. binary search, linear search)
 type tu struct {
 	from *url.URL
 	to   *url.URL
 }
+
+// list returns a single page of links from a set sorted by the "from" URL, starting after the cursor. Shared by the
+// implementations that are able to keep (or construct) their data set in order. Accepts the size of the set and a
+// function that returns the link at each position, so that it does not matter how the set is laid out in memory.
+func list(n int, at func(i int) *storage.Link, opts storage.ListOptions) *storage.Page {
+	page := &storage.Page{Links: []*storage.Link{}}
+
+	// The cursor is the last URL that was returned. Because the set is sorted, the starting point can be found by
+	// searching for the first URL that sorts after it.
+	start := 0
+	if opts.Cursor != "" {
+		start = sort.Search(n, func(i int) bool {
+			return at(i).From.String() > opts.Cursor
+		})
+	}
+
+	for i := start; i < n; i++ {
+		l := at(i)
+		if !opts.Matches(l) {
+			continue
+		}
+
+		// There is at least one more record past the end of this page, so the caller needs to be able to fetch it.
+		if len(page.Links) == opts.Size() {
+			page.Next = page.Links[len(page.Links)-1].From.String()
+			break
+		}
+
+		page.Links = append(page.Links, l)
+	}
+
+	return page
+}
+
+// folds is an index of the keys of the links in a data set by their folded URL (see storage.Fold), shared by the
+// implementations that are able to find links without regard to case. Links whose URLs differ only by case share an
+// entry.
+type folds map[string]map[string]bool
+
+// add indexes the key of the link at the URL.
+func (f folds) add(u *url.URL) {
+	k := storage.Fold(u)
+	if f[k] == nil {
+		f[k] = map[string]bool{}
+	}
+
+	f[k][u.String()] = true
+}
+
+// remove removes the key of the link at the URL from the index.
+func (f folds) remove(u *url.URL) {
+	k := storage.Fold(u)
+
+	delete(f[k], u.String())
+	if len(f[k]) == 0 {
+		delete(f, k)
+	}
+}
+
+// get returns the key of a link whose URL differs from the URL only by case, and whether there is one. Where there is
+// more than one, the first in order is returned.
+func (f folds) get(u *url.URL) (string, bool) {
+	keys := f[storage.Fold(u)]
+	if len(keys) == 0 {
+		return "", false
+	}
+
+	first := ""
+	for k := range keys {
+		if first == "" || k < first {
+			first = k
+		}
+	}
+
+	return first, true
+}
+
+// templates is an index of the keys of the link templates in a data set by their host (see storage.Templater), shared
+// by the implementations that support templates.
+type templates map[string]map[string]bool
+
+// add indexes the key of the link, if it is a template.
+func (t templates) add(l *storage.Link) {
+	if !l.IsTemplate() {
+		return
+	}
+
+	if t[l.From.Host] == nil {
+		t[l.From.Host] = map[string]bool{}
+	}
+
+	t[l.From.Host][l.From.String()] = true
+}
+
+// remove removes the key of the link at the URL from the index, if it is there.
+func (t templates) remove(u *url.URL) {
+	delete(t[u.Host], u.String())
+	if len(t[u.Host]) == 0 {
+		delete(t, u.Host)
+	}
+}
+
+// get returns the keys of the templates on the host, in order.
+func (t templates) get(host string) []string {
+	keys := make([]string, 0, len(t[host]))
+	for k := range t[host] {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,446 @@
+package memory
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andrewhowdencom/x40.link/storage"
+)
+
+// RadixTrie stores the data set in a radix trie: a tree in which each edge is labelled with part of the key, and in
+// which nodes with a single child are merged with that child. Keys are the string representation of the URL.
+//
+// Lookups and inserts are O(k) in the length of the key, regardless of how many links are stored. Unlike the hash
+// table, the links are kept in order, so they are able to be listed (or iterated beneath a prefix) without first
+// being sorted. Unlike the binary search, inserting a link does not require reallocating the whole set.
+type RadixTrie struct {
+	root *node
+
+	// folded indexes the keys of the trie by their folded URL. See storage.Folder.
+	folded folds
+
+	// templates indexes the keys of the templates in the trie by their host. See storage.Templater.
+	templates templates
+
+	mu sync.RWMutex
+}
+
+// node is a single node in the trie. The key of a node is the labels of every edge from the root to the node,
+// concatenated.
+type node struct {
+	// label is the label of the edge from the parent to this node.
+	label string
+
+	// link is the link stored at the key of this node, if any. Nodes without a link are only there to branch.
+	link *storage.Link
+
+	// children are sorted by the first byte of their label, which is unique amongst them.
+	children []*node
+}
+
+// NewRadixTrie initializes an empty radix trie.
+func NewRadixTrie() *RadixTrie {
+	return &RadixTrie{root: &node{}, folded: folds{}, templates: templates{}}
+}
+
+// Get fetches a URL by following the edges of the trie that make up its key.
+func (rt *RadixTrie) Get(ctx context.Context, in *url.URL) (*url.URL, error) {
+	l, err := rt.GetLink(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.To, nil
+}
+
+// GetLink fetches a link, complete with its metadata, in the same way as Get.
+func (rt *RadixTrie) GetLink(_ context.Context, in *url.URL) (*storage.Link, error) {
+	in = storage.Normalize(in)
+
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	if l := rt.root.get(in.String()); l != nil {
+		return l, nil
+	}
+
+	return nil, storage.ErrNotFound
+}
+
+// Put writes a URL into the trie.
+func (rt *RadixTrie) Put(ctx context.Context, f *url.URL, t *url.URL) error {
+	return rt.PutLink(ctx, &storage.Link{From: f, To: t})
+}
+
+// PutLink writes a link, complete with its metadata, into the trie. Links that already exist are only able to be
+// replaced by their owner.
+func (rt *RadixTrie) PutLink(ctx context.Context, l *storage.Link) error {
+	l = storage.NormalizeLink(l)
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	existing := rt.root.get(l.From.String())
+	if err := storage.Authorize(ctx, existing); err != nil {
+		return err
+	}
+
+	rt.root.insert(l.From.String(), storage.Stamp(ctx, l, existing))
+	rt.folded.add(l.From)
+	rt.templates.add(l)
+
+	return nil
+}
+
+// Create writes a URL into the trie, but only if there is not already a URL at that address.
+func (rt *RadixTrie) Create(ctx context.Context, l *storage.Link) error {
+	return rt.create(ctx, l, false)
+}
+
+// CreateFolded writes a URL into the trie, but only if there is neither a URL at that address, nor one that differs
+// from it only by case. See storage.FoldedCreator.
+func (rt *RadixTrie) CreateFolded(ctx context.Context, l *storage.Link) error {
+	return rt.create(ctx, l, true)
+}
+
+// create writes a URL into the trie, but only if there is not already a URL at that address, nor (where folded) one
+// that differs from it only by case.
+func (rt *RadixTrie) create(ctx context.Context, l *storage.Link, folded bool) error {
+	l = storage.NormalizeLink(l)
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if rt.root.get(l.From.String()) != nil {
+		return storage.ErrAlreadyExists
+	}
+
+	if _, ok := rt.folded.get(l.From); ok && folded {
+		return storage.ErrCaseCollision
+	}
+
+	rt.root.insert(l.From.String(), storage.Stamp(ctx, l, nil))
+	rt.folded.add(l.From)
+	rt.templates.add(l)
+
+	return nil
+}
+
+// Delete removes a URL from the trie, merging the nodes that no longer need to branch. Only the owner of the link is
+// able to remove it.
+func (rt *RadixTrie) Delete(ctx context.Context, in *url.URL) error {
+	in = storage.Normalize(in)
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	existing := rt.root.get(in.String())
+	if existing == nil {
+		return storage.ErrNotFound
+	}
+
+	if err := storage.Authorize(ctx, existing); err != nil {
+		return err
+	}
+
+	rt.root.remove(in.String())
+	rt.folded.remove(in)
+	rt.templates.remove(in)
+
+	return nil
+}
+
+// Owns validates whether the agent in the context owns the link.
+func (rt *RadixTrie) Owns(ctx context.Context, u *url.URL) bool {
+	agent, ok := ctx.Value(storage.CtxKeyAgent).(string)
+	if !ok || agent == "" {
+		return false
+	}
+
+	l, err := rt.GetLink(ctx, u)
+
+	return err == nil && l.Owner == agent
+}
+
+// Purge removes the links in the trie that have expired.
+func (rt *RadixTrie) Purge(_ context.Context, before time.Time) (int, error) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	expired := []*storage.Link{}
+	rt.root.walk("", "", func(_ string, l *storage.Link) bool {
+		if l.Expired(before) {
+			expired = append(expired, l)
+		}
+
+		return true
+	})
+
+	for _, l := range expired {
+		rt.root.remove(l.From.String())
+		rt.folded.remove(l.From)
+		rt.templates.remove(l.From)
+	}
+
+	return len(expired), nil
+}
+
+// List pages through the URLs in the trie. The trie is already in order, so the page is read by walking the trie from
+// the cursor; where listing a single host, only the part of the trie beneath that host is walked.
+func (rt *RadixTrie) List(_ context.Context, opts storage.ListOptions) (*storage.Page, error) {
+	page := &storage.Page{Links: []*storage.Link{}}
+
+	prefix := ""
+	if opts.Host != "" {
+		prefix = (&url.URL{Host: opts.Host}).String()
+	}
+
+	rt.Walk(prefix, opts.Cursor, func(l *storage.Link) bool {
+		if !opts.Matches(l) {
+			return true
+		}
+
+		// There is at least one more record past the end of this page, so the caller needs to be able to fetch it.
+		if len(page.Links) == opts.Size() {
+			page.Next = page.Links[len(page.Links)-1].From.String()
+			return false
+		}
+
+		page.Links = append(page.Links, l)
+
+		return true
+	})
+
+	return page, nil
+}
+
+// GetPrefix finds the prefix link that the URL is beneath (see storage.Prefixer). The links that the URL could be
+// beneath are those on the path through the trie to the URL itself, so they are found in a single descent.
+func (rt *RadixTrie) GetPrefix(_ context.Context, u *url.URL) (*storage.Link, error) {
+	u = storage.Normalize(u)
+
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	var found *storage.Link
+
+	key := u.String()
+	n := rt.root
+
+	for rest := key; ; {
+		if n.link != nil && n.link.IsPrefixOf(u) {
+			found = n.link
+		}
+
+		if rest == "" {
+			break
+		}
+
+		i, ok := n.child(rest[0])
+		if !ok || !strings.HasPrefix(rest, n.children[i].label) {
+			break
+		}
+
+		n = n.children[i]
+		rest = rest[len(n.label):]
+	}
+
+	if found == nil {
+		return nil, storage.ErrNotFound
+	}
+
+	return found, nil
+}
+
+// GetFolded fetches a link whose URL differs from the supplied URL only by case, through the index of folded URLs. See
+// storage.Folder.
+func (rt *RadixTrie) GetFolded(_ context.Context, in *url.URL) (*storage.Link, error) {
+	in = storage.Normalize(in)
+
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	if k, ok := rt.folded.get(in); ok {
+		return rt.root.get(k), nil
+	}
+
+	return nil, storage.ErrNotFound
+}
+
+// Templates returns the templates on the host, through the index of templates. See storage.Templater.
+func (rt *RadixTrie) Templates(_ context.Context, host string) ([]*storage.Link, error) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	found := []*storage.Link{}
+	for _, k := range rt.templates.get(host) {
+		found = append(found, rt.root.get(k))
+	}
+
+	return found, nil
+}
+
+// Walk calls fn with each link whose URL (as a string) begins with prefix and sorts after the cursor, in order, until
+// fn returns false. An empty prefix matches every link, and an empty cursor starts from the first.
+//
+// The trie is locked for reading while it is walked, so fn must not write to it.
+func (rt *RadixTrie) Walk(prefix string, cursor string, fn func(l *storage.Link) bool) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	n, key := rt.root.find(prefix)
+	if n == nil {
+		return
+	}
+
+	n.walk(key, cursor, func(_ string, l *storage.Link) bool { return fn(l) })
+}
+
+// child returns the position of the child whose label begins with the given byte, and whether there is one. Where
+// there is not, the position is where such a child would be inserted.
+func (n *node) child(b byte) (int, bool) {
+	i := sort.Search(len(n.children), func(i int) bool { return n.children[i].label[0] >= b })
+
+	return i, i < len(n.children) && n.children[i].label[0] == b
+}
+
+// get returns the link stored at the key, or nil if there is none.
+func (n *node) get(key string) *storage.Link {
+	for key != "" {
+		i, ok := n.child(key[0])
+		if !ok || !strings.HasPrefix(key, n.children[i].label) {
+			return nil
+		}
+
+		n = n.children[i]
+		key = key[len(n.label):]
+	}
+
+	return n.link
+}
+
+// find returns the highest node whose key begins with the prefix, along with that key. Every key beneath the node
+// also begins with the prefix. Returns nil where no key does.
+func (n *node) find(prefix string) (*node, string) {
+	key := ""
+
+	for prefix != "" {
+		i, ok := n.child(prefix[0])
+		if !ok {
+			return nil, ""
+		}
+
+		c := n.children[i]
+
+		switch {
+		case strings.HasPrefix(c.label, prefix):
+			return c, key + c.label
+		case strings.HasPrefix(prefix, c.label):
+			n, key, prefix = c, key+c.label, prefix[len(c.label):]
+		default:
+			return nil, ""
+		}
+	}
+
+	return n, key
+}
+
+// insert stores the link at the key, splitting the edge where the key diverges from its label part way through.
+func (n *node) insert(key string, l *storage.Link) {
+	for key != "" {
+		i, ok := n.child(key[0])
+		if !ok {
+			n.children = append(n.children, nil)
+			copy(n.children[i+1:], n.children[i:])
+			n.children[i] = &node{label: key, link: l}
+
+			return
+		}
+
+		c := n.children[i]
+		common := commonPrefix(key, c.label)
+
+		// The key diverges from the label (or ends) part way through it, so the edge is split at that point.
+		if common < len(c.label) {
+			split := &node{label: c.label[:common], children: []*node{c}}
+			c.label = c.label[common:]
+			n.children[i] = split
+			c = split
+		}
+
+		n = c
+		key = key[common:]
+	}
+
+	n.link = l
+}
+
+// remove removes the link stored at the key, if any. Nodes left without a link or children are removed, and those
+// left without a link and with a single child are merged with that child. Returns whether the node itself is no
+// longer needed.
+func (n *node) remove(key string) bool {
+	if key == "" {
+		n.link = nil
+	} else {
+		i, ok := n.child(key[0])
+		if !ok || !strings.HasPrefix(key, n.children[i].label) {
+			return false
+		}
+
+		c := n.children[i]
+		if c.remove(key[len(c.label):]) {
+			n.children = append(n.children[:i], n.children[i+1:]...)
+		} else if c.link == nil && len(c.children) == 1 {
+			gc := c.children[0]
+			gc.label = c.label + gc.label
+			n.children[i] = gc
+		}
+	}
+
+	return n.link == nil && len(n.children) == 0
+}
+
+// walk calls fn with each link at or beneath the node that sorts after the cursor, in order, until fn returns false.
+// Returns false where fn did. The key is the key of the node.
+//
+// A node's key sorts before every key beneath it, and the children are sorted by their label, so visiting the node
+// and then each of its children visits the keys in order. Subtrees that sort entirely before the cursor are skipped.
+func (n *node) walk(key string, cursor string, fn func(k string, l *storage.Link) bool) bool {
+	// Where the key is a prefix of the cursor, the node itself is at or before the cursor, but those beneath it may
+	// not be. Otherwise, the whole subtree sorts on the same side of the cursor as the key.
+	if cursor != "" && !strings.HasPrefix(cursor, key) {
+		if key < cursor {
+			return true
+		}
+
+		cursor = ""
+	}
+
+	if n.link != nil && cursor == "" {
+		if !fn(key, n.link) {
+			return false
+		}
+	}
+
+	for _, c := range n.children {
+		if !c.walk(key+c.label, cursor, fn) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// commonPrefix returns the length of the prefix shared by both strings.
+func commonPrefix(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+
+	return i
+}
@@ -0,0 +1,136 @@
+package memory
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/andrewhowdencom/x40.link/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// trie creates a radix trie containing the given URLs, each pointing to the same destination.
+func trie(t *testing.T, urls ...string) *RadixTrie {
+	rt := NewRadixTrie()
+
+	for _, u := range urls {
+		from, err := url.Parse(u)
+		assert.Nil(t, err)
+		assert.Nil(t, rt.Put(context.Background(), from, &url.URL{Host: "andrewhowden.com"}))
+	}
+
+	return rt
+}
+
+// walked returns the URLs visited by walking the trie.
+func walked(rt *RadixTrie, prefix, cursor string) []string {
+	found := []string{}
+	rt.Walk(prefix, cursor, func(l *storage.Link) bool {
+		found = append(found, l.From.String())
+		return true
+	})
+
+	return found
+}
+
+// TestRadixTrieWalk validates that the trie is walked in order, and is able to be walked beneath a prefix, or from a
+// cursor.
+func TestRadixTrieWalk(t *testing.T) {
+	t.Parallel()
+
+	urls := []string{"//x40/foo", "//x40", "//x40/f", "//k3s/a", "//x40/foobar", "//x40/fob", "//x40.link", "//x40/bar"}
+	rt := trie(t, urls...)
+
+	sorted := slices.Clone(urls)
+	slices.Sort(sorted)
+
+	for _, tc := range []struct {
+		name     string
+		prefix   string
+		cursor   string
+		expected []string
+	}{
+		{name: "everything", expected: sorted},
+		{name: "prefix on an edge", prefix: "//x40/fo", expected: []string{"//x40/fob", "//x40/foo", "//x40/foobar"}},
+		{name: "prefix on a node", prefix: "//x40/foo", expected: []string{"//x40/foo", "//x40/foobar"}},
+		{name: "prefix not found", prefix: "//x40/z", expected: []string{}},
+		{name: "cursor on a node", cursor: "//x40/foo", expected: []string{"//x40/foobar"}},
+		{name: "cursor not found", cursor: "//x40/c", expected: []string{"//x40/f", "//x40/fob", "//x40/foo", "//x40/foobar"}},
+		{name: "cursor before a branch", cursor: "//x40", expected: []string{"//x40.link", "//x40/bar", "//x40/f", "//x40/fob", "//x40/foo", "//x40/foobar"}},
+		{name: "prefix and cursor", prefix: "//x40/", cursor: "//x40/fob", expected: []string{"//x40/foo", "//x40/foobar"}},
+		{name: "cursor past the end", cursor: "//z", expected: []string{}},
+	} {
+		assert.Equal(t, tc.expected, walked(rt, tc.prefix, tc.cursor), tc.name)
+	}
+
+	// Walking stops when asked.
+	n := 0
+	rt.Walk("", "", func(*storage.Link) bool {
+		n++
+		return n < 3
+	})
+	assert.Equal(t, 3, n)
+}
+
+// TestRadixTrieDelete validates that removing links merges the nodes that are no longer needed, so that the trie does
+// not grow with links that are no longer there.
+func TestRadixTrieDelete(t *testing.T) {
+	t.Parallel()
+
+	rt := trie(t, "//x40/foo", "//x40/foobar", "//x40/fob")
+
+	// Removing a link that has children leaves the node to branch, but the link is gone.
+	assert.Nil(t, rt.Delete(context.Background(), &url.URL{Host: "x40", Path: "/foo"}))
+	assert.Equal(t, []string{"//x40/fob", "//x40/foobar"}, walked(rt, "", ""))
+
+	// Removing one side of a branch merges the other side into its parent.
+	assert.Nil(t, rt.Delete(context.Background(), &url.URL{Host: "x40", Path: "/fob"}))
+	assert.Len(t, rt.root.children, 1)
+	assert.Equal(t, "//x40/foobar", rt.root.children[0].label)
+	assert.Empty(t, rt.root.children[0].children)
+
+	// Removing the last link empties the trie.
+	assert.Nil(t, rt.Delete(context.Background(), &url.URL{Host: "x40", Path: "/foobar"}))
+	assert.Empty(t, rt.root.children)
+
+	assert.ErrorIs(t, rt.Delete(context.Background(), &url.URL{Host: "x40", Path: "/foobar"}), storage.ErrNotFound)
+}
+
+// TestRadixTrieSplit validates that a key that ends, or diverges, part way along an edge is stored without disturbing
+// the keys that share the edge.
+func TestRadixTrieSplit(t *testing.T) {
+	t.Parallel()
+
+	rt := trie(t, "//x40/foobar", "//x40/foo", "//x40/fox")
+
+	for _, u := range []string{"//x40/foobar", "//x40/foo", "//x40/fox"} {
+		from, _ := url.Parse(u)
+
+		_, err := rt.Get(context.Background(), from)
+		assert.Nil(t, err, u)
+	}
+
+	for _, u := range []string{"//x40/fo", "//x40/foob", "//x40/foobarbaz", "//x40/g"} {
+		from, _ := url.Parse(u)
+
+		_, err := rt.Get(context.Background(), from)
+		assert.ErrorIs(t, err, storage.ErrNotFound, u)
+	}
+}
+
+// TestNew validates that the layouts are able to be selected, and that unknown layouts are refused.
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	str, err := New(LayoutHashTable)
+	assert.Nil(t, err)
+	assert.IsType(t, &HashTable{}, str)
+
+	str, err = New(LayoutRadixTrie)
+	assert.Nil(t, err)
+	assert.IsType(t, &RadixTrie{}, str)
+
+	_, err = New("b-tree")
+	assert.ErrorIs(t, err, ErrUnknownLayout)
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// SlashPolicy is what is done with the trailing slash of the path of a URL as it is normalized.
+type SlashPolicy string
+
+// The supported policies.
+const (
+	// SlashKeep leaves the path as it is, such that /foo and /foo/ are different links.
+	SlashKeep SlashPolicy = "keep"
+
+	// SlashStrip removes the trailing slash, such that /foo and /foo/ are the same link.
+	SlashStrip SlashPolicy = "strip"
+)
+
+// SlashPolicies are the policies with which a trailing slash is able to be normalized.
+var SlashPolicies = []SlashPolicy{SlashKeep, SlashStrip}
+
+// ValidSlash indicates whether the policy is one that is supported.
+func ValidSlash(p SlashPolicy) bool {
+	return slices.Contains(SlashPolicies, p)
+}
+
+// defaultPorts are the ports implied by the schemes with which links are requested. Links are stored without a scheme,
+// so any of them is implied, whatever the scheme of the URL.
+var defaultPorts = []string{"80", "443"}
+
+// NormalizeOption modifies how a URL is normalized.
+type NormalizeOption func(o *normalizeOptions)
+
+type normalizeOptions struct {
+	slash SlashPolicy
+}
+
+// WithTrailingSlash applies the policy to the trailing slash of the path. By default, it is kept.
+func WithTrailingSlash(p SlashPolicy) NormalizeOption {
+	return func(o *normalizeOptions) {
+		o.slash = p
+	}
+}
+
+// Normalize returns the canonical form of the URL of a link, such that the different ways of writing the same link
+// are stored (and found) under the same key. The canonical form is the host and path alone; the host is lower case,
+// encoded as punycode where it is internationalized (e.g. bücher.example becomes xn--bcher-kva.example) and without
+// a port where it is the default. The scheme, query and fragment are not part of the link, and are dropped.
+//
+// Storage implementations normalize the URLs they are supplied; callers need only normalize where they want to apply
+// a trailing slash policy, or to report the URL as it was stored.
+func Normalize(u *url.URL, opts ...NormalizeOption) *url.URL {
+	o := &normalizeOptions{slash: SlashKeep}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	n := &url.URL{Host: normalizeHost(u), Path: u.Path}
+
+	if o.slash == SlashStrip {
+		n.Path = strings.TrimSuffix(n.Path, "/")
+	}
+
+	return n
+}
+
+// NormalizeLink returns a copy of the link with its URL normalized. See Normalize.
+func NormalizeLink(l *Link, opts ...NormalizeOption) *Link {
+	n := *l
+	n.From = Normalize(l.From, opts...)
+
+	return &n
+}
+
+// normalizeHost returns the host (and port, where it is not the default) of the URL in canonical form.
+func normalizeHost(u *url.URL) string {
+	host, port := strings.TrimSuffix(strings.ToLower(u.Hostname()), "."), u.Port()
+
+	// Hosts that are not valid domain names (such as IP addresses, or names with underscores) are left as they are.
+	if ascii, err := idna.Lookup.ToASCII(host); err == nil {
+		host = ascii
+	}
+
+	if slices.Contains(defaultPorts, port) {
+		port = ""
+	}
+
+	if port != "" {
+		return net.JoinHostPort(host, port)
+	}
+
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+
+	return host
+}
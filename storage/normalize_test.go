@@ -0,0 +1,67 @@
+package storage_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/andrewhowdencom/x40.link/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		in       string
+		slash    storage.SlashPolicy
+		expected string
+	}{
+		{in: "//x40.link/Abc", expected: "//x40.link/Abc"},
+		{in: "//X40.LINK/Abc", expected: "//x40.link/Abc"},
+		{in: "//x40.link:443/Abc", expected: "//x40.link/Abc"},
+		{in: "//x40.link:80/Abc", expected: "//x40.link/Abc"},
+		{in: "//x40.link:8080/Abc", expected: "//x40.link:8080/Abc"},
+		{in: "https://x40.link:443/Abc", expected: "//x40.link/Abc"},
+		{in: "https://x40.link:80/Abc", expected: "//x40.link/Abc"},
+		{in: "//x40.link./Abc", expected: "//x40.link/Abc"},
+		{in: "https://x40.link/Abc?utm_source=mail#top", expected: "//x40.link/Abc"},
+		{in: "//BÜCHER.example/Abc", expected: "//xn--bcher-kva.example/Abc"},
+		{in: "//xn--bcher-kva.example/Abc", expected: "//xn--bcher-kva.example/Abc"},
+		{in: "//[::1]:443/Abc", expected: "//[::1]/Abc"},
+		{in: "//[::1]:8080/Abc", expected: "//[::1]:8080/Abc"},
+		{in: "//127.0.0.1:80/Abc", expected: "//127.0.0.1/Abc"},
+		{in: "//a_b.test/Abc", expected: "//a_b.test/Abc"},
+		{in: "//x40.link/Abc/", expected: "//x40.link/Abc/"},
+		{in: "//x40.link/Abc/", slash: storage.SlashKeep, expected: "//x40.link/Abc/"},
+		{in: "//x40.link/Abc/", slash: storage.SlashStrip, expected: "//x40.link/Abc"},
+		{in: "//x40.link/", slash: storage.SlashStrip, expected: "//x40.link"},
+		{in: "//x40.link/a%2Fb", expected: "//x40.link/a/b"},
+	} {
+		u, err := url.Parse(tc.in)
+		assert.Nil(t, err, tc.in)
+
+		opts := []storage.NormalizeOption{}
+		if tc.slash != "" {
+			opts = append(opts, storage.WithTrailingSlash(tc.slash))
+		}
+
+		res := storage.Normalize(u, opts...)
+		assert.Equal(t, tc.expected, res.String(), tc.in)
+
+		// Normalizing is idempotent.
+		assert.Equal(t, res, storage.Normalize(res, opts...), tc.in)
+	}
+}
+
+func TestNormalizeLink(t *testing.T) {
+	t.Parallel()
+
+	l := &storage.Link{From: &url.URL{Host: "X40", Path: "/foo"}, To: &url.URL{Host: "K3S"}}
+	n := storage.NormalizeLink(l)
+
+	assert.Equal(t, &url.URL{Host: "x40", Path: "/foo"}, n.From)
+	assert.Equal(t, &url.URL{Host: "K3S"}, n.To)
+
+	// The link is copied, rather than modified.
+	assert.Equal(t, "X40", l.From.Host)
+}
@@ -0,0 +1,234 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/andrewhowdencom/x40.link/storage/frozen"
+	"github.com/jackc/pgx/v5"
+)
+
+// migrationLock is the key of the advisory lock held while migrating, so that replicas starting at the same time do
+// not attempt to apply the same migration twice. Arbitrary, but must never change.
+const migrationLock = 0x7834306c696e6b // "x40link"
+
+// migration brings the schema (or the data) up to date by one version, within the transaction in which it is recorded.
+type migration func(ctx context.Context, tx pgx.Tx) error
+
+// exec is a migration that executes the statements.
+func exec(stmts string) migration {
+	return func(ctx context.Context, tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, stmts)
+		return err
+	}
+}
+
+// migrations bring the schema up to date, in the order in which they must be applied. Each is applied in its own
+// transaction, and recorded in the schema_migrations table by its (one indexed) position.
+//
+// Migrations that have been released must never be modified; instead, append another.
+var migrations = []migration{
+	// 1: The initial schema. The short link is stored both in full (as the key, and the order in which links are
+	// listed) and split into its host, so links can be queried by host without a table scan. The "C" collation
+	// orders links byte by byte, consistent with the other storage implementations.
+	exec(`
+	CREATE TABLE links (
+		from_url    TEXT COLLATE "C" NOT NULL PRIMARY KEY,
+		host        TEXT             NOT NULL,
+		to_url      TEXT             NOT NULL,
+		owner       TEXT             NOT NULL DEFAULT '',
+		created     TIMESTAMPTZ      NOT NULL,
+		updated     TIMESTAMPTZ      NOT NULL,
+		description TEXT             NOT NULL DEFAULT '',
+		tags        TEXT[]           NOT NULL DEFAULT '{}',
+		status      INTEGER          NOT NULL DEFAULT 0,
+		expires     TIMESTAMPTZ
+	);
+
+	CREATE INDEX links_host    ON links (host, from_url);
+	CREATE INDEX links_owner   ON links (owner, from_url);
+	CREATE INDEX links_expires ON links (expires) WHERE expires IS NOT NULL;
+	`),
+
+	// 2: Prefix links match every path beneath their own, as well as their own.
+	exec(`ALTER TABLE links ADD COLUMN prefix BOOLEAN NOT NULL DEFAULT FALSE;`),
+
+	// 3: How the query string is forwarded to the destination. Empty means the server default.
+	exec(`ALTER TABLE links ADD COLUMN query_policy TEXT NOT NULL DEFAULT '';`),
+
+	// 4: Links are able to be found without regard to case, by their folded URL (see storage.Fold).
+	exec(`CREATE INDEX links_folded ON links (lower(from_url), from_url);`),
+
+	// 5: Keys were written as they were supplied, so the same link written in different ways (such as with the host in
+	// upper case, or with a default port) was stored more than once. Rewrite each key in its normalized form (see
+	// frozen.Normalize); where several keys normalize to the same one, the link most recently updated is kept.
+	func(ctx context.Context, tx pgx.Tx) error {
+		type move struct {
+			from, to, host string
+			updated        time.Time
+		}
+
+		rows, err := tx.Query(ctx, "SELECT from_url, updated FROM links ORDER BY from_url")
+		if err != nil {
+			return err
+		}
+
+		// The rows are collected before any are moved, as the connection is not able to be used while it is read.
+		moved := []move{}
+		for rows.Next() {
+			var m move
+			if err := rows.Scan(&m.from, &m.updated); err != nil {
+				rows.Close()
+				return err
+			}
+
+			from, err := url.Parse(m.from)
+			if err != nil {
+				rows.Close()
+				return fmt.Errorf("link %s: %s", m.from, err)
+			}
+
+			n := frozen.Normalize(from)
+			if m.to, m.host = n.String(), n.Host; m.to != m.from {
+				moved = append(moved, m)
+			}
+		}
+
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, m := range moved {
+			var updated time.Time
+			err := tx.QueryRow(ctx, "SELECT updated FROM links WHERE from_url = $1", m.to).Scan(&updated)
+
+			switch {
+			case errors.Is(err, pgx.ErrNoRows):
+			case err != nil:
+				return err
+			case updated.After(m.updated):
+				if _, err := tx.Exec(ctx, "DELETE FROM links WHERE from_url = $1", m.from); err != nil {
+					return err
+				}
+
+				continue
+			default:
+				if _, err := tx.Exec(ctx, "DELETE FROM links WHERE from_url = $1", m.to); err != nil {
+					return err
+				}
+			}
+
+			if _, err := tx.Exec(ctx, "UPDATE links SET from_url = $1, host = $2 WHERE from_url = $3", m.to, m.host, m.from); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+	// 6: Link templates are flagged, and indexed by their host, so that the templates a URL could match are found
+	// without scanning the host (see storage.Templater, and frozen.IsTemplate).
+	func(ctx context.Context, tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `
+			ALTER TABLE links ADD COLUMN template BOOLEAN NOT NULL DEFAULT FALSE;
+
+			CREATE INDEX links_template ON links (host, from_url) WHERE template;
+		`); err != nil {
+			return err
+		}
+
+		rows, err := tx.Query(ctx, "SELECT from_url FROM links")
+		if err != nil {
+			return err
+		}
+
+		// The rows are collected before any are updated, as the table is not able to be modified while it is read.
+		found := []string{}
+		for rows.Next() {
+			var from string
+			if err := rows.Scan(&from); err != nil {
+				rows.Close()
+				return err
+			}
+
+			u, err := url.Parse(from)
+			if err != nil {
+				rows.Close()
+				return fmt.Errorf("link %s: %s", from, err)
+			}
+
+			if frozen.IsTemplate(u.Path) {
+				found = append(found, from)
+			}
+		}
+
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, from := range found {
+			if _, err := tx.Exec(ctx, "UPDATE links SET template = TRUE WHERE from_url = $1", from); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+
+	// 7: Links created on hosts where paths are matched without regard to case are unique by their folded URL (see
+	// storage.FoldedCreator). The folded URL is only recorded for those links; the others may differ only by case.
+	exec(`
+	ALTER TABLE links ADD COLUMN folded TEXT;
+
+	CREATE UNIQUE INDEX links_folded_unique ON links (folded);
+	`),
+}
+
+// migrate applies the migrations that have not yet been applied to the database. The advisory lock is held by the
+// session, so the migrations are applied on a single connection, rather than through the pool.
+func migrate(ctx context.Context, conn conn) error {
+	// The lock is held by the session, rather than a transaction, as it spans several transactions.
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLock); err != nil {
+		return err
+	}
+
+	defer func() { _, _ = conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLock) }()
+
+	if _, err := conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER     NOT NULL PRIMARY KEY,
+			applied TIMESTAMPTZ NOT NULL DEFAULT now()
+		)
+	`); err != nil {
+		return err
+	}
+
+	var version int
+	if err := conn.QueryRow(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		return err
+	}
+
+	if version > len(migrations) {
+		return fmt.Errorf("%w: schema version %d is newer than this version supports (%d)", ErrSchemaTooNew, version, len(migrations))
+	}
+
+	for i := version; i < len(migrations); i++ {
+		if err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			if err := migrations[i](ctx, tx); err != nil {
+				return err
+			}
+
+			_, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", i+1)
+
+			return err
+		}); err != nil {
+			return fmt.Errorf("migration %d: %s", i+1, err)
+		}
+	}
+
+	return nil
+}
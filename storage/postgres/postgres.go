@@ -0,0 +1,445 @@
+// Package postgres implements storage based on a PostgreSQL database, allowing several replicas of the server to
+// share the same links.
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrewhowdencom/x40.link/storage"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Err* are sentinel errors
+var (
+	ErrSchemaTooNew = errors.New("database schema is newer than supported")
+)
+
+// columns are the columns from which a link is read, in the order expected by scan.
+const columns = "from_url, to_url, owner, created, updated, description, tags, status, expires, prefix, query_policy"
+
+// Postgres is an implementation of the link shortener that stores links in PostgreSQL:
+//
+// * https://www.postgresql.org/
+//
+// Queries are made through a pool of connections, which is safe to share between goroutines.
+type Postgres struct {
+	pool db
+}
+
+// conn is the part of a connection to the database (such as pgx.Conn, or pgxpool.Conn) on which the storage makes its
+// queries.
+type conn interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// db is the part of a pool of connections (see pgxpool.Pool) through which the storage makes its queries, such that
+// they are able to be tested without a database.
+type db interface {
+	conn
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	Close()
+}
+
+// New connects to the database described by the DSN (either a URL or a set of key=value pairs; see the pgx
+// documentation), applying any schema migrations that have not yet been applied.
+func New(ctx context.Context, dsn string) (*Postgres, error) {
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", storage.ErrStorageSetupFailed, err)
+	}
+
+	c, err := pool.Acquire(ctx)
+	if err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("%w: %s", storage.ErrStorageSetupFailed, err)
+	}
+
+	err = migrate(ctx, c)
+	c.Release()
+
+	if err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("%w: %s", storage.ErrStorageSetupFailed, err)
+	}
+
+	return &Postgres{pool: pool}, nil
+}
+
+// Close releases the connections in the pool.
+func (p *Postgres) Close() {
+	p.pool.Close()
+}
+
+// Get returns a URL, given another input URL
+func (p *Postgres) Get(ctx context.Context, in *url.URL) (*url.URL, error) {
+	l, err := p.GetLink(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.To, nil
+}
+
+// GetLink returns a link, complete with its metadata, given the input URL
+func (p *Postgres) GetLink(ctx context.Context, in *url.URL) (*storage.Link, error) {
+	in = storage.Normalize(in)
+
+	l, err := scan(ctx, p.pool.QueryRow(ctx, "SELECT "+columns+" FROM links WHERE from_url = $1", in.String()))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, storage.ErrNotFound
+	}
+
+	return l, err
+}
+
+// GetFolded returns a link whose URL differs from the input URL only by case, according to the index of folded URLs.
+// See storage.Folder.
+func (p *Postgres) GetFolded(ctx context.Context, in *url.URL) (*storage.Link, error) {
+	l, err := scan(ctx, p.pool.QueryRow(ctx,
+		"SELECT "+columns+" FROM links WHERE lower(from_url) = $1 ORDER BY from_url LIMIT 1",
+		storage.Fold(in),
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, storage.ErrNotFound
+	}
+
+	return l, err
+}
+
+// Templates returns the templates on the host, which are flagged (and indexed) as such. See storage.Templater.
+func (p *Postgres) Templates(ctx context.Context, host string) ([]*storage.Link, error) {
+	rows, err := p.pool.Query(ctx, "SELECT "+columns+" FROM links WHERE host = $1 AND template ORDER BY from_url", host)
+	if err != nil {
+		return nil, failed(ctx, err)
+	}
+
+	defer rows.Close()
+
+	found := []*storage.Link{}
+	for rows.Next() {
+		l, err := scan(ctx, rows)
+		if err != nil {
+			return nil, err
+		}
+
+		found = append(found, l)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, failed(ctx, err)
+	}
+
+	return found, nil
+}
+
+// Put saves a URL to the datastore
+func (p *Postgres) Put(ctx context.Context, f *url.URL, t *url.URL) error {
+	return p.PutLink(ctx, &storage.Link{From: f, To: t})
+}
+
+// PutLink saves a link, complete with its metadata, to the datastore. Where there is already a link at the same
+// address, only its owner is able to replace it.
+//
+// The ownership check is a condition on the update itself, so there is no opportunity for another writer to race
+// between the check and the write. Where the condition is not met, nothing is written.
+func (p *Postgres) PutLink(ctx context.Context, l *storage.Link) error {
+	l = storage.NormalizeLink(l)
+
+	agent, _ := ctx.Value(storage.CtxKeyAgent).(string)
+
+	tag, err := p.pool.Exec(ctx, `
+		INSERT INTO links (from_url, host, to_url, owner, created, updated, description, tags, status, expires, prefix, query_policy, template)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		ON CONFLICT (from_url) DO UPDATE SET
+			to_url       = excluded.to_url,
+			owner        = excluded.owner,
+			updated      = excluded.updated,
+			description  = excluded.description,
+			tags         = excluded.tags,
+			status       = excluded.status,
+			expires      = excluded.expires,
+			prefix       = excluded.prefix,
+			query_policy = excluded.query_policy
+		WHERE links.owner = $14
+	`, append(values(storage.Stamp(ctx, l, nil)), agent)...)
+	if err != nil {
+		return failed(ctx, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return storage.ErrUnauthorized
+	}
+
+	return nil
+}
+
+// Create saves a URL to the datastore, but only if there is not already a URL stored at that address. The insert
+// ignores conflicts, so whether it wrote anything indicates whether the address was free.
+func (p *Postgres) Create(ctx context.Context, l *storage.Link) error {
+	l = storage.NormalizeLink(l)
+
+	tag, err := p.pool.Exec(ctx, `
+		INSERT INTO links (from_url, host, to_url, owner, created, updated, description, tags, status, expires, prefix, query_policy, template)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		ON CONFLICT (from_url) DO NOTHING
+	`, values(storage.Stamp(ctx, l, nil))...)
+	if err != nil {
+		return failed(ctx, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return storage.ErrAlreadyExists
+	}
+
+	return nil
+}
+
+// CreateFolded saves a URL to the datastore, but only if there is neither a URL at that address, nor one that differs
+// from it only by case. See storage.FoldedCreator.
+//
+// The folded URL of the link is recorded, and is unique in the table, so of two links that differ only by case and are
+// created at the same time, only one is written. Links that were written otherwise do not have a folded URL recorded,
+// so are checked for (in the same transaction) by the index of the lower case URL.
+func (p *Postgres) CreateFolded(ctx context.Context, l *storage.Link) error {
+	l = storage.NormalizeLink(l)
+
+	folded := storage.Fold(l.From)
+
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return failed(ctx, err)
+	}
+
+	// Rollback is a no-op once the transaction has been committed.
+	defer func() { _ = tx.Rollback(context.Background()) }()
+
+	// The link at the URL itself (if there is one) sorts first.
+	var k string
+	if err := tx.QueryRow(ctx,
+		"SELECT from_url FROM links WHERE lower(from_url) = $1 ORDER BY from_url <> $2 LIMIT 1",
+		folded, l.From.String(),
+	).Scan(&k); err == nil {
+		if k == l.From.String() {
+			return storage.ErrAlreadyExists
+		}
+
+		return storage.ErrCaseCollision
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return failed(ctx, err)
+	}
+
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO links (from_url, host, to_url, owner, created, updated, description, tags, status, expires, prefix, query_policy, template, folded)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		ON CONFLICT DO NOTHING
+	`, append(values(storage.Stamp(ctx, l, nil)), folded)...)
+	if err != nil {
+		return failed(ctx, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		// Another link was written since it was checked; either at the same address, or at one that differs by case.
+		if _, err := scan(ctx, tx.QueryRow(ctx, "SELECT "+columns+" FROM links WHERE from_url = $1", l.From.String())); err == nil {
+			return storage.ErrAlreadyExists
+		} else if !errors.Is(err, pgx.ErrNoRows) {
+			return err
+		}
+
+		return storage.ErrCaseCollision
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return failed(ctx, err)
+	}
+
+	return nil
+}
+
+// Delete removes a URL from the datastore. Only the owner of the link is able to remove it.
+func (p *Postgres) Delete(ctx context.Context, in *url.URL) error {
+	in = storage.Normalize(in)
+
+	agent, _ := ctx.Value(storage.CtxKeyAgent).(string)
+
+	tag, err := p.pool.Exec(ctx, "DELETE FROM links WHERE from_url = $1 AND owner = $2", in.String(), agent)
+	if err != nil {
+		return failed(ctx, err)
+	}
+
+	if tag.RowsAffected() > 0 {
+		return nil
+	}
+
+	// Nothing was deleted; either because there is nothing there, or because it is owned by somebody else.
+	if _, err := p.GetLink(ctx, in); err != nil {
+		return err
+	}
+
+	return storage.ErrUnauthorized
+}
+
+// Purge removes the links in the datastore that have expired.
+func (p *Postgres) Purge(ctx context.Context, before time.Time) (int, error) {
+	tag, err := p.pool.Exec(ctx, "DELETE FROM links WHERE expires <= $1", before)
+	if err != nil {
+		return 0, failed(ctx, err)
+	}
+
+	return int(tag.RowsAffected()), nil
+}
+
+// Owns implements the interface validating whether a user actually owns this record.
+func (p *Postgres) Owns(ctx context.Context, u *url.URL) bool {
+	agent, ok := ctx.Value(storage.CtxKeyAgent).(string)
+	if !ok || agent == "" {
+		return false
+	}
+
+	l, err := p.GetLink(ctx, u)
+	if err != nil {
+		return false
+	}
+
+	return l.Owner == agent
+}
+
+// List pages through the URLs in the datastore, in the order of the short link. The cursor is the last short link of
+// the previous page; the host and owner filters are both indexed.
+func (p *Postgres) List(ctx context.Context, opts storage.ListOptions) (*storage.Page, error) {
+	where := []string{"from_url > $1"}
+	args := []any{opts.Cursor}
+
+	if opts.Host != "" {
+		args = append(args, opts.Host)
+		where = append(where, "host = $"+strconv.Itoa(len(args)))
+	}
+
+	if opts.Owner != "" {
+		args = append(args, opts.Owner)
+		where = append(where, "owner = $"+strconv.Itoa(len(args)))
+	}
+
+	// Query for a single link more than is needed, so as to know whether there is another page.
+	args = append(args, opts.Size()+1)
+
+	rows, err := p.pool.Query(ctx,
+		"SELECT "+columns+" FROM links WHERE "+strings.Join(where, " AND ")+
+			" ORDER BY from_url LIMIT $"+strconv.Itoa(len(args)),
+		args...,
+	)
+	if err != nil {
+		return nil, failed(ctx, err)
+	}
+
+	defer rows.Close()
+
+	page := &storage.Page{Links: []*storage.Link{}}
+	for rows.Next() {
+		if len(page.Links) == opts.Size() {
+			page.Next = page.Links[len(page.Links)-1].From.String()
+			break
+		}
+
+		l, err := scan(ctx, rows)
+		if err != nil {
+			return nil, err
+		}
+
+		page.Links = append(page.Links, l)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, failed(ctx, err)
+	}
+
+	return page, nil
+}
+
+// scan converts a row (in the order of columns) into a link.
+func scan(ctx context.Context, row pgx.Row) (*storage.Link, error) {
+	var (
+		from, to string
+		query    string
+		expires  *time.Time
+		l        = &storage.Link{}
+	)
+
+	if err := row.Scan(&from, &to, &l.Owner, &l.Created, &l.Updated, &l.Description, &l.Tags, &l.Status, &expires, &l.Prefix, &query); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, err
+		}
+
+		return nil, failed(ctx, err)
+	}
+
+	l.Query = storage.QueryPolicy(query)
+
+	var err error
+	if l.From, err = url.Parse(from); err != nil {
+		return nil, fmt.Errorf("%w: %s", storage.ErrCorrupt, err)
+	}
+
+	if l.To, err = url.Parse(to); err != nil {
+		return nil, fmt.Errorf("%w: %s", storage.ErrCorrupt, err)
+	}
+
+	// Links without tags are stored with an empty array, but are otherwise represented without any.
+	if len(l.Tags) == 0 {
+		l.Tags = nil
+	}
+
+	if expires != nil {
+		l.Expires = *expires
+	}
+
+	return l, nil
+}
+
+// values converts the link into the arguments for an insert, in the order of the columns in the table.
+func values(l *storage.Link) []any {
+	tags := l.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	var expires *time.Time
+	if !l.Expires.IsZero() {
+		expires = &l.Expires
+	}
+
+	return []any{
+		l.From.String(),
+		l.From.Host,
+		l.To.String(),
+		l.Owner,
+		l.Created,
+		l.Updated,
+		l.Description,
+		tags,
+		l.Status,
+		expires,
+		l.Prefix,
+		string(l.Query),
+		l.IsTemplate(),
+	}
+}
+
+// failed wraps an error from the database as ErrFailed. Where the context was cancelled (or its deadline exceeded),
+// that is the error preserved, so the caller is able to tell it apart from a failure of the database.
+func failed(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("%w: %w", storage.ErrFailed, ctx.Err())
+	}
+
+	return fmt.Errorf("%w: %s", storage.ErrFailed, err)
+}
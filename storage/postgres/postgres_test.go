@@ -0,0 +1,333 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/andrewhowdencom/x40.link/storage"
+	"github.com/jackc/pgx/v5"
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// The tests are run against a mock of the database, so that they do not need a PostgreSQL server; they validate the
+// statements the storage makes, and what it makes of their results. The statements themselves are validated against
+// a server by the external conformance suite.
+
+// expectMigrate expects the migrations to be locked and the schema version to be read, finding the version supplied.
+func expectMigrate(mock pgxmock.PgxConnIface, version int) {
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_lock($1)")).
+		WithArgs(migrationLock).
+		WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").
+		WillReturnResult(pgxmock.NewResult("CREATE", 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0) FROM schema_migrations")).
+		WillReturnRows(pgxmock.NewRows([]string{"version"}).AddRow(version))
+}
+
+// expectUnlock expects the lock on the migrations to be released.
+func expectUnlock(mock pgxmock.PgxConnIface) {
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_unlock($1)")).
+		WithArgs(migrationLock).
+		WillReturnResult(pgxmock.NewResult("SELECT", 1))
+}
+
+// expectCommit expects a migration to be committed. The migrations are run by pgx.BeginFunc, which rolls back every
+// transaction once it is done; the rollback of one that was already committed fails (and is ignored) as it is closed.
+func expectCommit(mock pgxmock.PgxConnIface) {
+	mock.ExpectCommit()
+	mock.ExpectRollback().WillReturnError(pgx.ErrTxClosed)
+}
+
+// expectRollback expects a migration to be rolled back, where it has failed.
+func expectRollback(mock pgxmock.PgxConnIface) {
+	mock.ExpectRollback()
+	mock.ExpectRollback().WillReturnError(pgx.ErrTxClosed)
+}
+
+// TestMigrations validates that a database that is up to date is not migrated again, that a database written by a
+// newer version is refused rather than modified, and that each migration is applied (and recorded) in its own
+// transaction, which is rolled back where the migration fails.
+func TestMigrations(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name   string
+		expect func(mock pgxmock.PgxConnIface)
+		err    error
+	}{
+		{
+			name: "up to date",
+			expect: func(mock pgxmock.PgxConnIface) {
+				expectMigrate(mock, len(migrations))
+				expectUnlock(mock)
+			},
+		},
+		{
+			name: "too new",
+			expect: func(mock pgxmock.PgxConnIface) {
+				expectMigrate(mock, len(migrations)+1)
+				expectUnlock(mock)
+			},
+			err: ErrSchemaTooNew,
+		},
+		{
+			name: "one behind",
+			expect: func(mock pgxmock.PgxConnIface) {
+				expectMigrate(mock, len(migrations)-1)
+				mock.ExpectBegin()
+				mock.ExpectExec("ALTER TABLE links ADD COLUMN folded").WillReturnResult(pgxmock.NewResult("ALTER", 0))
+				mock.ExpectExec(regexp.QuoteMeta("INSERT INTO schema_migrations (version) VALUES ($1)")).
+					WithArgs(len(migrations)).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
+				expectCommit(mock)
+				expectUnlock(mock)
+			},
+		},
+		{
+			name: "failed",
+			expect: func(mock pgxmock.PgxConnIface) {
+				expectMigrate(mock, len(migrations)-1)
+				mock.ExpectBegin()
+				mock.ExpectExec("ALTER TABLE links ADD COLUMN folded").WillReturnError(errors.New("I'm the test error"))
+				expectRollback(mock)
+				expectUnlock(mock)
+			},
+			err: errors.New("migration 7: I'm the test error"),
+		},
+	} {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			mock, err := pgxmock.NewConn()
+			assert.Nil(t, err)
+
+			tc.expect(mock)
+
+			err = migrate(context.Background(), mock)
+			if tc.err == nil {
+				assert.Nil(t, err)
+			} else if !errors.Is(err, tc.err) {
+				assert.EqualError(t, err, tc.err.Error())
+			}
+
+			assert.Nil(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+// TestMigrateNormalize validates that keys written before they were normalized are rewritten in their normalized form,
+// keeping the link most recently updated where several normalize to the same key, and that templates written before
+// they were flagged are flagged.
+func TestMigrateNormalize(t *testing.T) {
+	t.Parallel()
+
+	older, newer := time.Unix(1000, 0), time.Unix(2000, 0)
+
+	mock, err := pgxmock.NewConn()
+	assert.Nil(t, err)
+
+	expectMigrate(mock, 4)
+
+	// 5: Normalize the keys.
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT from_url, updated FROM links").WillReturnRows(pgxmock.NewRows([]string{"from_url", "updated"}).
+		AddRow("//X40/Older", older).
+		AddRow("//x40/Older", newer).
+		AddRow("//x40:443/Newer", newer).
+		AddRow("//x40/Newer", older).
+		AddRow("//x40/%7Brepo%7D", older))
+
+	// The key in the normalized form was updated more recently, so the other is removed.
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT updated FROM links WHERE from_url = $1")).
+		WithArgs("//x40/Older").
+		WillReturnRows(pgxmock.NewRows([]string{"updated"}).AddRow(newer))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM links WHERE from_url = $1")).
+		WithArgs("//X40/Older").
+		WillReturnResult(pgxmock.NewResult("DELETE", 1))
+
+	// The key being moved was updated more recently, so replaces the one in the normalized form.
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT updated FROM links WHERE from_url = $1")).
+		WithArgs("//x40/Newer").
+		WillReturnRows(pgxmock.NewRows([]string{"updated"}).AddRow(older))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM links WHERE from_url = $1")).
+		WithArgs("//x40/Newer").
+		WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE links SET from_url = $1, host = $2 WHERE from_url = $3")).
+		WithArgs("//x40/Newer", "x40", "//x40:443/Newer").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO schema_migrations (version) VALUES ($1)")).
+		WithArgs(5).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	expectCommit(mock)
+
+	// 6: Flag the templates.
+	mock.ExpectBegin()
+	mock.ExpectExec("ALTER TABLE links ADD COLUMN template").WillReturnResult(pgxmock.NewResult("ALTER", 0))
+	mock.ExpectQuery("SELECT from_url FROM links").WillReturnRows(pgxmock.NewRows([]string{"from_url"}).
+		AddRow("//x40/Older").
+		AddRow("//x40/Newer").
+		AddRow("//x40/%7Brepo%7D"))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE links SET template = TRUE WHERE from_url = $1")).
+		WithArgs("//x40/%7Brepo%7D").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO schema_migrations (version) VALUES ($1)")).
+		WithArgs(6).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	expectCommit(mock)
+
+	// 7: Add the folded URL.
+	mock.ExpectBegin()
+	mock.ExpectExec("ALTER TABLE links ADD COLUMN folded").WillReturnResult(pgxmock.NewResult("ALTER", 0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO schema_migrations (version) VALUES ($1)")).
+		WithArgs(7).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	expectCommit(mock)
+
+	expectUnlock(mock)
+
+	assert.Nil(t, migrate(context.Background(), mock))
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+// TestPutLinkOwnership validates that a link is only replaced where it is owned by the agent writing it; the upsert is
+// guarded by the owner, so where it writes nothing the link belongs to somebody else.
+func TestPutLinkOwnership(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name     string
+		agent    string
+		affected int64
+		err      error
+	}{
+		{name: "owner", agent: "alice", affected: 1},
+		{name: "another agent", agent: "bob", err: storage.ErrUnauthorized},
+		{name: "no agent", err: storage.ErrUnauthorized},
+	} {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			mock, err := pgxmock.NewPool()
+			assert.Nil(t, err)
+
+			p := &Postgres{pool: mock}
+
+			// Every column of the link, followed by the agent that must own any existing link.
+			args := []any{"//x40/foo", "x40", "//k3s/bar"}
+			for len(args) < 13 {
+				args = append(args, pgxmock.AnyArg())
+			}
+
+			mock.ExpectExec("INSERT INTO links").
+				WithArgs(append(args, tc.agent)...).
+				WillReturnResult(pgxmock.NewResult("INSERT", tc.affected))
+
+			ctx := context.Background()
+			if tc.agent != "" {
+				ctx = context.WithValue(ctx, storage.CtxKeyAgent, tc.agent)
+			}
+
+			err = p.PutLink(ctx, &storage.Link{
+				From:  &url.URL{Host: "X40", Path: "/foo"},
+				To:    &url.URL{Host: "k3s", Path: "/bar"},
+				Owner: "alice",
+			})
+			assert.ErrorIs(t, err, tc.err)
+			assert.Nil(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+// TestCreateFolded validates that links are not created where there is a link at a URL that differs from them only by
+// case, whether it is found as the link is checked or is written by another replica before the link is.
+func TestCreateFolded(t *testing.T) {
+	t.Parallel()
+
+	const (
+		from   = "//x40/abc"
+		folded = "//x40/abc"
+	)
+
+	check := regexp.QuoteMeta("SELECT from_url FROM links WHERE lower(from_url) = $1")
+
+	// Every column of the link, followed by its folded URL.
+	args := make([]any, 14)
+	for i := range args {
+		args[i] = pgxmock.AnyArg()
+	}
+
+	for _, tc := range []struct {
+		name   string
+		expect func(mock pgxmock.PgxPoolIface)
+		err    error
+	}{
+		{
+			name: "created",
+			expect: func(mock pgxmock.PgxPoolIface) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(check).WithArgs(folded, from).WillReturnRows(pgxmock.NewRows([]string{"from_url"}))
+				mock.ExpectExec("INSERT INTO links").WithArgs(args...).WillReturnResult(pgxmock.NewResult("INSERT", 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "exists",
+			expect: func(mock pgxmock.PgxPoolIface) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(check).WithArgs(folded, from).WillReturnRows(pgxmock.NewRows([]string{"from_url"}).AddRow(from))
+				mock.ExpectRollback()
+			},
+			err: storage.ErrAlreadyExists,
+		},
+		{
+			name: "differs by case",
+			expect: func(mock pgxmock.PgxPoolIface) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(check).WithArgs(folded, from).WillReturnRows(pgxmock.NewRows([]string{"from_url"}).AddRow("//x40/AbC"))
+				mock.ExpectRollback()
+			},
+			err: storage.ErrCaseCollision,
+		},
+		{
+			name: "written since it was checked",
+			expect: func(mock pgxmock.PgxPoolIface) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(check).WithArgs(folded, from).WillReturnRows(pgxmock.NewRows([]string{"from_url"}))
+				mock.ExpectExec("INSERT INTO links").WithArgs(args...).WillReturnResult(pgxmock.NewResult("INSERT", 0))
+				mock.ExpectQuery("SELECT from_url, to_url").WithArgs(from).WillReturnRows(pgxmock.NewRows([]string{"from_url"}))
+				mock.ExpectRollback()
+			},
+			err: storage.ErrCaseCollision,
+		},
+	} {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			mock, err := pgxmock.NewPool()
+			assert.Nil(t, err)
+
+			tc.expect(mock)
+
+			p := &Postgres{pool: mock}
+
+			err = p.CreateFolded(context.Background(), &storage.Link{
+				From: &url.URL{Host: "x40", Path: "/abc"},
+				To:   &url.URL{Host: "k3s"},
+			})
+			assert.ErrorIs(t, err, tc.err)
+			assert.Nil(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// Wildcard is the suffix with which a prefix link is written, where links are written as text. For example,
+// //go.example/docs/* is the prefix link at //go.example/docs; the same suffix on the destination is optional.
+const Wildcard = "/*"
+
+// TrimWildcard returns a copy of the URL without the trailing Wildcard, and whether there was one to trim.
+func TrimWildcard(u *url.URL) (*url.URL, bool) {
+	p, ok := strings.CutSuffix(u.Path, Wildcard)
+	if !ok {
+		return u, false
+	}
+
+	n := *u
+	n.Path = p
+	n.RawPath = ""
+
+	return &n, true
+}
+
+// Prefixer is an extension to the storage interface that finds the prefix link that a URL is beneath, for storage
+// that is able to do so more efficiently than looking up each of its parents in turn.
+//
+// A URL is beneath a prefix link where it is on the same host, and its path either is the path of the link, or
+// continues from it at a "/". Where a URL is beneath more than one prefix link, the one with the longest path wins.
+// Returns ErrNotFound where the URL is beneath no prefix link.
+type Prefixer interface {
+	GetPrefix(ctx context.Context, u *url.URL) (*Link, error)
+}
+
+// GetPrefix finds the prefix link that the URL is beneath. Where the storage is not a Prefixer, each of the paths that
+// the URL is able to be beneath is looked up in turn, from the longest to the host itself.
+func GetPrefix(ctx context.Context, str Storer, u *url.URL) (*Link, error) {
+	u = Normalize(u)
+
+	if p, ok := str.(Prefixer); ok {
+		return p.GetPrefix(ctx, u)
+	}
+
+	for i := len(u.Path); i >= 0; i-- {
+		if !boundary(u.Path, i) {
+			continue
+		}
+
+		l, err := GetLink(ctx, str, &url.URL{Host: u.Host, Path: u.Path[:i]})
+		if errors.Is(err, ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if l.Prefix {
+			return l, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+// IsPrefixOf indicates whether the URL is beneath this link, where this link is a prefix link. See Prefixer.
+func (l *Link) IsPrefixOf(u *url.URL) bool {
+	return l.Prefix &&
+		l.From.Host == u.Host &&
+		strings.HasPrefix(u.Path, l.From.Path) &&
+		boundary(u.Path, len(l.From.Path))
+}
+
+// Destination returns where a request for the URL is sent. For a prefix link, the part of the path of the URL that
+// continues beyond the path of the link is appended to the path of the destination; so, with the link
+// //go.example/docs/* → https://docs.example.com/*, //go.example/docs/a/b is sent to https://docs.example.com/a/b.
+// For a template, the segments captured by each placeholder are substituted into the destination; so, with the link
+// //go.example/gh/{repo} → https://github.com/org/{repo}, //go.example/gh/x40 is sent to https://github.com/org/x40.
+// Otherwise, it is the destination of the link.
+func (l *Link) Destination(u *url.URL) *url.URL {
+	if captured, ok := l.Match(u); ok {
+		return l.expand(captured)
+	}
+
+	if !l.IsPrefixOf(u) || len(u.Path) == len(l.From.Path) {
+		return l.To
+	}
+
+	n := *l.To
+	n.RawPath = ""
+	n.Path = strings.TrimSuffix(l.To.Path, "/") + "/" + strings.TrimPrefix(u.Path[len(l.From.Path):], "/")
+
+	return &n
+}
+
+// boundary indicates whether the path is able to be beneath a prefix link at its first i bytes; that is, whether the
+// prefix ends the path, or ends (or is followed by) a "/".
+func boundary(p string, i int) bool {
+	return i == len(p) || p[i] == '/' || (i > 0 && p[i-1] == '/')
+}
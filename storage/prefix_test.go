@@ -0,0 +1,83 @@
+package storage_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/andrewhowdencom/x40.link/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrimWildcard(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		in       string
+		expected string
+		wildcard bool
+	}{
+		{in: "//x40/docs/*", expected: "//x40/docs", wildcard: true},
+		{in: "//x40/*", expected: "//x40", wildcard: true},
+		{in: "//x40/docs", expected: "//x40/docs"},
+		{in: "//x40/docs*", expected: "//x40/docs*"},
+	} {
+		u, err := url.Parse(tc.in)
+		assert.Nil(t, err)
+
+		res, wildcard := storage.TrimWildcard(u)
+		assert.Equal(t, tc.expected, res.String(), tc.in)
+		assert.Equal(t, tc.wildcard, wildcard, tc.in)
+	}
+}
+
+func TestDestination(t *testing.T) {
+	t.Parallel()
+
+	prefix := &storage.Link{
+		From:   &url.URL{Host: "x40", Path: "/docs"},
+		To:     &url.URL{Scheme: "https", Host: "docs.example.com", Path: "/v1", RawQuery: "ref=x40"},
+		Prefix: true,
+	}
+
+	exact := &storage.Link{
+		From: &url.URL{Host: "x40", Path: "/docs"},
+		To:   &url.URL{Scheme: "https", Host: "docs.example.com"},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		link     *storage.Link
+		in       *url.URL
+		expected string
+	}{
+		{name: "link itself", link: prefix, in: &url.URL{Host: "x40", Path: "/docs"}, expected: "https://docs.example.com/v1?ref=x40"},
+		{name: "beneath", link: prefix, in: &url.URL{Host: "x40", Path: "/docs/a/b"}, expected: "https://docs.example.com/v1/a/b?ref=x40"},
+		{name: "trailing slash", link: prefix, in: &url.URL{Host: "x40", Path: "/docs/"}, expected: "https://docs.example.com/v1/?ref=x40"},
+		{name: "not on a boundary", link: prefix, in: &url.URL{Host: "x40", Path: "/docsfoo"}, expected: "https://docs.example.com/v1?ref=x40"},
+		{name: "not a prefix", link: exact, in: &url.URL{Host: "x40", Path: "/docs/a"}, expected: "https://docs.example.com"},
+	} {
+		assert.Equal(t, tc.expected, tc.link.Destination(tc.in).String(), tc.name)
+	}
+
+	// The destination of the link is not modified.
+	assert.Equal(t, "/v1", prefix.To.Path)
+}
+
+func TestIsPrefixOf(t *testing.T) {
+	t.Parallel()
+
+	l := &storage.Link{From: &url.URL{Host: "x40", Path: "/docs"}, Prefix: true}
+
+	assert.True(t, l.IsPrefixOf(&url.URL{Host: "x40", Path: "/docs"}))
+	assert.True(t, l.IsPrefixOf(&url.URL{Host: "x40", Path: "/docs/a"}))
+	assert.False(t, l.IsPrefixOf(&url.URL{Host: "x40", Path: "/docsa"}))
+	assert.False(t, l.IsPrefixOf(&url.URL{Host: "k3s", Path: "/docs/a"}))
+	assert.False(t, l.IsPrefixOf(&url.URL{Host: "x40", Path: "/"}))
+
+	// The host prefix matches everything on the host.
+	root := &storage.Link{From: &url.URL{Host: "x40"}, Prefix: true}
+	assert.True(t, root.IsPrefixOf(&url.URL{Host: "x40", Path: "/anything"}))
+
+	l.Prefix = false
+	assert.False(t, l.IsPrefixOf(&url.URL{Host: "x40", Path: "/docs/a"}))
+}
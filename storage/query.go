@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// QueryPolicy is how the query string with which a link is requested is forwarded to its destination.
+//
+// Fragments are not sent to the server, so are not subject to the policy; browsers carry the fragment of the link over
+// to the destination where the destination does not have one of its own.
+type QueryPolicy string
+
+// The supported policies.
+const (
+	// QueryDefault means the link uses the policy of the server.
+	QueryDefault QueryPolicy = ""
+
+	// QueryDrop discards the query string; the destination is used as it is.
+	QueryDrop QueryPolicy = "drop"
+
+	// QueryAppend adds every parameter of the query string after those of the destination, even where the destination
+	// already has a parameter of the same name.
+	QueryAppend QueryPolicy = "append"
+
+	// QueryMerge adds the parameters of the query string after those of the destination, except those that the
+	// destination already has; where both have a parameter, the destination wins.
+	QueryMerge QueryPolicy = "merge"
+)
+
+// QueryPolicies are the policies with which a link is able to forward its query string.
+var QueryPolicies = []QueryPolicy{QueryDrop, QueryAppend, QueryMerge}
+
+// ValidQuery indicates whether the policy is one that is supported. The empty policy is also valid, as it indicates
+// the link should use the server default.
+func ValidQuery(p QueryPolicy) bool {
+	return p == QueryDefault || slices.Contains(QueryPolicies, p)
+}
+
+// Forward returns a copy of the destination with the query string (as it was received, still escaped) forwarded
+// according to the policy. Parameters named in the blocklist are never forwarded; a name ending in "*" blocks every
+// parameter beginning with the rest of the name (e.g. utm_*). The order of the parameters is retained.
+func (p QueryPolicy) Forward(to *url.URL, query string, blocklist []string) *url.URL {
+	if p != QueryAppend && p != QueryMerge {
+		return to
+	}
+
+	existing := map[string]bool{}
+	for _, pair := range strings.Split(to.RawQuery, "&") {
+		if k, ok := queryKey(pair); ok {
+			existing[k] = true
+		}
+	}
+
+	forwarded := []string{}
+	for _, pair := range strings.Split(query, "&") {
+		k, ok := queryKey(pair)
+		if !ok || blocked(k, blocklist) || (p == QueryMerge && existing[k]) {
+			continue
+		}
+
+		forwarded = append(forwarded, pair)
+	}
+
+	if len(forwarded) == 0 {
+		return to
+	}
+
+	n := *to
+	n.RawQuery = strings.Join(append(slices.DeleteFunc(strings.Split(to.RawQuery, "&"), func(pair string) bool {
+		return pair == ""
+	}), forwarded...), "&")
+
+	return &n
+}
+
+// queryKey returns the (unescaped) name of the parameter in a "name=value" pair of a query string, and whether the pair
+// is well formed enough to have one.
+func queryKey(pair string) (string, bool) {
+	if pair == "" {
+		return "", false
+	}
+
+	k, _, _ := strings.Cut(pair, "=")
+
+	k, err := url.QueryUnescape(k)
+	if err != nil {
+		return "", false
+	}
+
+	return k, true
+}
+
+// blocked indicates whether the parameter is named in the blocklist. See QueryPolicy.Forward.
+func blocked(key string, blocklist []string) bool {
+	for _, b := range blocklist {
+		if prefix, ok := strings.CutSuffix(b, "*"); (ok && strings.HasPrefix(key, prefix)) || b == key {
+			return true
+		}
+	}
+
+	return false
+}
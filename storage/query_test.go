@@ -0,0 +1,57 @@
+package storage_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/andrewhowdencom/x40.link/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidQuery(t *testing.T) {
+	t.Parallel()
+
+	for _, p := range append(storage.QueryPolicies, storage.QueryDefault) {
+		assert.True(t, storage.ValidQuery(p), p)
+	}
+
+	assert.False(t, storage.ValidQuery("keep"))
+}
+
+func TestForward(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name      string
+		policy    storage.QueryPolicy
+		to        string
+		query     string
+		blocklist []string
+		expected  string
+	}{
+		{name: "default", to: "https://x40.link/a?b=c", query: "d=e", expected: "https://x40.link/a?b=c"},
+		{name: "drop", policy: storage.QueryDrop, to: "https://x40.link/a?b=c", query: "d=e", expected: "https://x40.link/a?b=c"},
+		{name: "append", policy: storage.QueryAppend, to: "https://x40.link/a?b=c", query: "b=x&d=e", expected: "https://x40.link/a?b=c&b=x&d=e"},
+		{name: "append without a destination query", policy: storage.QueryAppend, to: "https://x40.link/a", query: "d=e&f", expected: "https://x40.link/a?d=e&f"},
+		{name: "merge, destination wins", policy: storage.QueryMerge, to: "https://x40.link/a?b=c", query: "b=x&d=e", expected: "https://x40.link/a?b=c&d=e"},
+		{name: "merge, escaped names", policy: storage.QueryMerge, to: "https://x40.link/a?a+b=c", query: "a%20b=x&%C3%A9=1", expected: "https://x40.link/a?a+b=c&%C3%A9=1"},
+		{name: "no query", policy: storage.QueryMerge, to: "https://x40.link/a?b=c", query: "", expected: "https://x40.link/a?b=c"},
+		{name: "fragment retained", policy: storage.QueryAppend, to: "https://x40.link/a#top", query: "d=e", expected: "https://x40.link/a?d=e#top"},
+		{
+			name:      "blocklist",
+			policy:    storage.QueryAppend,
+			to:        "https://x40.link/a",
+			query:     "token=s3cr3t&utm_source=mail&utm_medium=email&utm=1&d=e",
+			blocklist: []string{"token", "utm_*"},
+			expected:  "https://x40.link/a?utm=1&d=e",
+		},
+		{name: "malformed pairs skipped", policy: storage.QueryAppend, to: "https://x40.link/a", query: "&%zz=1&&d=e", expected: "https://x40.link/a?d=e"},
+	} {
+		to, err := url.Parse(tc.to)
+		assert.Nil(t, err)
+
+		res := tc.policy.Forward(to, tc.query, tc.blocklist)
+		assert.Equal(t, tc.expected, res.String(), tc.name)
+		assert.Equal(t, tc.to, to.String(), tc.name)
+	}
+}
@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Log is the logger for the library. Uses the default structured logger, but can be overridden to disable the output
+// for this package.
+var Log = slog.Default()
+
+// Reap purges expired links from the storage at every interval, until the context is cancelled. Failures are logged,
+// and the purge tried again at the next interval. Blocks, so is expected to be run in its own goroutine.
+func Reap(ctx context.Context, p Purger, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			n, err := p.Purge(ctx, now)
+			if err != nil {
+				Log.Error("failed to purge expired links", "err", err)
+				continue
+			}
+
+			Log.Debug("purged expired links", "count", n)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/andrewhowdencom/x40.link/storage/frozen"
+)
+
+// migration brings the schema (or the data) up to date by one version, within the transaction of the migrate.
+type migration func(ctx context.Context, tx *sql.Tx) error
+
+// exec is a migration that executes the statements.
+func exec(stmts string) migration {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, stmts)
+		return err
+	}
+}
+
+// migrations bring the schema up to date, in the order in which they must be applied. The schema version stored in the
+// database is the number of migrations that have been applied to it.
+//
+// Migrations that have been released must never be modified; instead, append another.
+var migrations = []migration{
+	// 1: The initial schema. The short link is stored both in full (as the key, and the order in which links are
+	// listed) and split into its host, so links can be queried by host without a table scan.
+	exec(`
+	CREATE TABLE links (
+		from_url    TEXT    NOT NULL PRIMARY KEY,
+		host        TEXT    NOT NULL,
+		to_url      TEXT    NOT NULL,
+		owner       TEXT    NOT NULL DEFAULT '',
+		created     INTEGER NOT NULL,
+		updated     INTEGER NOT NULL,
+		description TEXT    NOT NULL DEFAULT '',
+		tags        TEXT    NOT NULL DEFAULT '[]',
+		status      INTEGER NOT NULL DEFAULT 0,
+		expires     INTEGER
+	);
+
+	CREATE INDEX links_host    ON links (host, from_url);
+	CREATE INDEX links_owner   ON links (owner, from_url);
+	CREATE INDEX links_expires ON links (expires) WHERE expires IS NOT NULL;
+	`),
+
+	// 2: Prefix links match every path beneath their own, as well as their own.
+	exec(`ALTER TABLE links ADD COLUMN prefix INTEGER NOT NULL DEFAULT 0;`),
+
+	// 3: How the query string is forwarded to the destination. Empty means the server default.
+	exec(`ALTER TABLE links ADD COLUMN query_policy TEXT NOT NULL DEFAULT '';`),
+
+	// 4: Links are able to be found without regard to case, by their folded URL (see storage.Fold).
+	exec(`CREATE INDEX links_folded ON links (lower(from_url), from_url);`),
+
+	// 5: Keys were written as they were supplied, so the same link written in different ways (such as with the host in
+	// upper case, or with a default port) was stored more than once. Rewrite each key in its normalized form (see
+	// frozen.Normalize); where several keys normalize to the same one, the link most recently updated is kept.
+	func(ctx context.Context, tx *sql.Tx) error {
+		type move struct {
+			from, to, host string
+			updated        int64
+		}
+
+		rows, err := tx.QueryContext(ctx, "SELECT from_url, updated FROM links ORDER BY from_url")
+		if err != nil {
+			return err
+		}
+
+		// The rows are collected before any are moved, as the table is not able to be modified while it is read.
+		moved := []move{}
+		for rows.Next() {
+			var m move
+			if err := rows.Scan(&m.from, &m.updated); err != nil {
+				_ = rows.Close()
+				return err
+			}
+
+			from, err := url.Parse(m.from)
+			if err != nil {
+				_ = rows.Close()
+				return fmt.Errorf("link %s: %s", m.from, err)
+			}
+
+			n := frozen.Normalize(from)
+			if m.to, m.host = n.String(), n.Host; m.to != m.from {
+				moved = append(moved, m)
+			}
+		}
+
+		if err := rows.Close(); err != nil {
+			return err
+		}
+
+		for _, m := range moved {
+			var updated int64
+			err := tx.QueryRowContext(ctx, "SELECT updated FROM links WHERE from_url = ?", m.to).Scan(&updated)
+
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+			case err != nil:
+				return err
+			case updated > m.updated:
+				if _, err := tx.ExecContext(ctx, "DELETE FROM links WHERE from_url = ?", m.from); err != nil {
+					return err
+				}
+
+				continue
+			default:
+				if _, err := tx.ExecContext(ctx, "DELETE FROM links WHERE from_url = ?", m.to); err != nil {
+					return err
+				}
+			}
+
+			if _, err := tx.ExecContext(ctx, "UPDATE links SET from_url = ?, host = ? WHERE from_url = ?", m.to, m.host, m.from); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+	// 6: Link templates are flagged, and indexed by their host, so that the templates a URL could match are found
+	// without scanning the host (see storage.Templater, and frozen.IsTemplate).
+	func(ctx context.Context, tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `
+			ALTER TABLE links ADD COLUMN template INTEGER NOT NULL DEFAULT 0;
+
+			CREATE INDEX links_template ON links (host, from_url) WHERE template;
+		`); err != nil {
+			return err
+		}
+
+		rows, err := tx.QueryContext(ctx, "SELECT from_url FROM links")
+		if err != nil {
+			return err
+		}
+
+		// The rows are collected before any are updated, as the table is not able to be modified while it is read.
+		found := []string{}
+		for rows.Next() {
+			var from string
+			if err := rows.Scan(&from); err != nil {
+				_ = rows.Close()
+				return err
+			}
+
+			u, err := url.Parse(from)
+			if err != nil {
+				_ = rows.Close()
+				return fmt.Errorf("link %s: %s", from, err)
+			}
+
+			if frozen.IsTemplate(u.Path) {
+				found = append(found, from)
+			}
+		}
+
+		if err := rows.Close(); err != nil {
+			return err
+		}
+
+		for _, from := range found {
+			if _, err := tx.ExecContext(ctx, "UPDATE links SET template = TRUE WHERE from_url = ?", from); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+
+	// 7: Links created on hosts where paths are matched without regard to case are unique by their folded URL (see
+	// storage.FoldedCreator). The folded URL is only recorded for those links; the others may differ only by case.
+	exec(`
+	ALTER TABLE links ADD COLUMN folded TEXT;
+
+	CREATE UNIQUE INDEX links_folded_unique ON links (folded);
+	`),
+}
+
+// migrate applies the migrations that have not yet been applied to the database. The version is tracked in the
+// user_version pragma, and updated in the same transaction as the migrations are applied so that a failed migration
+// leaves the database as it was.
+func migrate(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// Rollback is a no-op once the transaction has been committed.
+	defer func() { _ = tx.Rollback() }()
+
+	var version int
+	if err := tx.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	if version > len(migrations) {
+		return fmt.Errorf("%w: schema version %d is newer than this version supports (%d)", ErrSchemaTooNew, version, len(migrations))
+	}
+
+	if version == len(migrations) {
+		return nil
+	}
+
+	for i, m := range migrations[version:] {
+		if err := m(ctx, tx); err != nil {
+			return fmt.Errorf("migration %d: %s", version+i+1, err)
+		}
+	}
+
+	// Pragmas do not accept bound parameters, but the version is an integer under our control.
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", len(migrations))); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,480 @@
+// Package sqlite implements storage based on a SQLite database, backed by a single file on the filesystem.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/andrewhowdencom/x40.link/storage"
+
+	// Registers the "sqlite" driver. A pure Go implementation, so the binary can still be built without cgo.
+	_ "modernc.org/sqlite"
+)
+
+// Err* are sentinel errors
+var (
+	ErrSchemaTooNew = errors.New("database schema is newer than supported")
+)
+
+// columns are the columns from which a link is read, in the order expected by scan.
+const columns = "from_url, to_url, owner, created, updated, description, tags, status, expires, prefix, query_policy"
+
+// SQLite is an implementation of the link shortener that stores links in a SQLite database:
+//
+// * https://www.sqlite.org/
+//
+// The database is opened in write-ahead log mode, so any number of processes are able to read from the same file
+// while another writes to it.
+type SQLite struct {
+	db *sql.DB
+}
+
+// New opens (or creates) the SQLite database at the path, applying any schema migrations that have not yet been
+// applied.
+func New(path string) (*SQLite, error) {
+	// Writes take the lock at the start of the transaction, rather than attempting to upgrade a read lock part way
+	// through it (which fails immediately, rather than waiting, if another connection is also writing).
+	dsn := (&url.URL{
+		Scheme: "file",
+		Opaque: path,
+		RawQuery: url.Values{
+			"_pragma": []string{"journal_mode(WAL)", "busy_timeout(5000)"},
+			"_txlock": []string{"immediate"},
+		}.Encode(),
+	}).String()
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", storage.ErrStorageSetupFailed, err)
+	}
+
+	if err := migrate(context.Background(), db); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("%w: %s", storage.ErrStorageSetupFailed, err)
+	}
+
+	return &SQLite{db: db}, nil
+}
+
+// Close releases the underlying database.
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
+
+// Get returns a URL, given another input URL
+func (s *SQLite) Get(ctx context.Context, in *url.URL) (*url.URL, error) {
+	l, err := s.GetLink(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.To, nil
+}
+
+// GetLink returns a link, complete with its metadata, given the input URL
+func (s *SQLite) GetLink(ctx context.Context, in *url.URL) (*storage.Link, error) {
+	in = storage.Normalize(in)
+
+	return get(ctx, s.db, in)
+}
+
+// GetFolded returns a link whose URL differs from the input URL only by case, according to the index of folded URLs.
+// See storage.Folder.
+func (s *SQLite) GetFolded(ctx context.Context, in *url.URL) (*storage.Link, error) {
+	l, err := scan(ctx, s.db.QueryRowContext(ctx,
+		"SELECT "+columns+" FROM links WHERE lower(from_url) = ? ORDER BY from_url LIMIT 1",
+		storage.Fold(in),
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, storage.ErrNotFound
+	}
+
+	return l, err
+}
+
+// Templates returns the templates on the host, which are flagged (and indexed) as such. See storage.Templater.
+func (s *SQLite) Templates(ctx context.Context, host string) ([]*storage.Link, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+columns+" FROM links WHERE host = ? AND template ORDER BY from_url",
+		host,
+	)
+	if err != nil {
+		return nil, failed(ctx, err)
+	}
+
+	defer func() { _ = rows.Close() }()
+
+	found := []*storage.Link{}
+	for rows.Next() {
+		l, err := scan(ctx, rows)
+		if err != nil {
+			return nil, err
+		}
+
+		found = append(found, l)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, failed(ctx, err)
+	}
+
+	return found, nil
+}
+
+// Put saves a URL to the datastore
+func (s *SQLite) Put(ctx context.Context, f *url.URL, t *url.URL) error {
+	return s.PutLink(ctx, &storage.Link{From: f, To: t})
+}
+
+// PutLink saves a link, complete with its metadata, to the datastore. Where there is already a link at the same
+// address, only its owner is able to replace it.
+func (s *SQLite) PutLink(ctx context.Context, l *storage.Link) error {
+	l = storage.NormalizeLink(l)
+
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		existing, err := owned(ctx, tx, l.From)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+
+		n := storage.Stamp(ctx, l, existing)
+		args, err := values(n)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO links (from_url, host, to_url, owner, created, updated, description, tags, status, expires, prefix, query_policy, template)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (from_url) DO UPDATE SET
+				to_url       = excluded.to_url,
+				owner        = excluded.owner,
+				created      = excluded.created,
+				updated      = excluded.updated,
+				description  = excluded.description,
+				tags         = excluded.tags,
+				status       = excluded.status,
+				expires      = excluded.expires,
+				prefix       = excluded.prefix,
+				query_policy = excluded.query_policy
+		`, args...); err != nil {
+			return failed(ctx, err)
+		}
+
+		return nil
+	})
+}
+
+// Create saves a URL to the datastore, but only if there is not already a URL stored at that address. The insert
+// ignores conflicts, so whether it wrote anything indicates whether the address was free.
+func (s *SQLite) Create(ctx context.Context, l *storage.Link) error {
+	l = storage.NormalizeLink(l)
+
+	args, err := values(storage.Stamp(ctx, l, nil))
+	if err != nil {
+		return err
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO links (from_url, host, to_url, owner, created, updated, description, tags, status, expires, prefix, query_policy, template)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (from_url) DO NOTHING
+	`, args...)
+	if err != nil {
+		return failed(ctx, err)
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return failed(ctx, err)
+	} else if n == 0 {
+		return storage.ErrAlreadyExists
+	}
+
+	return nil
+}
+
+// CreateFolded saves a URL to the datastore, but only if there is neither a URL at that address, nor one that differs
+// from it only by case. The folded URL is checked in the same (write) transaction as the link is written, and the
+// folded URL of the link recorded, so that it is also unique in the table. See storage.FoldedCreator.
+func (s *SQLite) CreateFolded(ctx context.Context, l *storage.Link) error {
+	l = storage.NormalizeLink(l)
+
+	args, err := values(storage.Stamp(ctx, l, nil))
+	if err != nil {
+		return err
+	}
+
+	folded := storage.Fold(l.From)
+
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		if _, err := get(ctx, tx, l.From); err == nil {
+			return storage.ErrAlreadyExists
+		} else if !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+
+		var k string
+		if err := tx.QueryRowContext(ctx, "SELECT from_url FROM links WHERE lower(from_url) = ? LIMIT 1", folded).Scan(&k); err == nil {
+			return storage.ErrCaseCollision
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return failed(ctx, err)
+		}
+
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO links (from_url, host, to_url, owner, created, updated, description, tags, status, expires, prefix, query_policy, template, folded)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT DO NOTHING
+		`, append(args, folded)...)
+		if err != nil {
+			return failed(ctx, err)
+		}
+
+		if n, err := res.RowsAffected(); err != nil {
+			return failed(ctx, err)
+		} else if n == 0 {
+			return storage.ErrCaseCollision
+		}
+
+		return nil
+	})
+}
+
+// Delete removes a URL from the datastore. Only the owner of the link is able to remove it.
+func (s *SQLite) Delete(ctx context.Context, in *url.URL) error {
+	in = storage.Normalize(in)
+
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		if _, err := owned(ctx, tx, in); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM links WHERE from_url = ?", in.String()); err != nil {
+			return failed(ctx, err)
+		}
+
+		return nil
+	})
+}
+
+// Purge removes the links in the datastore that have expired.
+func (s *SQLite) Purge(ctx context.Context, before time.Time) (int, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM links WHERE expires <= ?", before.UnixNano())
+	if err != nil {
+		return 0, failed(ctx, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, failed(ctx, err)
+	}
+
+	return int(n), nil
+}
+
+// Owns implements the interface validating whether a user actually owns this record.
+func (s *SQLite) Owns(ctx context.Context, u *url.URL) bool {
+	u = storage.Normalize(u)
+
+	agent, ok := ctx.Value(storage.CtxKeyAgent).(string)
+	if !ok || agent == "" {
+		return false
+	}
+
+	l, err := get(ctx, s.db, u)
+	if err != nil {
+		return false
+	}
+
+	return l.Owner == agent
+}
+
+// List pages through the URLs in the datastore, in the order of the short link. The cursor is the last short link of
+// the previous page; the host and owner filters are both indexed.
+func (s *SQLite) List(ctx context.Context, opts storage.ListOptions) (*storage.Page, error) {
+	where := []string{"from_url > ?"}
+	args := []any{opts.Cursor}
+
+	if opts.Host != "" {
+		where = append(where, "host = ?")
+		args = append(args, opts.Host)
+	}
+
+	if opts.Owner != "" {
+		where = append(where, "owner = ?")
+		args = append(args, opts.Owner)
+	}
+
+	// Query for a single link more than is needed, so as to know whether there is another page.
+	args = append(args, opts.Size()+1)
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+columns+" FROM links WHERE "+strings.Join(where, " AND ")+" ORDER BY from_url LIMIT ?",
+		args...,
+	)
+	if err != nil {
+		return nil, failed(ctx, err)
+	}
+
+	defer func() { _ = rows.Close() }()
+
+	page := &storage.Page{Links: []*storage.Link{}}
+	for rows.Next() {
+		if len(page.Links) == opts.Size() {
+			page.Next = page.Links[len(page.Links)-1].From.String()
+			break
+		}
+
+		l, err := scan(ctx, rows)
+		if err != nil {
+			return nil, err
+		}
+
+		page.Links = append(page.Links, l)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, failed(ctx, err)
+	}
+
+	return page, nil
+}
+
+// tx runs the function in a (write) transaction, committing it if the function succeeds.
+func (s *SQLite) tx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return failed(ctx, err)
+	}
+
+	// Rollback is a no-op once the transaction has been committed.
+	defer func() { _ = tx.Rollback() }()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return failed(ctx, err)
+	}
+
+	return nil
+}
+
+// querier is the subset of the database (or transaction) required to read a link.
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// get reads a single link from the database.
+func get(ctx context.Context, q querier, in *url.URL) (*storage.Link, error) {
+	l, err := scan(ctx, q.QueryRowContext(ctx, "SELECT "+columns+" FROM links WHERE from_url = ?", in.String()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, storage.ErrNotFound
+	}
+
+	return l, err
+}
+
+// owned reads the link, failing with ErrUnauthorized where the link exists but the agent in the context is not its
+// owner.
+func owned(ctx context.Context, tx *sql.Tx, in *url.URL) (*storage.Link, error) {
+	l, err := get(ctx, tx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	agent, _ := ctx.Value(storage.CtxKeyAgent).(string)
+	if l.Owner != agent {
+		return nil, storage.ErrUnauthorized
+	}
+
+	return l, nil
+}
+
+// scan converts a row (in the order of columns) into a link.
+func scan(ctx context.Context, row interface{ Scan(dest ...any) error }) (*storage.Link, error) {
+	var (
+		from, to, tags   string
+		query            string
+		created, updated int64
+		expires          sql.NullInt64
+		l                = &storage.Link{}
+	)
+
+	if err := row.Scan(&from, &to, &l.Owner, &created, &updated, &l.Description, &tags, &l.Status, &expires, &l.Prefix, &query); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		return nil, failed(ctx, err)
+	}
+
+	l.Query = storage.QueryPolicy(query)
+
+	var err error
+	if l.From, err = url.Parse(from); err != nil {
+		return nil, fmt.Errorf("%w: %s", storage.ErrCorrupt, err)
+	}
+
+	if l.To, err = url.Parse(to); err != nil {
+		return nil, fmt.Errorf("%w: %s", storage.ErrCorrupt, err)
+	}
+
+	if err := json.Unmarshal([]byte(tags), &l.Tags); err != nil {
+		return nil, fmt.Errorf("%w: %s", storage.ErrCorrupt, err)
+	}
+
+	l.Created = time.Unix(0, created)
+	l.Updated = time.Unix(0, updated)
+
+	if expires.Valid {
+		l.Expires = time.Unix(0, expires.Int64)
+	}
+
+	return l, nil
+}
+
+// values converts the link into the arguments for an insert, in the order of the columns in the table.
+func values(l *storage.Link) ([]any, error) {
+	tags, err := json.Marshal(l.Tags)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", storage.ErrFailed, err)
+	}
+
+	expires := sql.NullInt64{}
+	if !l.Expires.IsZero() {
+		expires = sql.NullInt64{Int64: l.Expires.UnixNano(), Valid: true}
+	}
+
+	return []any{
+		l.From.String(),
+		l.From.Host,
+		l.To.String(),
+		l.Owner,
+		l.Created.UnixNano(),
+		l.Updated.UnixNano(),
+		l.Description,
+		string(tags),
+		l.Status,
+		expires,
+		l.Prefix,
+		string(l.Query),
+		l.IsTemplate(),
+	}, nil
+}
+
+// failed wraps an error from the database as ErrFailed. Where the context was cancelled (or its deadline exceeded),
+// that is the error preserved, so the caller is able to tell it apart from a failure of the database.
+func failed(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("%w: %w", storage.ErrFailed, ctx.Err())
+	}
+
+	return fmt.Errorf("%w: %s", storage.ErrFailed, err)
+}
@@ -0,0 +1,139 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/andrewhowdencom/x40.link/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMigrations validates that reopening a database does not reapply the migrations (losing or corrupting the
+// data), and that a database written by a newer version is refused rather than modified.
+func TestMigrations(t *testing.T) {
+	t.Parallel()
+
+	p := path.Join(t.TempDir(), "links.sqlite")
+
+	db, err := New(p)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(context.Background(), &url.URL{Host: "x40"}, &url.URL{Host: "andrewhowden.com"}))
+	assert.Nil(t, db.Close())
+
+	db, err = New(p)
+	assert.Nil(t, err)
+
+	to, err := db.Get(context.Background(), &url.URL{Host: "x40"})
+	assert.Nil(t, err)
+	assert.Equal(t, &url.URL{Host: "andrewhowden.com"}, to)
+
+	_, err = db.db.Exec("PRAGMA user_version = 1000")
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+
+	_, err = New(p)
+	assert.ErrorIs(t, err, storage.ErrStorageSetupFailed)
+	assert.ErrorContains(t, err, ErrSchemaTooNew.Error())
+}
+
+// legacy writes a database at the schema version, with the links (from, to and when each was last updated) inserted
+// exactly as supplied.
+func legacy(t *testing.T, p string, version int, links [][3]string) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", p)
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	assert.Nil(t, err)
+
+	for _, m := range migrations[:version] {
+		assert.Nil(t, m(ctx, tx))
+	}
+
+	for _, l := range links {
+		from, err := url.Parse(l[0])
+		assert.Nil(t, err)
+
+		updated, err := time.Parse(time.RFC3339, l[2])
+		assert.Nil(t, err)
+
+		_, err = tx.Exec(
+			"INSERT INTO links (from_url, host, to_url, created, updated) VALUES (?, ?, ?, ?, ?)",
+			l[0], from.Host, l[1], updated.UnixNano(), updated.UnixNano(),
+		)
+		assert.Nil(t, err)
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version))
+	assert.Nil(t, err)
+	assert.Nil(t, tx.Commit())
+	assert.Nil(t, db.Close())
+}
+
+// TestMigrateNormalize validates that keys written before they were normalized are rewritten in their normalized form,
+// and that where several keys collide, the most recently updated link wins.
+func TestMigrateNormalize(t *testing.T) {
+	t.Parallel()
+
+	p := path.Join(t.TempDir(), "denormal.sqlite")
+	legacy(t, p, 4, [][3]string{
+		{"//x40/already", "https://andrewhowden.com/already", "2024-01-01T00:00:00Z"},
+		{"//X40/Upper", "https://andrewhowden.com/upper", "2024-01-01T00:00:00Z"},
+		{"//x40:443/collides", "https://andrewhowden.com/newer", "2024-06-01T00:00:00Z"},
+		{"//X40/collides", "https://andrewhowden.com/older", "2024-03-01T00:00:00Z"},
+		{"//x40/collides", "https://andrewhowden.com/oldest", "2024-01-01T00:00:00Z"},
+	})
+
+	db, err := New(p)
+	assert.Nil(t, err)
+
+	page, err := db.List(context.Background(), storage.ListOptions{Host: "x40"})
+	assert.Nil(t, err)
+
+	keys := []string{}
+	for _, l := range page.Links {
+		keys = append(keys, l.From.String())
+	}
+	assert.Equal(t, []string{"//x40/Upper", "//x40/already", "//x40/collides"}, keys)
+
+	for path, to := range map[string]string{
+		"/Upper":    "https://andrewhowden.com/upper",
+		"/already":  "https://andrewhowden.com/already",
+		"/collides": "https://andrewhowden.com/newer",
+	} {
+		res, err := db.Get(context.Background(), &url.URL{Host: "x40", Path: path})
+		assert.Nil(t, err, path)
+		assert.Equal(t, to, res.String(), path)
+	}
+
+	assert.Nil(t, db.Close())
+}
+
+// TestMigrateTemplates validates that templates written before they were flagged as such are flagged, and that links
+// that are not templates are not.
+func TestMigrateTemplates(t *testing.T) {
+	t.Parallel()
+
+	p := path.Join(t.TempDir(), "unflagged.sqlite")
+	legacy(t, p, 5, [][3]string{
+		{"//x40/gh/%7Brepo%7D", "https://github.com/%7Brepo%7D", "2024-01-01T00:00:00Z"},
+		{"//x40/gh", "https://github.com", "2024-01-01T00:00:00Z"},
+	})
+
+	db, err := New(p)
+	assert.Nil(t, err)
+
+	found, err := db.Templates(context.Background(), "x40")
+	assert.Nil(t, err)
+	assert.Len(t, found, 1)
+	assert.Equal(t, "/gh/{repo}", found[0].From.Path)
+
+	assert.Nil(t, db.Close())
+}
@@ -3,7 +3,11 @@ package storage
 import (
 	"context"
 	"errors"
+	"io"
+	"net/http"
 	"net/url"
+	"slices"
+	"time"
 )
 
 // Err* are common errors that the storage implementations will return.
@@ -14,8 +18,27 @@ var (
 	ErrFailed             = errors.New("storage implementation failed")
 	ErrCorrupt            = errors.New("the data returned by the storage is invalid")
 	ErrUnauthorized       = errors.New("you are not the owner of this record")
+	ErrAlreadyExists      = errors.New("a record already exists at this url")
 )
 
+// DefaultListLimit is the number of entries a Lister returns in a single page, where the caller does not
+// specify otherwise.
+const DefaultListLimit = 100
+
+// RedirectStatuses are the HTTP status codes with which a link is able to redirect.
+var RedirectStatuses = []int{
+	http.StatusMovedPermanently,
+	http.StatusFound,
+	http.StatusTemporaryRedirect,
+	http.StatusPermanentRedirect,
+}
+
+// ValidStatus indicates whether the status code is one with which a link is able to redirect. Zero is also valid, as
+// it indicates the link should use the server default.
+func ValidStatus(code int) bool {
+	return code == 0 || slices.Contains(RedirectStatuses, code)
+}
+
 // CtxKey is a type designed to allow delimiting key/value pairs
 type CtxKey string
 
@@ -33,6 +56,230 @@ type Authenticator interface {
 	Owns(ctx context.Context, u *url.URL) bool
 }
 
+// Creator is an extension to the storage interface that writes a link only if there is not already a link stored at the
+// same address. Where there is, the existing link is left untouched and ErrAlreadyExists is returned. Implementations
+// that do not support metadata store only the destination of the link.
+//
+// Unlike Put, which will happily overwrite an existing link, this allows generating addresses without the risk of
+// (silently) replacing a link that somebody else created.
+type Creator interface {
+	Create(ctx context.Context, l *Link) error
+}
+
+// Deleter is an extension to the storage interface that allows removing a link that has previously been stored.
+//
+// Implementations that track ownership apply the same rules on delete as they do when writing; that is, the agent
+// stored in the context must own the record. Returns ErrNotFound where there is no such link.
+type Deleter interface {
+	Delete(ctx context.Context, u *url.URL) error
+}
+
+// Link is a short link, complete with the metadata that describes it.
+type Link struct {
+	// From is the short link that users visit.
+	From *url.URL
+
+	// To is the destination to which users are sent.
+	To *url.URL
+
+	// Owner is the agent that created the link, if there was one.
+	Owner string
+
+	// Created and Updated are the times at which the link was first and last written. Both are managed by the
+	// storage, and are ignored when writing.
+	Created time.Time
+	Updated time.Time
+
+	// Description is a human readable explanation of the purpose of the link.
+	Description string
+
+	// Tags are free form labels that can be used to group links.
+	Tags []string
+
+	// Status is the HTTP status code with which users are redirected. Zero means the server default.
+	Status int
+
+	// Expires is the time after which the link no longer redirects. Zero means the link never expires.
+	Expires time.Time
+
+	// Prefix marks the link as matching every path beneath its own, as well as its own. See Prefixer.
+	Prefix bool
+
+	// Query is how the query string of the request is forwarded to the destination. Empty means the server default.
+	Query QueryPolicy
+}
+
+// Expired indicates whether the link has expired at the given time.
+func (l *Link) Expired(at time.Time) bool {
+	return !l.Expires.IsZero() && !at.Before(l.Expires)
+}
+
+// LinkStorer is an extension to the storage interface that reads and writes links complete with their metadata,
+// rather than only their destination.
+//
+// Writing a link replaces it entirely, with the exception of the time it was created. Where the context has an agent,
+// the agent is recorded as the owner of the link; otherwise, the owner supplied on the link is used.
+type LinkStorer interface {
+	GetLink(ctx context.Context, u *url.URL) (*Link, error)
+	PutLink(ctx context.Context, l *Link) error
+}
+
+// GetLink fetches the link from the storage. Where the storage does not support metadata, a link is constructed
+// from the destination alone.
+func GetLink(ctx context.Context, str Storer, u *url.URL) (*Link, error) {
+	if ls, ok := str.(LinkStorer); ok {
+		return ls.GetLink(ctx, u)
+	}
+
+	to, err := str.Get(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Link{From: u, To: to}, nil
+}
+
+// PutLink writes the link to the storage. Where the storage does not support metadata, only the destination is
+// written.
+func PutLink(ctx context.Context, str Storer, l *Link) error {
+	if ls, ok := str.(LinkStorer); ok {
+		return ls.PutLink(ctx, l)
+	}
+
+	return str.Put(ctx, l.From, l.To)
+}
+
+// Stamp copies the link, applying the metadata that is managed by the storage (the owner and timestamps). Accepts
+// the link that is being replaced, if there is one, so that the time of creation can be retained.
+//
+// Intended for use by storage implementations as they write links.
+func Stamp(ctx context.Context, l *Link, existing *Link) *Link {
+	n := *l
+	n.Tags = slices.Clone(l.Tags)
+
+	if agent, ok := ctx.Value(CtxKeyAgent).(string); ok && agent != "" {
+		n.Owner = agent
+	}
+
+	n.Updated = time.Now()
+	n.Created = n.Updated
+
+	if existing != nil {
+		n.Created = existing.Created
+	}
+
+	return &n
+}
+
+// Authorize validates whether the agent in the context is able to replace (or remove) the existing link. Only the agent
+// that owns the link is able to; links without an owner are only able to be replaced by those without an agent. Returns
+// ErrUnauthorized otherwise.
+//
+// Intended for use by storage implementations as they write links, in the same transaction as the existing link is
+// read.
+func Authorize(ctx context.Context, existing *Link) error {
+	if existing == nil {
+		return nil
+	}
+
+	agent, _ := ctx.Value(CtxKeyAgent).(string)
+	if existing.Owner != agent {
+		return ErrUnauthorized
+	}
+
+	return nil
+}
+
+// ListOptions narrows down (and pages through) the links returned by a Lister.
+type ListOptions struct {
+	// Host limits the results to links on the given host. If empty, links on all hosts are returned.
+	Host string
+
+	// Owner limits the results to links owned by the given agent. If empty, links of all owners are returned.
+	Owner string
+
+	// Cursor is the position from which to continue listing, as returned by Page.Next. If empty, listing starts from
+	// the beginning.
+	Cursor string
+
+	// Limit is the maximum number of entries in a single page. If zero (or less), DefaultListLimit is used.
+	Limit int
+}
+
+// Size returns the number of entries that should be returned in a single page.
+func (o ListOptions) Size() int {
+	if o.Limit <= 0 {
+		return DefaultListLimit
+	}
+
+	return o.Limit
+}
+
+// Matches indicates whether a given link should be included in the results, given the host and owner filters.
+func (o ListOptions) Matches(l *Link) bool {
+	if o.Host != "" && o.Host != l.From.Host {
+		return false
+	}
+
+	if o.Owner != "" && o.Owner != l.Owner {
+		return false
+	}
+
+	return true
+}
+
+// Page is a single set of results returned by a Lister.
+type Page struct {
+	Links []*Link
+
+	// Next is the cursor to supply in ListOptions to fetch the following page. Empty if there are no more results.
+	Next string
+}
+
+// Lister is an extension to the storage interface that allows enumerating the links held by the storage. The results
+// are supplied in pages, with each page returning the cursor from which to fetch the next.
+//
+// Cursors are opaque; they should only be passed back to the implementation from which they were returned.
+type Lister interface {
+	List(ctx context.Context, opts ListOptions) (*Page, error)
+}
+
+// Purger is an extension to the storage interface that physically removes links that have expired. Until they are
+// purged, expired links are retained by the storage (though they should no longer be redirected).
+type Purger interface {
+	// Purge removes all links that expired at or before the given time, returning the number of links removed.
+	Purge(ctx context.Context, before time.Time) (int, error)
+}
+
+// Backuper is an extension to the storage interface that writes a consistent snapshot of the storage, such that it can
+// be restored by replacing the storage with it. Unlike exporting the links, the snapshot is in the native format of
+// the storage, and is taken while the storage remains available for both reads and writes.
+type Backuper interface {
+	// Backup writes the snapshot to w, returning the number of bytes written.
+	Backup(w io.Writer) (int64, error)
+}
+
+// BackupChecker is an extension to the storage interface for storage that wraps other storage (such as a cache), and
+// so implements Backuper whether or not the storage it wraps is able to back up. Reports whether it is.
+type BackupChecker interface {
+	CanBackup() bool
+}
+
+// AsBackuper returns the storage as a Backuper, where it is able to write a snapshot. Storage that wraps other storage
+// is only able to where the storage it wraps is (see BackupChecker).
+func AsBackuper(str Storer) (Backuper, bool) {
+	b, ok := str.(Backuper)
+	if !ok {
+		return nil, false
+	}
+
+	if c, ok := str.(BackupChecker); ok && !c.CanBackup() {
+		return nil, false
+	}
+
+	return b, true
+}
+
 // Storer is the interface that retrieves links supplied to it. Methods are named after the RESTful HTTP
 // verbs, as the meanings are semantically similar.
 type Storer interface {
@@ -3,18 +3,23 @@ package storage_test
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"log"
 	"net"
 	"net/url"
+	"os"
 	"os/exec"
+	"path"
+	"strconv"
 	"sync"
 	"syscall"
 	"testing"
 	"time"
 
-	"cloud.google.com/go/firestore"
 	"github.com/andrewhowdencom/x40.link/storage"
 	storer "github.com/andrewhowdencom/x40.link/storage/firestore"
+	"github.com/andrewhowdencom/x40.link/storage/postgres"
+	"github.com/andrewhowdencom/x40.link/storage/storagetest"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/api/option"
 	"google.golang.org/grpc"
@@ -32,6 +37,16 @@ var pids = struct {
 // Factories to generate valid storage engines
 var externalSinkFactories = map[string]func(string) storage.Storer{
 	"firestore": func(s string) storage.Storer {
+		// Use the emulator that is already running, where there is one.
+		if os.Getenv(storer.EnvEmulatorHost) != "" {
+			str, err := storer.New(context.Background(), "")
+			if err != nil {
+				panic(err)
+			}
+
+			return str
+		}
+
 		// Start the firebase emulator
 		cmd := exec.Command(
 			"gcloud",
@@ -92,19 +107,78 @@ var externalSinkFactories = map[string]func(string) storage.Storer{
 
 		pids.m[s] = cmd
 
-		conn, err := grpc.NewClient("localhost:8500", grpc.WithTransportCredentials(insecure.NewCredentials()))
+		// Connect the client in the same way as it would be connected to any other emulator.
+		str, err := storer.New(
+			context.Background(),
+			"andrewhowdencom",
+			option.WithGRPCConn(emulatorConn("localhost:8500")),
+		)
 		if err != nil {
 			panic(err)
 		}
 
-		client, err := firestore.NewClient(context.Background(), "andrewhowdencom", option.WithGRPCConn(conn))
+		return str
+	},
+	"postgres": func(s string) storage.Storer {
+		// Create a throwaway cluster, trusting all local connections.
+		dir := postgresDir(s)
+		if err := os.RemoveAll(dir); err != nil {
+			panic(err)
+		}
+
+		if out, err := exec.Command("initdb", "-D", dir, "--username=postgres", "--auth=trust", "--no-sync").CombinedOutput(); err != nil {
+			log.Println(string(out))
+			panic(err)
+		}
+
+		// Find a free port on which to listen.
+		l, err := net.Listen("tcp", "127.0.0.1:0")
 		if err != nil {
 			panic(err)
 		}
 
-		// Bootstrap the client
-		return &storer.Firestore{
-			Client: client,
+		port := l.Addr().(*net.TCPAddr).Port
+		if err := l.Close(); err != nil {
+			panic(err)
+		}
+
+		cmd := exec.Command(
+			"postgres",
+			"-D", dir,
+			"-p", strconv.Itoa(port),
+			"-k", dir,
+			"-c", "listen_addresses=127.0.0.1",
+			"-c", "fsync=off",
+		)
+
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		cmd.Stdout = &bytes.Buffer{}
+		cmd.Stderr = &bytes.Buffer{}
+
+		if err := cmd.Start(); err != nil {
+			panic(err)
+		}
+
+		pids.mu.Lock()
+		pids.m["postgres+"+s] = cmd
+		pids.mu.Unlock()
+
+		// Wait for postgres to accept connections.
+		dsn := fmt.Sprintf("postgres://postgres@127.0.0.1:%d/postgres?sslmode=disable", port)
+		deadline := time.Now().Add(time.Second * 30)
+
+		for {
+			db, err := postgres.New(context.Background(), dsn)
+			if err == nil {
+				return db
+			}
+
+			if time.Now().After(deadline) {
+				log.Println(cmd.Stderr.(*bytes.Buffer).String())
+				panic(err)
+			}
+
+			time.Sleep(time.Millisecond * 100)
 		}
 	},
 }
@@ -112,10 +186,64 @@ var externalSinkFactories = map[string]func(string) storage.Storer{
 // Factories to tear down valid storage engines
 var externalSinkTeardown = map[string]func(string){
 	"firestore": func(s string) {
+		// The emulator was not started by the tests, so is left running.
+		if pids.m[s] == nil {
+			return
+		}
+
 		if err := syscall.Kill(-pids.m[s].Process.Pid, syscall.SIGINT); err != nil {
 			panic(err)
 		}
 	},
+	"postgres": func(s string) {
+		cmd := pids.m["postgres+"+s]
+		if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGINT); err != nil {
+			panic(err)
+		}
+
+		// Error ignored, as the process exits non-zero when interrupted.
+		_ = cmd.Wait()
+
+		if err := os.RemoveAll(postgresDir(s)); err != nil {
+			panic(err)
+		}
+	},
+}
+
+// externalSinkBinaries are the binaries required to launch a storage engine, where the tests for that storage should
+// be skipped (rather than failed) when they are not installed.
+var externalSinkBinaries = map[string][]string{
+	"firestore": {"gcloud"},
+	"postgres":  {"initdb", "postgres"},
+}
+
+// skipUnavailable skips the test if the binaries required to launch the storage engine are not installed. Firestore
+// does not need launching where the emulator is already running.
+func skipUnavailable(t *testing.T, n string) {
+	if n == "firestore" && os.Getenv(storer.EnvEmulatorHost) != "" {
+		return
+	}
+
+	for _, b := range externalSinkBinaries[n] {
+		if _, err := exec.LookPath(b); err != nil {
+			t.Skipf("%s is not installed: %s", b, err)
+		}
+	}
+}
+
+// emulatorConn connects to the firestore emulator at the given address.
+func emulatorConn(addr string) *grpc.ClientConn {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		panic(err)
+	}
+
+	return conn
+}
+
+// postgresDir is the directory in which the postgres cluster for a given test is created.
+func postgresDir(s string) string {
+	return path.Join(os.TempDir(), "test+external+"+s+"+postgres")
 }
 
 // TestComplianceAll tests that the storages actually store and retrieve valid records in the (simplest) expected ways.
@@ -125,6 +253,8 @@ func TestComplianceExternalAll(t *testing.T) {
 		n := n
 
 		t.Run(n, func(t *testing.T) {
+			skipUnavailable(t, n)
+
 			str := f("compliance")
 			defer externalSinkTeardown[n]("compliance")
 
@@ -144,54 +274,50 @@ func TestComplianceExternalAll(t *testing.T) {
 			assert.Equal(t, &url.URL{
 				Host: "andrewhowden.com",
 			}, res)
-		})
-	}
-}
 
-func TestOwnership(t *testing.T) {
-	for _, k := range []string{
-		"firestore",
-	} {
-		k := k
-		t.Run(k, func(t *testing.T) {
-			str := externalSinkFactories[k]("ownership")
-			defer externalSinkTeardown[k]("ownership")
+			// Refuse to create a record over the top of an existing one
+			creator, isCreator := str.(storage.Creator)
+			assert.Truef(t, isCreator, "supplied storer does not create")
 
-			auth, isAuthenticator := str.(storage.Authenticator)
+			assert.ErrorIs(t,
+				creator.Create(context.Background(), &storage.Link{From: &url.URL{Host: "x40"}, To: &url.URL{Host: "k3s"}}),
+				storage.ErrAlreadyExists,
+			)
 
-			assert.Truef(t, isAuthenticator, "supplied storer does not authenticate")
+			// Enumerate the records, across both hosts and paths.
+			assert.Nil(t, str.Put(context.Background(), &url.URL{Host: "x40", Path: "/a"}, &url.URL{Host: "k3s"}))
 
-			// Write the context into the store
-			ownerCtx := context.WithValue(context.Background(), storage.CtxKeyAgent, "email:user1@example.com")
-			thiefCtx := context.WithValue(context.Background(), storage.CtxKeyAgent, "email:user2@example.com")
+			lister, isLister := str.(storage.Lister)
+			assert.Truef(t, isLister, "supplied storer does not list")
 
-			// Write a record into the datastore
-			err := str.Put(ownerCtx, &url.URL{Host: "x40"}, &url.URL{Host: "x40"})
+			page, err := lister.List(context.Background(), storage.ListOptions{Limit: 1})
 			assert.Nil(t, err)
+			assert.Len(t, page.Links, 1)
+			assert.Equal(t, &url.URL{Host: "x40"}, page.Links[0].From)
 
-			assert.True(t, auth.Owns(ownerCtx, &url.URL{Host: "x40"}))
-			assert.False(t, auth.Owns(thiefCtx, &url.URL{Host: "x40"}))
+			page, err = lister.List(context.Background(), storage.ListOptions{Limit: 1, Cursor: page.Next})
+			assert.Nil(t, err)
+			assert.Len(t, page.Links, 1)
+			assert.Equal(t, &url.URL{Host: "x40", Path: "/a"}, page.Links[0].From)
+			assert.Empty(t, page.Next)
+		})
+	}
+}
 
-			// Try and update the record as the thief
-			assert.ErrorIs(
-				t,
-				str.Put(thiefCtx, &url.URL{Host: "x40"}, &url.URL{Host: "x40"}),
-				storage.ErrUnauthorized,
-			)
+// TestConformanceExternalAll runs the conformance suite against the external implementations. Each is launched
+// once, and shared by the tests in the suite.
+func TestConformanceExternalAll(t *testing.T) {
+	for n, f := range externalSinkFactories {
+		f := f
+		n := n
 
-			// Try and update the error as the user
-			assert.Nil(t, str.Put(ownerCtx, &url.URL{Host: "x40"}, &url.URL{Host: "40x"}))
+		t.Run(n, func(t *testing.T) {
+			skipUnavailable(t, n)
 
-			// Allow all users to read URLs
-			to, err := str.Get(thiefCtx, &url.URL{Host: "x40"})
-			assert.Nil(t, err)
-			assert.Equal(t, to.String(), (&url.URL{Host: "40x"}).String())
+			str := f("conformance")
+			t.Cleanup(func() { externalSinkTeardown[n]("conformance") })
 
-			// Do not allow anonymous users to update the record
-			assert.ErrorIs(t,
-				str.Put(context.Background(), &url.URL{Host: "x40"}, &url.URL{Host: "x40"}),
-				storage.ErrUnauthorized,
-			)
+			storagetest.RunConformance(t, func(*testing.T) storage.Storer { return str })
 		})
 	}
 }
@@ -9,11 +9,19 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/andrewhowdencom/x40.link/storage"
 	"github.com/andrewhowdencom/x40.link/storage/boltdb"
+	"github.com/andrewhowdencom/x40.link/storage/cache"
+	"github.com/andrewhowdencom/x40.link/storage/chain"
 	"github.com/andrewhowdencom/x40.link/storage/memory"
+	"github.com/andrewhowdencom/x40.link/storage/sqlite"
+	"github.com/andrewhowdencom/x40.link/storage/storagetest"
+	"github.com/andrewhowdencom/x40.link/storage/yaml"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -26,6 +34,24 @@ var sinkFactories = map[string]func(string) storage.Storer{
 	"hash table":    func(string) storage.Storer { return memory.NewHashTable() },
 	"linear search": func(string) storage.Storer { return memory.NewLinearSearch() },
 	"binary search": func(string) storage.Storer { return memory.NewBinarySearch() },
+	"radix trie":    func(string) storage.Storer { return memory.NewRadixTrie() },
+	"cache": func(string) storage.Storer {
+		c, err := cache.New(memory.NewHashTable())
+		if err != nil {
+			panic(err)
+		}
+
+		return c
+	},
+	"chain": func(string) storage.Storer {
+		// An empty read-only layer in front of the writable one, so the writes must fall through to it.
+		y, err := yaml.New(memory.NewHashTable(), strings.NewReader("[]"))
+		if err != nil {
+			panic(err)
+		}
+
+		return chain.New(y, memory.NewHashTable())
+	},
 	"boltdb": func(n string) storage.Storer {
 		db, err := boltdb.New(path.Join(os.TempDir(), "test+"+n+"+url-shortner.db"))
 		if err != nil {
@@ -34,6 +60,26 @@ var sinkFactories = map[string]func(string) storage.Storer{
 
 		return db
 	},
+	"sqlite": func(n string) storage.Storer {
+		db, err := sqlite.New(path.Join(os.TempDir(), "test+"+n+"+url-shortner.sqlite"))
+		if err != nil {
+			panic(err)
+		}
+
+		return db
+	},
+}
+
+// The storage engines that do not stop an operation when its context is cancelled; either they hold the links in
+// memory, or (as with BoltDB) the operation is not able to be interrupted once started.
+var ignoresContext = map[string]bool{
+	"hash table":    true,
+	"linear search": true,
+	"binary search": true,
+	"radix trie":    true,
+	"cache":         true,
+	"chain":         true,
+	"boltdb":        true,
 }
 
 // Factories to tear down valid storage engines
@@ -41,11 +87,24 @@ var teardownFunc = map[string]func(string){
 	"hash table":    func(string) {},
 	"linear search": func(string) {},
 	"binary search": func(string) {},
+	"radix trie":    func(string) {},
+	"cache":         func(string) {},
+	"chain":         func(string) {},
 	"boltdb": func(n string) {
 		if err := os.Remove(path.Join(os.TempDir(), "test+"+n+"+url-shortner.db")); err != nil {
 			panic(err)
 		}
 	},
+	"sqlite": removeSQLite,
+}
+
+// removeSQLite removes the SQLite database, as well as the write-ahead log files that accompany it.
+func removeSQLite(n string) {
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if err := os.Remove(path.Join(os.TempDir(), "test+"+n+"+url-shortner.sqlite"+suffix)); err != nil && !os.IsNotExist(err) {
+			panic(err)
+		}
+	}
 }
 
 // benchmark is a generic approach to benchmarking the various different storage implementations at different underlying data
@@ -96,9 +155,17 @@ func benchmark(b *testing.B, str storage.Storer, iter int) {
 
 // race is designed to stress the storage by using it concurrently, such that the go race detector can
 // figure out if variables are being shared across the stack.
+//
+// Waits for all operations to complete, so the storage is not torn down while it is still in use.
 func race(str storage.Storer) {
+	wg := sync.WaitGroup{}
+
 	for i := 0; i < 1000; i++ {
+		wg.Add(1)
+
 		go func() {
+			defer wg.Done()
+
 			// If the number is divisible by 4 (which it should be, 25% of the time) then make it a write operation.
 			if rand.Int()%4 == 0 {
 				if err := str.Put(context.Background(), &url.URL{
@@ -117,6 +184,8 @@ func race(str storage.Storer) {
 			}
 		}()
 	}
+
+	wg.Wait()
 }
 
 // BenchmarkAll benchmarkes all storage implementations (supplied by the sinkFactories variable)
@@ -127,7 +196,9 @@ func BenchmarkAll(b *testing.B) {
 		"hash table":    {10, 100, 1000, 100000},
 		"linear search": {10, 100, 1000},
 		"binary search": {10, 100, 1000},
+		"radix trie":    {10, 100, 1000, 100000},
 		"boltdb":        {10, 100, 1000},
+		"sqlite":        {10, 100, 1000},
 	}
 
 	for n, f := range sinkFactories {
@@ -191,3 +262,334 @@ func TestComplianceAll(t *testing.T) {
 		})
 	}
 }
+
+// TestConformanceAll runs the conformance suite against all implementations.
+func TestConformanceAll(t *testing.T) {
+	for n, f := range sinkFactories {
+		f := f
+		n := n
+
+		t.Run(n, func(t *testing.T) {
+			t.Parallel()
+
+			str := f("conformance")
+			t.Cleanup(func() { teardownFunc[n]("conformance") })
+
+			opts := []storagetest.Option{}
+			if ignoresContext[n] {
+				opts = append(opts, storagetest.IgnoresContext())
+			}
+
+			storagetest.RunConformance(t, func(*testing.T) storage.Storer { return str }, opts...)
+		})
+	}
+}
+
+// TestDeleteAll validates that the storages are able to remove a record that has been previously written, and that
+// deleting a record that does not exist is reported as such.
+func TestDeleteAll(t *testing.T) {
+	for n, f := range sinkFactories {
+		f := f
+		n := n
+
+		t.Run(n, func(t *testing.T) {
+			t.Parallel()
+
+			str := f("delete")
+			defer teardownFunc[n]("delete")
+
+			del, isDeleter := str.(storage.Deleter)
+			assert.Truef(t, isDeleter, "supplied storer does not delete")
+
+			// Delete a record that does not exist.
+			assert.ErrorIs(t, del.Delete(context.Background(), &url.URL{Host: "x40"}), storage.ErrNotFound)
+
+			// Insert, delete and query a record.
+			assert.Nil(t, str.Put(context.Background(), &url.URL{Host: "x40"}, &url.URL{Host: "andrewhowden.com"}))
+			assert.Nil(t, str.Put(context.Background(), &url.URL{Host: "x40", Path: "/a"}, &url.URL{Host: "k3s"}))
+			assert.Nil(t, del.Delete(context.Background(), &url.URL{Host: "x40"}))
+
+			_, err := str.Get(context.Background(), &url.URL{Host: "x40"})
+			assert.ErrorIs(t, err, storage.ErrNotFound)
+
+			// Other records are left untouched
+			res, err := str.Get(context.Background(), &url.URL{Host: "x40", Path: "/a"})
+			assert.Nil(t, err)
+			assert.Equal(t, &url.URL{Host: "k3s"}, res)
+		})
+	}
+}
+
+// TestListAll validates that the storages that are able to enumerate their links return all of them, in order,
+// across multiple pages.
+func TestListAll(t *testing.T) {
+	for n, f := range sinkFactories {
+		f := f
+		n := n
+
+		t.Run(n, func(t *testing.T) {
+			t.Parallel()
+
+			str := f("list")
+			defer teardownFunc[n]("list")
+
+			lister, isLister := str.(storage.Lister)
+			if !isLister {
+				t.Skip("supplied storer does not list")
+			}
+
+			// Listing an empty storage should not fail
+			page, err := lister.List(context.Background(), storage.ListOptions{})
+			assert.Nil(t, err)
+			assert.Empty(t, page.Links)
+			assert.Empty(t, page.Next)
+
+			for _, u := range []*url.URL{
+				{Host: "x40", Path: "/c"},
+				{Host: "k3s", Path: "/a"},
+				{Host: "x40", Path: "/a"},
+				{Host: "x40"},
+				{Host: "x40", Path: "/b"},
+			} {
+				assert.Nil(t, str.Put(context.Background(), u, &url.URL{Host: "andrewhowden.com"}))
+			}
+
+			for _, tc := range []struct {
+				name     string
+				opts     storage.ListOptions
+				expected []string
+			}{
+				{
+					name:     "all hosts",
+					opts:     storage.ListOptions{Limit: 2},
+					expected: []string{"//k3s/a", "//x40", "//x40/a", "//x40/b", "//x40/c"},
+				},
+				{
+					name:     "single host",
+					opts:     storage.ListOptions{Limit: 2, Host: "x40"},
+					expected: []string{"//x40", "//x40/a", "//x40/b", "//x40/c"},
+				},
+				{
+					name:     "single page",
+					opts:     storage.ListOptions{Host: "k3s"},
+					expected: []string{"//k3s/a"},
+				},
+			} {
+				found := []string{}
+				opts := tc.opts
+
+				for {
+					page, err := lister.List(context.Background(), opts)
+					assert.Nil(t, err)
+					assert.LessOrEqual(t, len(page.Links), opts.Size())
+
+					for _, l := range page.Links {
+						found = append(found, l.From.String())
+					}
+
+					if page.Next == "" {
+						break
+					}
+
+					opts.Cursor = page.Next
+				}
+
+				assert.Equal(t, tc.expected, found, tc.name)
+			}
+		})
+	}
+}
+
+// TestCreateAll validates that the storages will write a record that does not exist, but refuse to overwrite one that
+// does.
+func TestCreateAll(t *testing.T) {
+	for n, f := range sinkFactories {
+		f := f
+		n := n
+
+		t.Run(n, func(t *testing.T) {
+			t.Parallel()
+
+			str := f("create")
+			defer teardownFunc[n]("create")
+
+			creator, isCreator := str.(storage.Creator)
+			assert.Truef(t, isCreator, "supplied storer does not create")
+
+			assert.Nil(t, creator.Create(context.Background(), &storage.Link{
+				From: &url.URL{Host: "x40"},
+				To:   &url.URL{Host: "andrewhowden.com"},
+			}))
+			assert.ErrorIs(t,
+				creator.Create(context.Background(), &storage.Link{From: &url.URL{Host: "x40"}, To: &url.URL{Host: "k3s"}}),
+				storage.ErrAlreadyExists,
+			)
+
+			// The original record is left untouched
+			res, err := str.Get(context.Background(), &url.URL{Host: "x40"})
+			assert.Nil(t, err)
+			assert.Equal(t, &url.URL{Host: "andrewhowden.com"}, res)
+		})
+	}
+}
+
+// TestLinkAll validates that the storages that support metadata store and retrieve it, and that the storages that
+// do not at least store and retrieve the destination.
+func TestLinkAll(t *testing.T) {
+	for n, f := range sinkFactories {
+		f := f
+		n := n
+
+		t.Run(n, func(t *testing.T) {
+			t.Parallel()
+
+			str := f("link")
+			defer teardownFunc[n]("link")
+
+			ctx := context.WithValue(context.Background(), storage.CtxKeyAgent, "email:user1@example.com")
+			in := &storage.Link{
+				From:        &url.URL{Host: "x40", Path: "/foo"},
+				To:          &url.URL{Host: "andrewhowden.com"},
+				Description: "The personal website",
+				Tags:        []string{"personal", "website"},
+				Status:      301,
+				Query:       storage.QueryMerge,
+			}
+
+			assert.Nil(t, storage.PutLink(ctx, str, in))
+
+			out, err := storage.GetLink(ctx, str, in.From)
+			assert.Nil(t, err)
+			assert.Equal(t, in.From, out.From)
+			assert.Equal(t, in.To, out.To)
+
+			if _, ok := str.(storage.LinkStorer); !ok {
+				return
+			}
+
+			assert.Equal(t, in.Description, out.Description)
+			assert.Equal(t, in.Tags, out.Tags)
+			assert.Equal(t, in.Status, out.Status)
+			assert.Equal(t, in.Query, out.Query)
+			assert.Equal(t, "email:user1@example.com", out.Owner)
+			assert.False(t, out.Created.IsZero())
+			assert.Equal(t, out.Created, out.Updated)
+
+			// Replacing the link retains the time at which it was created.
+			assert.Nil(t, storage.PutLink(ctx, str, &storage.Link{From: in.From, To: &url.URL{Host: "k3s"}}))
+
+			updated, err := storage.GetLink(ctx, str, in.From)
+			assert.Nil(t, err)
+			assert.Equal(t, &url.URL{Host: "k3s"}, updated.To)
+			assert.Empty(t, updated.Description)
+			assert.Empty(t, updated.Query)
+			assert.True(t, out.Created.Equal(updated.Created))
+			assert.False(t, updated.Updated.Before(out.Updated))
+		})
+	}
+}
+
+// TestPurgeAll validates that the storages that are able to purge expired links remove those links, and only those
+// links.
+func TestPurgeAll(t *testing.T) {
+	for n, f := range sinkFactories {
+		f := f
+		n := n
+
+		t.Run(n, func(t *testing.T) {
+			t.Parallel()
+
+			str := f("purge")
+			defer teardownFunc[n]("purge")
+
+			purger, isPurger := str.(storage.Purger)
+			if !isPurger {
+				t.Skip("supplied storer does not purge")
+			}
+
+			now := time.Now()
+
+			assert.Nil(t, storage.PutLink(context.Background(), str, &storage.Link{
+				From:    &url.URL{Host: "x40", Path: "/expired"},
+				To:      &url.URL{Host: "andrewhowden.com"},
+				Expires: now.Add(-time.Minute),
+			}))
+			assert.Nil(t, storage.PutLink(context.Background(), str, &storage.Link{
+				From:    &url.URL{Host: "x40", Path: "/expiring"},
+				To:      &url.URL{Host: "andrewhowden.com"},
+				Expires: now.Add(time.Hour),
+			}))
+			assert.Nil(t, str.Put(context.Background(), &url.URL{Host: "x40", Path: "/forever"}, &url.URL{Host: "k3s"}))
+
+			removed, err := purger.Purge(context.Background(), now)
+			assert.Nil(t, err)
+			assert.Equal(t, 1, removed)
+
+			_, err = str.Get(context.Background(), &url.URL{Host: "x40", Path: "/expired"})
+			assert.ErrorIs(t, err, storage.ErrNotFound)
+
+			for _, p := range []string{"/expiring", "/forever"} {
+				_, err = str.Get(context.Background(), &url.URL{Host: "x40", Path: p})
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+// TestReap validates that the reaper purges expired links in the background, and stops when asked.
+func TestReap(t *testing.T) {
+	t.Parallel()
+
+	str := memory.NewHashTable()
+	assert.Nil(t, str.PutLink(context.Background(), &storage.Link{
+		From:    &url.URL{Host: "x40"},
+		To:      &url.URL{Host: "andrewhowden.com"},
+		Expires: time.Now().Add(-time.Minute),
+	}))
+
+	ctx, cxl := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		storage.Reap(ctx, str, time.Millisecond)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		_, err := str.Get(context.Background(), &url.URL{Host: "x40"})
+		return errors.Is(err, storage.ErrNotFound)
+	}, time.Second, time.Millisecond)
+
+	cxl()
+	<-done
+}
+
+func TestAsBackuper(t *testing.T) {
+	t.Parallel()
+
+	db, err := boltdb.New(path.Join(t.TempDir(), "links.db"))
+	assert.Nil(t, err)
+
+	cached := func(str storage.Storer) storage.Storer {
+		c, err := cache.New(str)
+		assert.Nil(t, err)
+
+		return c
+	}
+
+	for _, tc := range []struct {
+		name     string
+		str      storage.Storer
+		expected bool
+	}{
+		{name: "boltdb", str: db, expected: true},
+		{name: "hash table", str: memory.NewHashTable()},
+		{name: "cached boltdb", str: cached(db), expected: true},
+		{name: "cached hash table", str: cached(memory.NewHashTable())},
+		{name: "chain with a cached boltdb", str: chain.New(memory.NewHashTable(), cached(db)), expected: true},
+		{name: "chain without boltdb", str: chain.New(memory.NewHashTable(), cached(memory.NewHashTable()))},
+	} {
+		_, ok := storage.AsBackuper(tc.str)
+		assert.Equal(t, tc.expected, ok, tc.name)
+	}
+}
@@ -0,0 +1,527 @@
+// Package storagetest provides a suite of tests that every storage implementation is expected to pass, so that the
+// implementations are proven to behave the same way rather than each being tested in its own, slightly different way.
+//
+// Implementations run the suite from their own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		str := memory.NewHashTable()
+//		storagetest.RunConformance(t, func(*testing.T) storage.Storer { return str }, storagetest.IgnoresContext())
+//	}
+//
+// Behaviour that is optional (such as tracking ownership) is only tested where the storage implements the interface
+// that indicates it is supported. Storage that does not honour the cancellation of the context says so with an Option.
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/andrewhowdencom/x40.link/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// Factory supplies the storage under test. It is called once for each test in the suite, and may return the same
+// storage each time; each test writes its links only to a host of its own, so the tests do not interfere with each
+// other (or with links already in the storage).
+type Factory func(t *testing.T) storage.Storer
+
+// Option modifies the conformance suite, for storage that does not support some behaviour the suite would otherwise
+// expect of it.
+type Option func(*suite)
+
+// suite is the behaviour the conformance suite expects of the storage.
+type suite struct {
+	ignoresContext bool
+}
+
+// IgnoresContext indicates that the storage does not stop an operation when its context is cancelled, such as storage
+// that has nothing to wait on. The tests of cancellation are skipped.
+func IgnoresContext() Option {
+	return func(s *suite) {
+		s.ignoresContext = true
+	}
+}
+
+// RunConformance runs the conformance suite against the storage supplied by the factory. The tests are run in
+// parallel.
+func RunConformance(t *testing.T, factory Factory, opts ...Option) {
+	s := &suite{}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	for _, tc := range []struct {
+		name string
+		fn   func(t *testing.T, str storage.Storer, host string)
+
+		// ctx indicates the test requires the storage to honour the cancellation of the context.
+		ctx bool
+	}{
+		{name: "round trip", fn: roundTrip},
+		{name: "not found", fn: notFound},
+		{name: "host and path", fn: hostAndPath},
+		{name: "normalisation", fn: normalisation},
+		{name: "ownership", fn: ownership},
+		{name: "prefix", fn: prefix},
+		{name: "template", fn: template},
+		{name: "folding", fn: folding},
+		{name: "concurrency", fn: concurrency},
+		{name: "cancellation", fn: cancellation, ctx: true},
+	} {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if tc.ctx && s.ignoresContext {
+				t.Skip("storage does not honour the cancellation of the context")
+			}
+
+			tc.fn(t, factory(t), host(t))
+		})
+	}
+}
+
+// host generates a host unique to the test, such that tests sharing a storage do not see each other's links.
+func host(t *testing.T) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '-'
+		}
+	}, strings.ToLower(t.Name())) + ".test"
+}
+
+// roundTrip validates that the destination written to a URL is the destination read back from it, complete with its
+// scheme, query and fragment, and that writing it again replaces it.
+func roundTrip(t *testing.T, str storage.Storer, host string) {
+	from := &url.URL{Host: host, Path: "/foo"}
+	to, _ := url.Parse("https://andrewhowden.com/foo?bar=baz#qux")
+
+	assert.Nil(t, str.Put(context.Background(), from, to))
+
+	res, err := str.Get(context.Background(), from)
+	assert.Nil(t, err)
+	assert.Equal(t, to, res)
+
+	assert.Nil(t, str.Put(context.Background(), from, &url.URL{Host: "k3s"}))
+
+	res, err = str.Get(context.Background(), from)
+	assert.Nil(t, err)
+	assert.Equal(t, &url.URL{Host: "k3s"}, res)
+}
+
+// notFound validates that URLs that have not been written are reported as not found, rather than failing.
+func notFound(t *testing.T, str storage.Storer, host string) {
+	_, err := str.Get(context.Background(), &url.URL{Host: host})
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	// A link on the host does not mean every path on the host is found.
+	assert.Nil(t, str.Put(context.Background(), &url.URL{Host: host, Path: "/foo"}, &url.URL{Host: "k3s"}))
+
+	for _, u := range []*url.URL{
+		{Host: host},
+		{Host: host, Path: "/bar"},
+		{Host: host, Path: "/foo/bar"},
+	} {
+		_, err = str.Get(context.Background(), u)
+		assert.ErrorIsf(t, err, storage.ErrNotFound, "%s", u)
+	}
+}
+
+// hostAndPath validates that a link on the host, and links on paths beneath it, are stored independently.
+func hostAndPath(t *testing.T, str storage.Storer, host string) {
+	links := map[*url.URL]*url.URL{
+		{Host: host}:                        {Host: "k3s", Path: "/"},
+		{Host: host, Path: "/a"}:            {Host: "k3s", Path: "/a"},
+		{Host: host, Path: "/a/b"}:          {Host: "k3s", Path: "/a/b"},
+		{Host: "other." + host}:             {Host: "k3s", Path: "/other"},
+		{Host: "other." + host, Path: "/a"}: {Host: "k3s", Path: "/other/a"},
+	}
+
+	for from, to := range links {
+		assert.Nil(t, str.Put(context.Background(), from, to))
+	}
+
+	for from, to := range links {
+		res, err := str.Get(context.Background(), from)
+		assert.Nil(t, err)
+		assert.Equalf(t, to, res, "%s", from)
+	}
+}
+
+// normalisation validates that equivalent URLs find the same link, whether they were parsed from a string or
+// constructed, whether or not their path needed to be escaped, and whatever the case, port or encoding of their host.
+func normalisation(t *testing.T, str storage.Storer, host string) {
+	for _, tc := range []struct {
+		written *url.URL
+		read    string
+	}{
+		{written: &url.URL{Host: host, Path: "/foo"}, read: "//" + host + "/foo"},
+		{written: &url.URL{Host: host, Path: "/foo bar"}, read: "//" + host + "/foo%20bar"},
+		{written: &url.URL{Host: host, Path: "/föö"}, read: "//" + host + "/f%C3%B6%C3%B6"},
+		{written: &url.URL{Host: strings.ToUpper(host), Path: "/case"}, read: "//" + host + "/case"},
+		{written: &url.URL{Host: host + ":443", Path: "/port"}, read: "https://" + host + "/port?utm_source=mail"},
+		{written: &url.URL{Host: "bücher." + host, Path: "/idna"}, read: "//xn--bcher-kva." + host + "/idna"},
+	} {
+		to := &url.URL{Host: "k3s", Path: tc.written.Path}
+		assert.Nil(t, str.Put(context.Background(), tc.written, to))
+
+		u, err := url.Parse(tc.read)
+		assert.Nil(t, err)
+
+		res, err := str.Get(context.Background(), u)
+		assert.Nilf(t, err, "%s", tc.read)
+		assert.Equalf(t, to, res, "%s", tc.read)
+	}
+}
+
+// ownership validates that the storage enforces the rules of ownership: links are readable by anybody, but are only
+// able to be replaced or removed by the agent that owns them. Skipped for storages that do not track ownership.
+func ownership(t *testing.T, str storage.Storer, host string) {
+	auth, isAuthenticator := str.(storage.Authenticator)
+	if !isAuthenticator {
+		t.Skip("supplied storer does not track ownership")
+	}
+
+	from := &url.URL{Host: host}
+
+	ownerCtx := context.WithValue(context.Background(), storage.CtxKeyAgent, "email:user1@example.com")
+	thiefCtx := context.WithValue(context.Background(), storage.CtxKeyAgent, "email:user2@example.com")
+
+	// Write a record into the datastore
+	assert.Nil(t, str.Put(ownerCtx, from, &url.URL{Host: "x40"}))
+
+	assert.True(t, auth.Owns(ownerCtx, from))
+	assert.False(t, auth.Owns(thiefCtx, from))
+	assert.False(t, auth.Owns(context.Background(), from))
+
+	// Neither somebody else, nor anonymous users, are able to replace the record.
+	assert.ErrorIs(t, str.Put(thiefCtx, from, &url.URL{Host: "evil"}), storage.ErrUnauthorized)
+	assert.ErrorIs(t, str.Put(context.Background(), from, &url.URL{Host: "evil"}), storage.ErrUnauthorized)
+
+	// The owner is
+	assert.Nil(t, str.Put(ownerCtx, from, &url.URL{Host: "40x"}))
+
+	// Anybody is able to read the record
+	to, err := str.Get(thiefCtx, from)
+	assert.Nil(t, err)
+	assert.Equal(t, &url.URL{Host: "40x"}, to)
+
+	// Only the owner is able to delete the record
+	del, isDeleter := str.(storage.Deleter)
+	if !isDeleter {
+		return
+	}
+
+	assert.ErrorIs(t, del.Delete(thiefCtx, from), storage.ErrUnauthorized)
+	assert.Nil(t, del.Delete(ownerCtx, from))
+
+	_, err = str.Get(ownerCtx, from)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+// prefix validates that prefix links are stored as such, and that URLs are found beneath the longest prefix link
+// that they are beneath, but only where they continue from it at a "/". Skipped for storages that do not store links
+// complete with their metadata.
+func prefix(t *testing.T, str storage.Storer, host string) {
+	if _, ok := str.(storage.LinkStorer); !ok {
+		t.Skip("supplied storer does not store links")
+	}
+
+	for _, l := range []*storage.Link{
+		{From: &url.URL{Host: host, Path: "/docs"}, To: &url.URL{Host: "k3s", Path: "/docs"}, Prefix: true},
+		{From: &url.URL{Host: host, Path: "/docs/api"}, To: &url.URL{Host: "k3s", Path: "/api"}, Prefix: true},
+		{From: &url.URL{Host: host, Path: "/docs/api/exact"}, To: &url.URL{Host: "k3s", Path: "/exact"}},
+	} {
+		assert.Nil(t, storage.PutLink(context.Background(), str, l))
+	}
+
+	l, err := storage.GetLink(context.Background(), str, &url.URL{Host: host, Path: "/docs"})
+	assert.Nil(t, err)
+	assert.True(t, l.Prefix)
+
+	for _, tc := range []struct {
+		path     string
+		expected string
+	}{
+		{path: "/docs", expected: "/docs"},
+		{path: "/docs/guide", expected: "/docs"},
+		{path: "/docs/apis", expected: "/docs"},
+		{path: "/docs/api", expected: "/docs/api"},
+		{path: "/docs/api/users/1", expected: "/docs/api"},
+		// Links that are not prefixes do not match what is beneath them.
+		{path: "/docs/api/exact/more", expected: "/docs/api"},
+	} {
+		l, err := storage.GetPrefix(context.Background(), str, &url.URL{Host: host, Path: tc.path})
+		if assert.Nilf(t, err, "%s", tc.path) {
+			assert.Equalf(t, tc.expected, l.From.Path, "%s", tc.path)
+		}
+	}
+
+	for _, p := range []string{"/docsfoo", "/", "/other"} {
+		_, err := storage.GetPrefix(context.Background(), str, &url.URL{Host: host, Path: p})
+		assert.ErrorIsf(t, err, storage.ErrNotFound, "%s", p)
+	}
+}
+
+// folding validates that links are found by URLs that differ from them only by case, while still being stored (and
+// looked up exactly) in the case in which they were written, and that links are not created where they differ from
+// another only by case. Skipped for storages that do not find links without regard to case.
+func folding(t *testing.T, str storage.Storer, host string) {
+	if _, ok := str.(storage.Folder); !ok {
+		t.Skip("supplied storer does not find links without regard to case")
+	}
+
+	from := &url.URL{Host: host, Path: "/AbC1"}
+	assert.Nil(t, str.Put(context.Background(), from, &url.URL{Host: "k3s"}))
+
+	for _, u := range []*url.URL{
+		{Host: host, Path: "/AbC1"},
+		{Host: host, Path: "/abc1"},
+		{Host: strings.ToUpper(host), Path: "/ABC1"},
+	} {
+		l, err := storage.GetFolded(context.Background(), str, u)
+		if assert.Nilf(t, err, "%s", u) {
+			assert.Equalf(t, from.String(), l.From.String(), "%s", u)
+		}
+	}
+
+	// The link is only found exactly in the case in which it was written, and other hosts and paths do not see it.
+	_, err := str.Get(context.Background(), &url.URL{Host: host, Path: "/abc1"})
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	for _, u := range []*url.URL{
+		{Host: "other." + host, Path: "/abc1"},
+		{Host: host, Path: "/abc"},
+		{Host: host, Path: "/abc1/"},
+	} {
+		_, err := storage.GetFolded(context.Background(), str, u)
+		assert.ErrorIsf(t, err, storage.ErrNotFound, "%s", u)
+	}
+
+	// Links are not created where they differ from another only by case, but are on other paths.
+	if c, ok := str.(storage.FoldedCreator); ok {
+		for _, tc := range []struct {
+			path string
+			err  error
+		}{
+			{path: "/abc1", err: storage.ErrCaseCollision},
+			{path: "/AbC1", err: storage.ErrAlreadyExists},
+			{path: "/abc2"},
+		} {
+			err := c.CreateFolded(context.Background(), &storage.Link{
+				From: &url.URL{Host: host, Path: tc.path},
+				To:   &url.URL{Host: "k3s"},
+			})
+			assert.ErrorIsf(t, err, tc.err, "%s", tc.path)
+		}
+
+		_, err = str.Get(context.Background(), &url.URL{Host: host, Path: "/abc1"})
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+	}
+
+	// Links that are removed are no longer found.
+	d, ok := str.(storage.Deleter)
+	if !ok {
+		return
+	}
+
+	assert.Nil(t, d.Delete(context.Background(), from))
+
+	_, err = storage.GetFolded(context.Background(), str, &url.URL{Host: host, Path: "/abc1"})
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	// Every link is found without regard to case, however it was written. Links that differ only by case are able to
+	// be put (as they were before the host was folded), and each is found, and prevents creating another that differs
+	// only by case, until both are removed.
+	variants := []*url.URL{{Host: host, Path: "/XyZ1"}, {Host: host, Path: "/xyz1"}}
+	for _, u := range variants {
+		assert.Nil(t, str.Put(context.Background(), u, &url.URL{Host: "k3s"}))
+	}
+
+	for _, u := range variants {
+		_, err := storage.GetFolded(context.Background(), str, &url.URL{Host: host, Path: "/XYZ1"})
+		assert.Nilf(t, err, "before removing %s", u)
+
+		if c, ok := str.(storage.FoldedCreator); ok {
+			err := c.CreateFolded(context.Background(), &storage.Link{
+				From: &url.URL{Host: host, Path: "/Xyz1"},
+				To:   &url.URL{Host: "k3s"},
+			})
+			assert.ErrorIsf(t, err, storage.ErrCaseCollision, "before removing %s", u)
+		}
+
+		assert.Nil(t, d.Delete(context.Background(), u))
+	}
+
+	_, err = storage.GetFolded(context.Background(), str, &url.URL{Host: host, Path: "/XYZ1"})
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+// template validates that URLs are matched against the link templates on their host, with the captured segments
+// substituted into the destination, that an exact link wins over a template, and that a template that is deleted no
+// longer matches. Skipped for storages that do not support templates.
+func template(t *testing.T, str storage.Storer, host string) {
+	if _, ok := str.(storage.Templater); !ok {
+		t.Skip("supplied storer does not support templates")
+	}
+
+	links := map[string]string{
+		"/gh/{repo}":           "https://github.com/org/{repo}",
+		"/gh/{org}/{repo}":     "https://github.com/{org}/{repo}",
+		"/gh/x40/{repo}":       "https://github.com/x40/{repo}?ref=x40",
+		"/gh/exact":            "https://github.com/exact",
+		"/jira/{id}/{comment}": "https://jira.example.com/browse/{id}#{comment}",
+	}
+
+	for from, to := range links {
+		u, err := url.Parse(to)
+		assert.Nil(t, err)
+		assert.Nil(t, str.Put(context.Background(), &url.URL{Host: host, Path: from}, u))
+	}
+
+	for _, tc := range []struct {
+		path     string
+		expected string
+	}{
+		{path: "/gh/x40.link", expected: "https://github.com/org/x40.link"},
+		{path: "/gh/andrewhowdencom/x40.link", expected: "https://github.com/andrewhowdencom/x40.link"},
+		{path: "/gh/x40/link", expected: "https://github.com/x40/link?ref=x40"},
+		{path: "/jira/X-1/2", expected: "https://jira.example.com/browse/X-1#2"},
+	} {
+		u := &url.URL{Host: host, Path: tc.path}
+
+		l, err := storage.GetTemplate(context.Background(), str, u)
+		if assert.Nilf(t, err, "%s", tc.path) {
+			assert.Equalf(t, tc.expected, l.Destination(u).String(), "%s", tc.path)
+		}
+	}
+
+	// The link at the URL itself is found without the template, and other hosts do not see the templates.
+	res, err := str.Get(context.Background(), &url.URL{Host: host, Path: "/gh/exact"})
+	assert.Nil(t, err)
+	assert.Equal(t, "https://github.com/exact", res.String())
+
+	for _, u := range []*url.URL{
+		{Host: host, Path: "/gh"},
+		{Host: host, Path: "/gh/a/b/c"},
+		{Host: "other." + host, Path: "/gh/x40.link"},
+	} {
+		_, err := storage.GetTemplate(context.Background(), str, u)
+		assert.ErrorIsf(t, err, storage.ErrNotFound, "%s", u)
+	}
+
+	d, ok := str.(storage.Deleter)
+	if !ok {
+		return
+	}
+
+	// Looked up first, so that storage that caches the templates has them cached.
+	u := &url.URL{Host: host, Path: "/jira/X-1/2"}
+	_, err = storage.GetTemplate(context.Background(), str, u)
+	assert.Nil(t, err)
+
+	assert.Nil(t, d.Delete(context.Background(), &url.URL{Host: host, Path: "/jira/{id}/{comment}"}))
+
+	_, err = storage.GetTemplate(context.Background(), str, u)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+// concurrency validates that the storage is safe to read and write from many goroutines at once. Run with the race
+// detector for the most value.
+func concurrency(t *testing.T, str storage.Storer, host string) {
+	const workers, links = 8, 25
+
+	shared := &url.URL{Host: host}
+	written := map[string]bool{}
+	wg := sync.WaitGroup{}
+	mu := sync.Mutex{}
+
+	for w := 0; w < workers; w++ {
+		to := &url.URL{Host: "k3s", Path: fmt.Sprintf("/%d", w)}
+		written[to.String()] = true
+
+		wg.Add(1)
+
+		go func(w int) {
+			defer wg.Done()
+
+			for i := 0; i < links; i++ {
+				from := &url.URL{Host: host, Path: fmt.Sprintf("/%d/%d", w, i)}
+
+				if err := str.Put(context.Background(), from, to); err != nil {
+					mu.Lock()
+					t.Errorf("put %s: %s", from, err)
+					mu.Unlock()
+
+					return
+				}
+
+				// Every goroutine also writes to (and reads from) the same link, which the others are writing.
+				if err := str.Put(context.Background(), shared, to); err != nil {
+					mu.Lock()
+					t.Errorf("put %s: %s", shared, err)
+					mu.Unlock()
+
+					return
+				}
+
+				if _, err := str.Get(context.Background(), shared); err != nil {
+					mu.Lock()
+					t.Errorf("get %s: %s", shared, err)
+					mu.Unlock()
+
+					return
+				}
+			}
+		}(w)
+	}
+
+	wg.Wait()
+
+	// Every link written is readable, and the shared link is one of those written (rather than a mix of them).
+	for w := 0; w < workers; w++ {
+		for i := 0; i < links; i++ {
+			from := &url.URL{Host: host, Path: fmt.Sprintf("/%d/%d", w, i)}
+
+			res, err := str.Get(context.Background(), from)
+			assert.Nil(t, err)
+			assert.Equal(t, &url.URL{Host: "k3s", Path: fmt.Sprintf("/%d", w)}, res)
+		}
+	}
+
+	res, err := str.Get(context.Background(), shared)
+	assert.Nil(t, err)
+	assert.Truef(t, written[res.String()], "%s was not written", res)
+}
+
+// cancellation validates that an operation with a context that is already cancelled fails with the error of the
+// context, rather than reporting the link as absent or writing it anyway.
+func cancellation(t *testing.T, str storage.Storer, host string) {
+	ctx, cxl := context.WithCancel(context.Background())
+	cxl()
+
+	existing := &url.URL{Host: host, Path: "/existing"}
+	assert.Nil(t, str.Put(context.Background(), existing, &url.URL{Host: "k3s"}))
+
+	_, err := str.Get(ctx, existing)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	for _, u := range []*url.URL{existing, {Host: host, Path: "/new"}} {
+		before, beforeErr := str.Get(context.Background(), u)
+
+		assert.ErrorIsf(t, str.Put(ctx, u, &url.URL{Host: "x40"}), context.Canceled, "write to %s", u)
+
+		// The write did not happen.
+		res, err := str.Get(context.Background(), u)
+		assert.Equalf(t, before, res, "cancelled write to %s was written", u)
+		assert.Equalf(t, beforeErr, err, "cancelled write to %s was written", u)
+	}
+}
@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalidTemplate is returned where a link is written with placeholders that are not able to be matched, or
+// substituted.
+var ErrInvalidTemplate = errors.New("invalid link template")
+
+// placeholder matches a placeholder (e.g. {repo}) anywhere in a string. Within the path of the URL of a link, a
+// placeholder must be a whole segment.
+var placeholder = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Templater is an extension to the storage interface that supports link templates, for storage that keeps an index of
+// the templates on each host. Returns the templates on the host, of which none may be found.
+//
+// A link is a template where the path of its URL has one or more segments that are a placeholder, such as
+// //go.example/gh/{repo}. A URL matches the template where it is on the same host, has the same number of segments
+// and each segment that is not a placeholder is the same. Where a URL matches more than one template, the one with a
+// segment that is not a placeholder where the others have a placeholder, furthest left, wins.
+type Templater interface {
+	Templates(ctx context.Context, host string) ([]*Link, error)
+}
+
+// Templates returns the link templates on the host. Where the storage is not a Templater, templates are not supported,
+// so there are none.
+func Templates(ctx context.Context, str Storer, host string) ([]*Link, error) {
+	t, ok := str.(Templater)
+	if !ok {
+		return nil, nil
+	}
+
+	return t.Templates(ctx, host)
+}
+
+// GetTemplate finds the link template that the URL matches, from amongst the templates on its host (see Templates).
+// Returns ErrNotFound where the URL matches no template.
+func GetTemplate(ctx context.Context, str Storer, u *url.URL) (*Link, error) {
+	u = Normalize(u)
+
+	templates, err := Templates(ctx, str, u.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	var found *Link
+	for _, l := range templates {
+		if _, ok := l.Match(u); ok && (found == nil || l.moreSpecific(found)) {
+			found = l
+		}
+	}
+
+	if found == nil {
+		return nil, ErrNotFound
+	}
+
+	return found, nil
+}
+
+// IsTemplate indicates whether the path of the URL of the link has any placeholders.
+func (l *Link) IsTemplate() bool {
+	return placeholder.MatchString(l.From.Path)
+}
+
+// Match indicates whether the URL matches this link, where this link is a template, along with the segments of the
+// URL captured by each placeholder. See Templater.
+func (l *Link) Match(u *url.URL) (map[string]string, bool) {
+	if !l.IsTemplate() || l.From.Host != u.Host {
+		return nil, false
+	}
+
+	want := strings.Split(l.From.Path, "/")
+	got := strings.Split(u.Path, "/")
+
+	if len(want) != len(got) {
+		return nil, false
+	}
+
+	captured := map[string]string{}
+
+	for i, seg := range want {
+		if name, ok := variable(seg); ok && got[i] != "" {
+			captured[name] = got[i]
+		} else if seg != got[i] {
+			return nil, false
+		}
+	}
+
+	return captured, true
+}
+
+// ValidateTemplate validates that the placeholders of a link are able to be matched and substituted: each is a whole
+// segment of the path of the URL, is not repeated, and each placeholder in the destination is one that the URL
+// captures. Links that are not templates are always valid.
+func ValidateTemplate(l *Link) error {
+	defined := map[string]bool{}
+
+	for _, seg := range strings.Split(l.From.Path, "/") {
+		name, ok := variable(seg)
+		if !ok {
+			if strings.ContainsAny(seg, "{}") {
+				return fmt.Errorf("%w: segment %q must either be a placeholder, or have no braces", ErrInvalidTemplate, seg)
+			}
+
+			continue
+		}
+
+		if defined[name] {
+			return fmt.Errorf("%w: placeholder {%s} is used more than once", ErrInvalidTemplate, name)
+		}
+
+		defined[name] = true
+	}
+
+	if len(defined) == 0 {
+		return nil
+	}
+
+	if l.Prefix {
+		return fmt.Errorf("%w: a template is not able to also be a prefix", ErrInvalidTemplate)
+	}
+
+	for _, s := range []string{l.To.Path, l.To.RawQuery, l.To.Fragment} {
+		for _, m := range placeholder.FindAllStringSubmatch(s, -1) {
+			if !defined[m[1]] {
+				return fmt.Errorf("%w: destination uses {%s}, which the url does not capture", ErrInvalidTemplate, m[1])
+			}
+		}
+	}
+
+	return nil
+}
+
+// expand returns a copy of the destination of the link with each placeholder replaced by the value captured for it.
+// Values are escaped as appropriate to the part of the destination in which they are placed.
+func (l *Link) expand(captured map[string]string) *url.URL {
+	replace := func(s string, escape func(string) string) string {
+		return placeholder.ReplaceAllStringFunc(s, func(m string) string {
+			v, ok := captured[m[1:len(m)-1]]
+			if !ok {
+				return m
+			}
+
+			return escape(v)
+		})
+	}
+
+	raw := func(s string) string { return s }
+
+	n := *l.To
+	n.Path = replace(l.To.Path, raw)
+	n.RawPath = ""
+	n.RawQuery = replace(l.To.RawQuery, url.QueryEscape)
+	n.Fragment = replace(l.To.Fragment, raw)
+	n.RawFragment = ""
+
+	return &n
+}
+
+// moreSpecific indicates whether this template wins over the other, where a URL matches both. See Templater.
+func (l *Link) moreSpecific(other *Link) bool {
+	mine := strings.Split(l.From.Path, "/")
+	theirs := strings.Split(other.From.Path, "/")
+
+	for i := range mine {
+		_, a := variable(mine[i])
+		_, b := variable(theirs[i])
+
+		if a != b {
+			return !a
+		}
+	}
+
+	return false
+}
+
+// variable returns the name of the placeholder that a segment of a path is, if it is one.
+func variable(seg string) (string, bool) {
+	m := placeholder.FindStringSubmatch(seg)
+	if m == nil || m[0] != seg {
+		return "", false
+	}
+
+	return m[1], true
+}
@@ -0,0 +1,119 @@
+package storage_test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/andrewhowdencom/x40.link/storage"
+	"github.com/andrewhowdencom/x40.link/storage/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	t.Parallel()
+
+	l := &storage.Link{From: &url.URL{Host: "x40", Path: "/gh/{org}/{repo}"}}
+
+	for _, tc := range []struct {
+		in       *url.URL
+		captured map[string]string
+		ok       bool
+	}{
+		{in: &url.URL{Host: "x40", Path: "/gh/andrewhowdencom/x40.link"}, captured: map[string]string{"org": "andrewhowdencom", "repo": "x40.link"}, ok: true},
+		{in: &url.URL{Host: "x40", Path: "/gh/andrewhowdencom"}},
+		{in: &url.URL{Host: "x40", Path: "/gh/andrewhowdencom/x40.link/issues"}},
+		{in: &url.URL{Host: "x40", Path: "/gh/andrewhowdencom/"}},
+		{in: &url.URL{Host: "x40", Path: "/gl/andrewhowdencom/x40.link"}},
+		{in: &url.URL{Host: "k3s", Path: "/gh/andrewhowdencom/x40.link"}},
+	} {
+		captured, ok := l.Match(tc.in)
+		assert.Equal(t, tc.ok, ok, tc.in.String())
+		assert.Equal(t, tc.captured, captured, tc.in.String())
+	}
+
+	// Links that are not templates match nothing, not even their own URL.
+	exact := &storage.Link{From: &url.URL{Host: "x40", Path: "/gh"}}
+	_, ok := exact.Match(&url.URL{Host: "x40", Path: "/gh"})
+	assert.False(t, ok)
+}
+
+func TestValidateTemplate(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name  string
+		from  string
+		to    string
+		pfx   bool
+		valid bool
+	}{
+		{name: "not a template", from: "/gh", to: "https://github.com/{repo}", valid: true},
+		{name: "path", from: "/gh/{repo}", to: "https://github.com/org/{repo}", valid: true},
+		{name: "query and fragment", from: "/jira/{id}", to: "https://jira.example.com/browse?id={id}#{id}", valid: true},
+		{name: "capture unused", from: "/gh/{org}/{repo}", to: "https://github.com/{repo}", valid: true},
+		{name: "part of a segment", from: "/gh/repo-{repo}", to: "https://github.com/{repo}"},
+		{name: "unbalanced", from: "/gh/{repo", to: "https://github.com/"},
+		{name: "repeated", from: "/gh/{repo}/{repo}", to: "https://github.com/{repo}"},
+		{name: "not captured", from: "/gh/{repo}", to: "https://github.com/{org}/{repo}"},
+		{name: "prefix", from: "/gh/{repo}", to: "https://github.com/{repo}", pfx: true},
+	} {
+		to, err := url.Parse(tc.to)
+		assert.Nil(t, err)
+
+		err = storage.ValidateTemplate(&storage.Link{From: &url.URL{Host: "x40", Path: tc.from}, To: to, Prefix: tc.pfx})
+		if tc.valid {
+			assert.Nil(t, err, tc.name)
+		} else {
+			assert.ErrorIs(t, err, storage.ErrInvalidTemplate, tc.name)
+		}
+	}
+}
+
+func TestDestinationTemplate(t *testing.T) {
+	t.Parallel()
+
+	to, err := url.Parse("https://jira.example.com/browse/{id}?q={id}#{id}")
+	assert.Nil(t, err)
+
+	l := &storage.Link{From: &url.URL{Host: "x40", Path: "/jira/{id}"}, To: to}
+
+	res := l.Destination(&url.URL{Host: "x40", Path: "/jira/a b&c"})
+	assert.Equal(t, "https://jira.example.com/browse/a%20b&c?q=a+b%26c#a%20b&c", res.String())
+
+	// The destination of the link is not modified.
+	assert.Equal(t, "/browse/{id}", l.To.Path)
+}
+
+// TestGetTemplate validates that, where several templates match, the one that is most specific furthest left wins.
+func TestGetTemplate(t *testing.T) {
+	t.Parallel()
+
+	str := memory.NewHashTable()
+
+	for _, from := range []string{"/gh/{org}/{repo}", "/gh/{org}/x40", "/gh/andrewhowdencom/{repo}", "/{any}/{thing}/{at_all}"} {
+		assert.Nil(t, str.Put(context.Background(), &url.URL{Host: "x40", Path: from}, &url.URL{Host: "k3s"}))
+	}
+
+	for _, tc := range []struct {
+		in       string
+		expected string
+	}{
+		{in: "/gh/andrewhowdencom/x40", expected: "/gh/andrewhowdencom/{repo}"},
+		{in: "/gh/other/x40", expected: "/gh/{org}/x40"},
+		{in: "/gh/other/other", expected: "/gh/{org}/{repo}"},
+		{in: "/gl/other/other", expected: "/{any}/{thing}/{at_all}"},
+	} {
+		l, err := storage.GetTemplate(context.Background(), str, &url.URL{Host: "x40", Path: tc.in})
+		if assert.Nil(t, err, tc.in) {
+			assert.Equal(t, tc.expected, l.From.Path, tc.in)
+		}
+	}
+
+	_, err := storage.GetTemplate(context.Background(), str, &url.URL{Host: "x40", Path: "/gh/other"})
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	// Storage that is not able to list does not support templates.
+	_, err = storage.GetTemplate(context.Background(), memory.NewLinearSearch(), &url.URL{Host: "x40", Path: "/gh/a/b"})
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
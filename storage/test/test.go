@@ -13,7 +13,7 @@ type Option func(t *ts)
 
 // ts is test storage
 type ts struct {
-	r map[string]*url.URL
+	r map[string]*storage.Link
 
 	// error will modify the test structure to return an error for all operations.
 	err error
@@ -23,7 +23,7 @@ type ts struct {
 func New(opts ...Option) *ts {
 
 	n := &ts{
-		r: make(map[string]*url.URL),
+		r: make(map[string]*storage.Link),
 	}
 
 	for _, o := range opts {
@@ -42,7 +42,19 @@ func WithError(err error) Option {
 }
 
 // see storage.Storer
-func (ts *ts) Get(_ context.Context, u *url.URL) (*url.URL, error) {
+func (ts *ts) Get(ctx context.Context, u *url.URL) (*url.URL, error) {
+	l, err := ts.GetLink(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.To, nil
+}
+
+// see storage.LinkStorer
+func (ts *ts) GetLink(_ context.Context, u *url.URL) (*storage.Link, error) {
+	u = storage.Normalize(u)
+
 	if ts.err != nil {
 		return nil, ts.err
 	}
@@ -55,12 +67,51 @@ func (ts *ts) Get(_ context.Context, u *url.URL) (*url.URL, error) {
 }
 
 // see storage.Storer
-func (ts *ts) Put(_ context.Context, f *url.URL, t *url.URL) error {
+func (ts *ts) Put(ctx context.Context, f *url.URL, t *url.URL) error {
+	return ts.PutLink(ctx, &storage.Link{From: f, To: t})
+}
+
+// see storage.LinkStorer
+func (ts *ts) PutLink(ctx context.Context, l *storage.Link) error {
+	l = storage.NormalizeLink(l)
+
 	if ts.err != nil {
 		return ts.err
 	}
 
-	ts.r[f.String()] = t
+	ts.r[l.From.String()] = storage.Stamp(ctx, l, ts.r[l.From.String()])
+	return nil
+}
+
+// see storage.Creator
+func (ts *ts) Create(ctx context.Context, l *storage.Link) error {
+	l = storage.NormalizeLink(l)
+
+	if ts.err != nil {
+		return ts.err
+	}
+
+	if _, ok := ts.r[l.From.String()]; ok {
+		return storage.ErrAlreadyExists
+	}
+
+	ts.r[l.From.String()] = storage.Stamp(ctx, l, nil)
+	return nil
+}
+
+// see storage.Deleter
+func (ts *ts) Delete(_ context.Context, u *url.URL) error {
+	u = storage.Normalize(u)
+
+	if ts.err != nil {
+		return ts.err
+	}
+
+	if _, ok := ts.r[u.String()]; !ok {
+		return storage.ErrNotFound
+	}
+
+	delete(ts.r, u.String())
 	return nil
 }
 
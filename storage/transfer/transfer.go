@@ -0,0 +1,333 @@
+// Package transfer moves links between storage implementations, by way of a stream. Links are exported as JSON lines
+// (that is, one JSON object per line), complete with their owner and metadata:
+//
+//	{"from":"//x40/foo","to":"//x40/bar","owner":"alice","created":"2024-01-01T00:00:00Z",...}
+//
+// Streaming the links means they can be exported from one storage and imported into another without either needing
+// to hold the whole set in memory; for example, piping the export of one process into the import of another. Times
+// of creation and update are exported, but are managed by the storage into which the links are imported.
+package transfer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/andrewhowdencom/x40.link/storage"
+)
+
+// Err* are sentinel errors
+var (
+	ErrConflict      = errors.New("a link already exists at this url")
+	ErrMalformed     = errors.New("the export is malformed")
+	ErrInvalidOption = errors.New("invalid option")
+)
+
+// MaxLineSize is the largest link (that is, line of the export) that is able to be imported, in bytes. It is far larger
+// than any link is expected to be, but bounds the memory an import is able to use on a malformed export.
+const MaxLineSize = 1 << 20
+
+// Policy decides what happens when an imported link is at the same address as one that is already stored.
+type Policy string
+
+// The supported policies.
+const (
+	// Skip leaves the stored link as it is, and moves on to the next.
+	Skip Policy = "skip"
+
+	// Overwrite replaces the stored link with the imported one.
+	Overwrite Policy = "overwrite"
+
+	// Fail stops the import, returning ErrConflict.
+	Fail Policy = "fail"
+)
+
+// ParsePolicy returns the policy with the given name.
+func ParsePolicy(name string) (Policy, error) {
+	switch p := Policy(name); p {
+	case Skip, Overwrite, Fail:
+		return p, nil
+	default:
+		return "", fmt.Errorf("%w: unknown conflict policy %q", ErrInvalidOption, name)
+	}
+}
+
+// record is a single link, as written in the export.
+type record struct {
+	From        string    `json:"from"`
+	To          string    `json:"to"`
+	Owner       string    `json:"owner,omitempty"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+	Description string    `json:"description,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	Status      int       `json:"status,omitempty"`
+	Expires     time.Time `json:"expires"`
+	Prefix      bool      `json:"prefix,omitempty"`
+	Query       string    `json:"query,omitempty"`
+}
+
+// Export writes every link in the storage to w, returning the number of links written. The storage must be a
+// storage.Lister.
+func Export(ctx context.Context, str storage.Storer, w io.Writer) (int, error) {
+	l, ok := str.(storage.Lister)
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", storage.ErrFailed, "storage does not list")
+	}
+
+	enc := json.NewEncoder(w)
+	n := 0
+
+	opts := storage.ListOptions{}
+	for {
+		page, err := l.List(ctx, opts)
+		if err != nil {
+			return n, err
+		}
+
+		for _, link := range page.Links {
+			if err := enc.Encode(&record{
+				From:        link.From.String(),
+				To:          link.To.String(),
+				Owner:       link.Owner,
+				Created:     link.Created,
+				Updated:     link.Updated,
+				Description: link.Description,
+				Tags:        link.Tags,
+				Status:      link.Status,
+				Expires:     link.Expires,
+				Prefix:      link.Prefix,
+				Query:       string(link.Query),
+			}); err != nil {
+				return n, err
+			}
+
+			n++
+		}
+
+		if page.Next == "" {
+			return n, nil
+		}
+
+		opts.Cursor = page.Next
+	}
+}
+
+// Summary describes the outcome of an import.
+type Summary struct {
+	// Read is the number of links read from the export.
+	Read int
+
+	// Created, Overwritten and Skipped are the number of links that were new, replaced a stored link, and left the
+	// stored link as it was. In a dry run, these are the links that would have been.
+	Created     int
+	Overwritten int
+	Skipped     int
+
+	// Failed are the links that could not be written (e.g. as they are owned by somebody else in the storage), each
+	// wrapped with the link to which it applies. The import continues past them.
+	Failed []error
+}
+
+// Option modifies the behaviour of an import
+type Option func(i *importer) error
+
+// importer holds the configuration of an import.
+type importer struct {
+	policy Policy
+	dryRun bool
+	folded storage.FoldedHosts
+}
+
+// WithPolicy sets what happens when an imported link is at the same address as one that is already stored. By default,
+// the stored link is skipped.
+func WithPolicy(p Policy) Option {
+	return func(i *importer) error {
+		if _, err := ParsePolicy(string(p)); err != nil {
+			return err
+		}
+
+		i.policy = p
+
+		return nil
+	}
+}
+
+// WithDryRun reads the links, and works out what would be written, without writing anything.
+func WithDryRun() Option {
+	return func(i *importer) error {
+		i.dryRun = true
+
+		return nil
+	}
+}
+
+// WithFoldedHosts sets the hosts on which paths are matched without regard to case. Links on them are not imported where
+// a link is already stored at a URL that differs from theirs only by case; that is a failure, whatever the conflict
+// policy, as the imported link does not replace the stored one (see storage.FoldedCreator).
+func WithFoldedHosts(hosts storage.FoldedHosts) Option {
+	return func(i *importer) error {
+		i.folded = hosts
+
+		return nil
+	}
+}
+
+// Import reads links from r (as written by Export), and writes them to the storage. Each link is written as its
+// owner, so the usual ownership rules apply; links stored at the same address by somebody else fail, rather than
+// being replaced.
+//
+// Returns the summary of the import so far, even where the import fails part way through.
+func Import(ctx context.Context, str storage.Storer, r io.Reader, opts ...Option) (*Summary, error) {
+	i := &importer{policy: Skip}
+	for _, opt := range opts {
+		if err := opt(i); err != nil {
+			return nil, err
+		}
+	}
+
+	sum := &Summary{Failed: []error{}}
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), MaxLineSize)
+
+	for line := 1; s.Scan(); line++ {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+
+		l, err := decode(s.Bytes())
+		if err != nil {
+			return sum, fmt.Errorf("%w: line %d: %s", ErrMalformed, line, err)
+		}
+
+		sum.Read++
+
+		if err := i.write(ctx, str, l, sum); errors.Is(err, ErrConflict) {
+			return sum, fmt.Errorf("%w: line %d: %s", err, line, l.From)
+		} else if err != nil {
+			sum.Failed = append(sum.Failed, fmt.Errorf("line %d: %s: %w", line, l.From, err))
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return sum, fmt.Errorf("%w: %s", ErrMalformed, err)
+	}
+
+	return sum, nil
+}
+
+// write writes a single link to the storage, following the conflict policy, and records the outcome in the summary.
+func (i *importer) write(ctx context.Context, str storage.Storer, l *storage.Link, sum *Summary) error {
+	if l.Owner != "" {
+		ctx = context.WithValue(ctx, storage.CtxKeyAgent, l.Owner)
+	}
+
+	existing, err := storage.GetLink(ctx, str, l.From)
+
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		if i.dryRun {
+			if err := i.collides(ctx, str, l); err != nil {
+				return err
+			}
+
+			sum.Created++
+			return nil
+		}
+
+		err = storage.Create(ctx, str, i.folded, l)
+		if errors.Is(err, storage.ErrCaseCollision) || !errors.Is(err, storage.ErrAlreadyExists) {
+			if err == nil {
+				sum.Created++
+			}
+
+			return err
+		}
+
+		// The link was written by somebody else since it was checked, so is treated as any other conflict.
+	case err != nil:
+		return err
+	}
+
+	switch i.policy {
+	case Fail:
+		return ErrConflict
+	case Skip:
+		sum.Skipped++
+		return nil
+	}
+
+	if i.dryRun {
+		// Storage that knows who owns its links lets only their owner replace them, so the dry run fails where the
+		// import would.
+		if _, ok := str.(storage.Authenticator); ok {
+			if err := storage.Authorize(ctx, existing); err != nil {
+				return err
+			}
+		}
+	} else if err := storage.PutLink(ctx, str, l); err != nil {
+		return err
+	}
+
+	sum.Overwritten++
+
+	return nil
+}
+
+// collides returns storage.ErrCaseCollision where the link is on one of the folded hosts, and there is already a link
+// stored at a URL that differs from it only by case. Used only in a dry run; otherwise, the check is made as the link
+// is written.
+func (i *importer) collides(ctx context.Context, str storage.Storer, l *storage.Link) error {
+	if !i.folded.Contains(l.From) {
+		return nil
+	}
+
+	if _, err := storage.GetFolded(ctx, str, l.From); err == nil {
+		return storage.ErrCaseCollision
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+// decode reads a single link from a line of the export.
+func decode(data []byte) (*storage.Link, error) {
+	r := &record{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, err
+	}
+
+	from, err := url.Parse(r.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from: %s", err)
+	}
+
+	to, err := url.Parse(r.To)
+	if err != nil {
+		return nil, fmt.Errorf("invalid to: %s", err)
+	}
+
+	if from.Host == "" || r.To == "" {
+		return nil, fmt.Errorf("link requires both from (with a host) and to")
+	}
+
+	return &storage.Link{
+		From:        from,
+		To:          to,
+		Owner:       r.Owner,
+		Created:     r.Created,
+		Updated:     r.Updated,
+		Description: r.Description,
+		Tags:        r.Tags,
+		Status:      r.Status,
+		Expires:     r.Expires,
+		Prefix:      r.Prefix,
+		Query:       storage.QueryPolicy(r.Query),
+	}, nil
+}
@@ -0,0 +1,286 @@
+package transfer_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrewhowdencom/x40.link/storage"
+	"github.com/andrewhowdencom/x40.link/storage/memory"
+	"github.com/andrewhowdencom/x40.link/storage/sqlite"
+	"github.com/andrewhowdencom/x40.link/storage/transfer"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	foo = &url.URL{Host: "x40", Path: "/foo"}
+	bar = &url.URL{Host: "x40", Path: "/bar"}
+)
+
+// export creates a storage with two links, owned by different agents, and exports it.
+func export(t *testing.T) *bytes.Buffer {
+	src := memory.NewHashTable()
+
+	assert.Nil(t, src.PutLink(context.Background(), &storage.Link{
+		From:        foo,
+		To:          &url.URL{Host: "k3s", Path: "/foo"},
+		Owner:       "alice",
+		Description: "The foo",
+		Tags:        []string{"foo"},
+		Status:      301,
+		Expires:     time.Now().Add(time.Hour).Truncate(time.Second),
+	}))
+	assert.Nil(t, src.PutLink(context.Background(), &storage.Link{
+		From:  bar,
+		To:    &url.URL{Host: "k3s", Path: "/bar"},
+		Owner: "bob",
+	}))
+
+	buf := &bytes.Buffer{}
+	n, err := transfer.Export(context.Background(), src, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	return buf
+}
+
+// destination creates the storage into which the links are imported. SQLite is used, as it enforces ownership.
+func destination(t *testing.T) *sqlite.SQLite {
+	dst, err := sqlite.New(filepath.Join(t.TempDir(), "links.db"))
+	assert.Nil(t, err)
+
+	t.Cleanup(func() { _ = dst.Close() })
+
+	return dst
+}
+
+func TestRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	dst := destination(t)
+
+	sum, err := transfer.Import(context.Background(), dst, export(t))
+	assert.Nil(t, err)
+	assert.Equal(t, &transfer.Summary{Read: 2, Created: 2, Failed: []error{}}, sum)
+
+	l, err := dst.GetLink(context.Background(), foo)
+	assert.Nil(t, err)
+	assert.Equal(t, &url.URL{Host: "k3s", Path: "/foo"}, l.To)
+	assert.Equal(t, "alice", l.Owner)
+	assert.Equal(t, "The foo", l.Description)
+	assert.Equal(t, []string{"foo"}, l.Tags)
+	assert.Equal(t, 301, l.Status)
+	assert.False(t, l.Expires.IsZero())
+
+	l, err = dst.GetLink(context.Background(), bar)
+	assert.Nil(t, err)
+	assert.Equal(t, "bob", l.Owner)
+}
+
+func TestImport(t *testing.T) {
+	t.Parallel()
+
+	elsewhere := &url.URL{Host: "elsewhere"}
+
+	for _, tc := range []struct {
+		name string
+		opts []transfer.Option
+
+		// agent owns the link that is already stored at foo.
+		agent string
+
+		sum *transfer.Summary
+		err error
+		to  *url.URL
+	}{
+		{
+			name:  "skips by default",
+			agent: "alice",
+			sum:   &transfer.Summary{Read: 2, Created: 1, Skipped: 1, Failed: []error{}},
+			to:    elsewhere,
+		},
+		{
+			name:  "overwrites",
+			opts:  []transfer.Option{transfer.WithPolicy(transfer.Overwrite)},
+			agent: "alice",
+			sum:   &transfer.Summary{Read: 2, Created: 1, Overwritten: 1, Failed: []error{}},
+			to:    &url.URL{Host: "k3s", Path: "/foo"},
+		},
+		{
+			name:  "fails",
+			opts:  []transfer.Option{transfer.WithPolicy(transfer.Fail)},
+			agent: "alice",
+			sum:   &transfer.Summary{Read: 2, Created: 1, Failed: []error{}},
+			err:   transfer.ErrConflict,
+			to:    elsewhere,
+		},
+		{
+			name:  "dry run",
+			opts:  []transfer.Option{transfer.WithPolicy(transfer.Overwrite), transfer.WithDryRun()},
+			agent: "alice",
+			sum:   &transfer.Summary{Read: 2, Created: 1, Overwritten: 1, Failed: []error{}},
+			to:    elsewhere,
+		},
+		{
+			name:  "owned by somebody else",
+			opts:  []transfer.Option{transfer.WithPolicy(transfer.Overwrite)},
+			agent: "mallory",
+			sum:   &transfer.Summary{Read: 2, Created: 1, Failed: []error{storage.ErrUnauthorized}},
+			to:    elsewhere,
+		},
+		{
+			name:  "dry run owned by somebody else",
+			opts:  []transfer.Option{transfer.WithPolicy(transfer.Overwrite), transfer.WithDryRun()},
+			agent: "mallory",
+			sum:   &transfer.Summary{Read: 2, Created: 1, Failed: []error{storage.ErrUnauthorized}},
+			to:    elsewhere,
+		},
+	} {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			dst := destination(t)
+			ctx := context.WithValue(context.Background(), storage.CtxKeyAgent, tc.agent)
+			assert.Nil(t, dst.PutLink(ctx, &storage.Link{From: foo, To: elsewhere}))
+
+			sum, err := transfer.Import(context.Background(), dst, export(t), tc.opts...)
+			assert.ErrorIs(t, err, tc.err)
+
+			// Failures are wrapped with the link to which they apply, so are compared by what they wrap.
+			assert.Len(t, sum.Failed, len(tc.sum.Failed))
+			for i := range sum.Failed {
+				assert.True(t, errors.Is(sum.Failed[i], tc.sum.Failed[i]))
+			}
+
+			sum.Failed, tc.sum.Failed = nil, nil
+			assert.Equal(t, tc.sum, sum)
+
+			to, err := dst.Get(context.Background(), foo)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.to, to)
+		})
+	}
+}
+
+func TestImportDryRun(t *testing.T) {
+	t.Parallel()
+
+	dst := destination(t)
+
+	sum, err := transfer.Import(context.Background(), dst, export(t), transfer.WithDryRun())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, sum.Created)
+
+	for _, u := range []*url.URL{foo, bar} {
+		_, err := dst.Get(context.Background(), u)
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+	}
+}
+
+func TestImportFoldedHosts(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name    string
+		opts    []transfer.Option
+		created int
+		failed  int
+	}{
+		{name: "other hosts", opts: []transfer.Option{transfer.WithFoldedHosts(storage.FoldedHosts{"k3s"})}, created: 2},
+		{name: "folded", opts: []transfer.Option{transfer.WithFoldedHosts(storage.FoldedHosts{"x40"})}, created: 1, failed: 1},
+		{
+			name:    "folded dry run",
+			opts:    []transfer.Option{transfer.WithFoldedHosts(storage.FoldedHosts{"x40"}), transfer.WithDryRun()},
+			created: 1,
+			failed:  1,
+		},
+	} {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			dst := destination(t)
+			assert.Nil(t, dst.Put(context.Background(), &url.URL{Host: "x40", Path: "/FOO"}, &url.URL{Host: "k3s"}))
+
+			sum, err := transfer.Import(context.Background(), dst, export(t), tc.opts...)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.created, sum.Created)
+
+			if assert.Len(t, sum.Failed, tc.failed) && tc.failed > 0 {
+				assert.ErrorIs(t, sum.Failed[0], storage.ErrCaseCollision)
+			}
+		})
+	}
+}
+
+func TestImportMalformed(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name string
+		in   string
+		read int
+	}{
+		{name: "not json", in: "I'm not json!\n"},
+		{name: "no host", in: `{"from":"/foo","to":"//k3s"}` + "\n"},
+		{name: "after a valid link", in: `{"from":"//x40/foo","to":"//k3s"}` + "\n\n" + `{"from":` + "\n", read: 1},
+	} {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			sum, err := transfer.Import(context.Background(), memory.NewHashTable(), bytes.NewBufferString(tc.in))
+			assert.ErrorIs(t, err, transfer.ErrMalformed)
+			assert.Equal(t, tc.read, sum.Read)
+		})
+	}
+}
+
+func TestImportLongLine(t *testing.T) {
+	t.Parallel()
+
+	// line is a link with a description of the given size, which makes up the bulk of the line.
+	line := func(size int) string {
+		return `{"from":"//x40/foo","to":"//k3s","description":"` + strings.Repeat("a", size) + `"}` + "\n"
+	}
+
+	for _, tc := range []struct {
+		name string
+		in   string
+		read int
+		err  error
+	}{
+		{name: "larger than the default buffer", in: line(bufio.MaxScanTokenSize * 2), read: 1},
+		{name: "larger than the maximum", in: line(transfer.MaxLineSize), err: transfer.ErrMalformed},
+	} {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			sum, err := transfer.Import(context.Background(), memory.NewHashTable(), bytes.NewBufferString(tc.in))
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.read, sum.Read)
+		})
+	}
+}
+
+func TestInvalidPolicy(t *testing.T) {
+	t.Parallel()
+
+	_, err := transfer.ParsePolicy("merge")
+	assert.ErrorIs(t, err, transfer.ErrInvalidOption)
+
+	_, err = transfer.Import(context.Background(), memory.NewHashTable(), &bytes.Buffer{}, transfer.WithPolicy("merge"))
+	assert.ErrorIs(t, err, transfer.ErrInvalidOption)
+}
@@ -7,4 +7,36 @@
 //
 // Where there is no scheme (assumed to be the default case), the "//" is required to clearly indicate this is a
 // schemeless URL.
+//
+// Each link can optionally be described with metadata:
+//
+//	---
+//	- from: //x40/foo
+//	  to: //x40/bar
+//	  description: The bar, by way of foo
+//	  tags: [bar, foo]
+//	  status: 301
+//	  expires: 2024-12-31T23:59:59Z
+//	  query: merge
+//
+// A from URL ending in "/*" also redirects every path beneath it, appending the rest of the path to the destination:
+//
+//	---
+//	- from: //x40/docs/*
+//	  to: https://docs.andrewhowden.com/*
+//
+// A from URL with a path segment in braces is a template, which matches any segment in its place and substitutes it
+// into the destination:
+//
+//	---
+//	- from: //x40/gh/{repo}
+//	  to: https://github.com/andrewhowdencom/{repo}
+//
+// The same links can also be written as JSON, TOML or CSV; see the catalogue package for the layout of each. Watch
+// derives the format from the file extension, unless it is set with WithFormat.
+//
+// Rows that are invalid (such as those with a from URL that has no host) are skipped, and logged with the line on which
+// they appear; with WithStrict, the file is instead rejected in its entirety.
+//
+// Where the storage is created with Watch, the file is reloaded whenever it changes, without needing to restart.
 package yaml
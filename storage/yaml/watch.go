@@ -0,0 +1,138 @@
+package yaml
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/andrewhowdencom/x40.link/storage"
+	"github.com/andrewhowdencom/x40.link/storage/catalogue"
+	"github.com/andrewhowdencom/x40.link/storage/memory"
+	"github.com/fsnotify/fsnotify"
+)
+
+// settle is how long to wait after the file last changed before reloading it. Editors (and tools that write the file
+// in several steps) tend to generate a flurry of events, of which only the last is interesting.
+const settle = 100 * time.Millisecond
+
+// Watch generates the storer from the file at the path, and then watches the file for changes until the context is
+// cancelled. Each time the file changes, it is read into a new in-memory storage which replaces the previous one in
+// its entirety.
+//
+// Where the file cannot be read or parsed on change, the previous state is kept and the error is logged.
+func Watch(ctx context.Context, path string, opts ...Option) (*yaml, error) {
+	y, err := Open(path, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", storage.ErrStorageSetupFailed, err)
+	}
+
+	// The directory is watched, rather than the file, as many editors replace the file rather than write to it.
+	// Watching the file itself would stop at the first replacement.
+	if err := w.Add(filepath.Dir(path)); err != nil {
+		_ = w.Close()
+		return nil, fmt.Errorf("%w: %s", storage.ErrStorageSetupFailed, err)
+	}
+
+	go y.watch(ctx, w, path)
+
+	return y, nil
+}
+
+// Open generates the storer from the file at the path, read once. Unlike Watch, it does not follow changes to the file,
+// so suits those that use the storage briefly (such as to export it) rather than serve from it.
+func Open(path string, opts ...Option) (*yaml, error) {
+	y := &yaml{}
+	for _, o := range opts {
+		o(y)
+	}
+
+	if y.format == "" {
+		f, err := catalogue.FormatFor(path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", storage.ErrStorageSetupFailed, err)
+		}
+
+		y.format = f
+	}
+
+	if _, err := y.reload(path); err != nil {
+		return nil, err
+	}
+
+	return y, nil
+}
+
+// watch reloads the file whenever the watcher reports it has changed, until the context is cancelled.
+func (y *yaml) watch(ctx context.Context, w *fsnotify.Watcher, path string) {
+	defer func() { _ = w.Close() }()
+
+	name := filepath.Clean(path)
+
+	// The timer is created stopped, and started by the first change to the file.
+	t := time.NewTimer(settle)
+	t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+
+			if filepath.Clean(ev.Name) != name || !ev.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+				continue
+			}
+
+			t.Reset(settle)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+
+			Log.Error("failed watching yaml storage", "file", path, "err", err)
+		case <-t.C:
+			n, err := y.reload(path)
+			if err != nil {
+				Log.Error("failed to reload yaml storage; keeping the previous links", "file", path, "err", err)
+				continue
+			}
+
+			Log.Info("reloaded yaml storage", "file", path, "links", n)
+		}
+	}
+}
+
+// reload reads the file into a new in-memory storage and, if that succeeds, swaps it in place of the current one.
+// Returns the number of links read.
+func (y *yaml) reload(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", storage.ErrStorageSetupFailed, err)
+	}
+
+	defer func() { _ = f.Close() }()
+
+	str := memory.NewHashTable()
+
+	n, err := y.load(str, f)
+	if err != nil {
+		return 0, err
+	}
+
+	y.mu.Lock()
+	defer y.mu.Unlock()
+
+	y.str = str
+
+	return n, nil
+}
@@ -0,0 +1,79 @@
+package yaml_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andrewhowdencom/x40.link/storage"
+	"github.com/andrewhowdencom/x40.link/storage/yaml"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatch(t *testing.T) {
+	t.Parallel()
+
+	ctx, cxl := context.WithCancel(context.Background())
+	defer cxl()
+
+	dir := t.TempDir()
+	p := filepath.Join(dir, "links.yaml")
+
+	// found indicates whether the storage currently holds a link at the path.
+	found := func(str storage.Storer, path string) bool {
+		_, err := str.Get(context.Background(), &url.URL{Host: "x40", Path: path})
+		return !errors.Is(err, storage.ErrNotFound)
+	}
+
+	assert.Nil(t, os.WriteFile(p, []byte("- from: //x40/a\n  to: //k3s/a\n"), 0600))
+
+	y, err := yaml.Watch(ctx, p)
+	assert.Nil(t, err)
+	assert.True(t, found(y, "/a"))
+
+	// Writing to the file replaces the links entirely.
+	assert.Nil(t, os.WriteFile(p, []byte("- from: //x40/b\n  to: //k3s/b\n"), 0600))
+	assert.Eventually(t, func() bool { return found(y, "/b") && !found(y, "/a") }, time.Second*5, time.Millisecond*10)
+
+	// A file that fails to parse leaves the previous links in place.
+	assert.Nil(t, os.WriteFile(p, []byte("- from: [\n"), 0600))
+	time.Sleep(time.Millisecond * 500)
+	assert.True(t, found(y, "/b"))
+
+	// Replacing the file, rather than writing to it, is also picked up.
+	tmp := filepath.Join(dir, "links.yaml.tmp")
+	assert.Nil(t, os.WriteFile(tmp, []byte("- from: //x40/c\n  to: //k3s/c\n"), 0600))
+	assert.Nil(t, os.Rename(tmp, p))
+	assert.Eventually(t, func() bool { return found(y, "/c") && !found(y, "/b") }, time.Second*5, time.Millisecond*10)
+}
+
+func TestWatchMissingFile(t *testing.T) {
+	t.Parallel()
+
+	_, err := yaml.Watch(context.Background(), filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.ErrorIs(t, err, storage.ErrStorageSetupFailed)
+}
+
+func TestOpen(t *testing.T) {
+	t.Parallel()
+
+	p := filepath.Join(t.TempDir(), "links.yaml")
+	assert.Nil(t, os.WriteFile(p, []byte("- from: //x40/a\n  to: //k3s/a\n"), 0600))
+
+	y, err := yaml.Open(p)
+	assert.Nil(t, err)
+
+	// Changes to the file are not followed.
+	assert.Nil(t, os.WriteFile(p, []byte("- from: //x40/b\n  to: //k3s/b\n"), 0600))
+	time.Sleep(time.Millisecond * 500)
+
+	_, err = y.Get(context.Background(), &url.URL{Host: "x40", Path: "/a"})
+	assert.Nil(t, err)
+
+	_, err = yaml.Open(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.ErrorIs(t, err, storage.ErrStorageSetupFailed)
+}
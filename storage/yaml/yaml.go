@@ -2,13 +2,15 @@ package yaml
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/url"
+	"sync"
 
 	"github.com/andrewhowdencom/x40.link/storage"
-	parser "gopkg.in/yaml.v3"
+	"github.com/andrewhowdencom/x40.link/storage/catalogue"
 )
 
 // Log is the logger for the library. Uses the default structured logger, but can be overridden to disable the output
@@ -17,63 +19,154 @@ var Log = slog.Default()
 
 // Yaml is a simple, read only implement of storage that fetches its initial state from a file and then returns
 // that state. It rejects any writes.
+//
+// Where created with Watch, the state is rebuilt whenever the file changes, and swapped in place of the previous state.
 type yaml struct {
+	mu  sync.RWMutex
 	str storage.Storer
+
+	// strict rejects the file where any row is invalid.
+	strict bool
+
+	// format is the format in which the file is written. See catalogue.Format
+	format catalogue.Format
+
+	// folded are the hosts on which no two links may differ only by the case of their paths. See storage.FoldedHosts
+	folded storage.FoldedHosts
+}
+
+// Option modifies how the YAML is loaded.
+type Option func(y *yaml)
+
+// WithStrict rejects the file in its entirety if any row is invalid (see Validate), rather than skipping the invalid
+// rows.
+func WithStrict() Option {
+	return func(y *yaml) {
+		y.strict = true
+	}
 }
 
-// row is the implementation of the file format in YAML.
-type row struct {
-	// From is the source URL that will be redirected.
-	From string `yaml:"from"`
+// WithFormat reads the file in another format (such as JSON or CSV), rather than YAML. Where the storage is created
+// with Watch, the format is otherwise derived from the extension of the file.
+func WithFormat(f catalogue.Format) Option {
+	return func(y *yaml) {
+		y.format = f
+	}
+}
 
-	// To is the destination url To which the source will be redirected.
-	To string `yaml:"to"`
+// WithFoldedHosts rejects links on the supplied hosts that differ from a link earlier in the file only by the case of
+// their path (skipping them, unless configured to be strict), as they could not be found.
+func WithFoldedHosts(hosts storage.FoldedHosts) Option {
+	return func(y *yaml) {
+		y.folded = hosts
+	}
 }
 
 // New generates the storer. It receives another storer which it will enrich with the content from the YAML,
 // and an io.reader which is expected to supply the YAML (typically a file).
 //
 // Returns an error in the case there is a failure to store the URL or to wholely fail the YAML parsing, but
-// ignores single line failures (simply skipping the record)
-func New(str storage.Storer, src io.Reader) (*yaml, error) {
-	y := &yaml{str: str}
-
-	// Read the content into a structure that we can convert it to URLs
-	rows := make([]row, 0)
-	dec := parser.NewDecoder(src)
-	err := dec.Decode(&rows)
+// ignores (logging and skipping) single invalid rows unless configured to be strict.
+func New(str storage.Storer, src io.Reader, opts ...Option) (*yaml, error) {
+	y := &yaml{str: str, format: catalogue.YAML}
+	for _, o := range opts {
+		o(y)
+	}
+
+	if _, err := y.load(str, src); err != nil {
+		return nil, err
+	}
+
+	return y, nil
+}
+
+// load enriches the storer with the content from the YAML, returning the number of links stored.
+func (y *yaml) load(str storage.Storer, src io.Reader) (int, error) {
+	links, problems, err := catalogue.Parse(src, y.format)
 	if err != nil {
-		return nil, fmt.Errorf("%w: %s", storage.ErrStorageSetupFailed, err)
+		return 0, err
+	}
+
+	if len(problems) > 0 && y.strict {
+		return 0, fmt.Errorf("%w: %w", storage.ErrStorageSetupFailed, &catalogue.ValidationError{Problems: problems})
+	}
+
+	for _, p := range problems {
+		Log.Warn("skipping invalid row", "line", p.Line, "err", p.Message)
 	}
 
 	// Fill up the storage with the links
-	for _, r := range rows {
-		from, err := url.Parse(r.From)
-		if err != nil {
-			Log.Debug("failed to parse from log", "url", r.From, "err", err)
+	n := 0
+	for _, l := range links {
+		err := storage.Create(context.Background(), str, y.folded, l)
+
+		switch {
+		case errors.Is(err, storage.ErrCaseCollision) && y.strict:
+			return 0, fmt.Errorf("%w: %s", storage.ErrStorageSetupFailed, err)
+		case errors.Is(err, storage.ErrCaseCollision):
+			Log.Warn("skipping link that differs from another only by case", "from", l.From.String())
 			continue
 		}
 
-		to, err := url.Parse(r.To)
 		if err != nil {
-			Log.Debug("failed to parse from log", "url", r.To, "err", err)
-			continue
+			return 0, fmt.Errorf("%w: %s", storage.ErrStorageSetupFailed, err)
 		}
 
-		fmt.Println(from.String(), to.String())
-
-		if err := y.str.Put(context.Background(), from, to); err != nil {
-			return nil, fmt.Errorf("%w: %s", storage.ErrStorageSetupFailed, err)
-		}
+		n++
 	}
 
-	return y, nil
+	return n, nil
 }
 
 func (y *yaml) Get(ctx context.Context, u *url.URL) (*url.URL, error) {
-	return y.str.Get(ctx, u)
+	return y.storer().Get(ctx, u)
+}
+
+func (y *yaml) GetLink(ctx context.Context, u *url.URL) (*storage.Link, error) {
+	return storage.GetLink(ctx, y.storer(), u)
+}
+
+func (y *yaml) GetFolded(ctx context.Context, u *url.URL) (*storage.Link, error) {
+	return storage.GetFolded(ctx, y.storer(), u)
+}
+
+func (y *yaml) Templates(ctx context.Context, host string) ([]*storage.Link, error) {
+	return storage.Templates(ctx, y.storer(), host)
 }
 
 func (y *yaml) Put(context.Context, *url.URL, *url.URL) error {
 	return storage.ErrReadOnlyStorage
 }
+
+func (y *yaml) PutLink(context.Context, *storage.Link) error {
+	return storage.ErrReadOnlyStorage
+}
+
+func (y *yaml) Create(context.Context, *storage.Link) error {
+	return storage.ErrReadOnlyStorage
+}
+
+func (y *yaml) CreateFolded(context.Context, *storage.Link) error {
+	return storage.ErrReadOnlyStorage
+}
+
+func (y *yaml) Delete(context.Context, *url.URL) error {
+	return storage.ErrReadOnlyStorage
+}
+
+func (y *yaml) List(ctx context.Context, opts storage.ListOptions) (*storage.Page, error) {
+	l, ok := y.storer().(storage.Lister)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", storage.ErrFailed, "storage does not list")
+	}
+
+	return l.List(ctx, opts)
+}
+
+// storer returns the storage holding the current state of the file.
+func (y *yaml) storer() storage.Storer {
+	y.mu.RLock()
+	defer y.mu.RUnlock()
+
+	return y.str
+}
@@ -10,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/andrewhowdencom/x40.link/storage"
+	"github.com/andrewhowdencom/x40.link/storage/catalogue"
 	"github.com/andrewhowdencom/x40.link/storage/memory"
 	"github.com/andrewhowdencom/x40.link/storage/test"
 	"github.com/andrewhowdencom/x40.link/storage/yaml"
@@ -117,6 +118,31 @@ func TestYamlRejectWrite(t *testing.T) {
 	)
 
 	assert.ErrorIs(t, err, storage.ErrReadOnlyStorage)
+
+	err = y.Create(context.Background(), &storage.Link{
+		From: &url.URL{Host: "x40", Path: "/foo"},
+		To:   &url.URL{Host: "k3s", Path: "/bar"},
+	})
+
+	assert.ErrorIs(t, err, storage.ErrReadOnlyStorage)
+}
+
+func TestYamlRejectDelete(t *testing.T) {
+	t.Parallel()
+	y, err := yaml.New(memory.NewHashTable(), bytes.NewBufferString(`
+---
+- from: //x40/foo
+  to: //k3s/bar
+`))
+
+	assert.Nil(t, err)
+
+	err = y.Delete(context.Background(), &url.URL{Host: "x40", Path: "/foo"})
+	assert.ErrorIs(t, err, storage.ErrReadOnlyStorage)
+
+	// The record is still there.
+	_, err = y.Get(context.Background(), &url.URL{Host: "x40", Path: "/foo"})
+	assert.Nil(t, err)
 }
 
 func TestLoggerOverride(t *testing.T) {
@@ -139,3 +165,102 @@ func TestLoggerOverride(t *testing.T) {
 	// Validate the output
 	assert.Contains(t, b.String(), "invalid control character")
 }
+
+func TestSkipInvalid(t *testing.T) {
+	exist := yaml.Log
+	defer func() { yaml.Log = exist }()
+
+	b := &bytes.Buffer{}
+	yaml.Log = slog.New(slog.NewTextHandler(b, nil))
+
+	y, err := yaml.New(memory.NewHashTable(), bytes.NewBufferString(`---
+- from: //x40/foo
+  to: //k3s/bar
+- from: /no-host
+  to: //k3s/bar
+`))
+	assert.Nil(t, err)
+
+	// The valid row is stored, and the invalid one is logged (with where it is) rather than stored.
+	_, err = y.Get(context.Background(), &url.URL{Host: "x40", Path: "/foo"})
+	assert.Nil(t, err)
+
+	_, err = y.Get(context.Background(), &url.URL{Path: "/no-host"})
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	assert.Contains(t, b.String(), "skipping invalid row")
+	assert.Contains(t, b.String(), "line=4")
+	assert.Contains(t, b.String(), `no host`)
+}
+
+func TestStrict(t *testing.T) {
+	t.Parallel()
+
+	in := `---
+- from: //x40/foo
+  to: //k3s/bar
+- from: /no-host
+  to: //k3s/bar
+`
+
+	// By default, the invalid row is skipped.
+	_, err := yaml.New(memory.NewHashTable(), bytes.NewBufferString(in))
+	assert.Nil(t, err)
+
+	// In strict mode, the whole file is rejected.
+	_, err = yaml.New(memory.NewHashTable(), bytes.NewBufferString(in), yaml.WithStrict())
+	assert.ErrorIs(t, err, storage.ErrStorageSetupFailed)
+	assert.ErrorIs(t, err, catalogue.ErrInvalid)
+	assert.ErrorContains(t, err, "line 4: from \"/no-host\" has no host")
+}
+
+func TestFoldedHosts(t *testing.T) {
+	t.Parallel()
+
+	in := `---
+- from: //x40/foo
+  to: //k3s/bar
+- from: //x40/FOO
+  to: //k3s/baz
+`
+
+	// By default, the link that differs only by case is skipped.
+	y, err := yaml.New(memory.NewHashTable(), bytes.NewBufferString(in), yaml.WithFoldedHosts(storage.FoldedHosts{"x40"}))
+	assert.Nil(t, err)
+
+	to, err := y.Get(context.Background(), &url.URL{Host: "x40", Path: "/foo"})
+	assert.Nil(t, err)
+	assert.Equal(t, &url.URL{Host: "k3s", Path: "/bar"}, to)
+
+	_, err = y.Get(context.Background(), &url.URL{Host: "x40", Path: "/FOO"})
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	// In strict mode, the whole file is rejected.
+	_, err = yaml.New(
+		memory.NewHashTable(),
+		bytes.NewBufferString(in),
+		yaml.WithFoldedHosts(storage.FoldedHosts{"x40"}),
+		yaml.WithStrict(),
+	)
+	assert.ErrorIs(t, err, storage.ErrStorageSetupFailed)
+	assert.ErrorContains(t, err, "in a different case")
+
+	// On other hosts, links that differ only by case are both kept.
+	y, err = yaml.New(memory.NewHashTable(), bytes.NewBufferString(in), yaml.WithFoldedHosts(storage.FoldedHosts{"k3s"}))
+	assert.Nil(t, err)
+
+	to, err = y.Get(context.Background(), &url.URL{Host: "x40", Path: "/FOO"})
+	assert.Nil(t, err)
+	assert.Equal(t, &url.URL{Host: "k3s", Path: "/baz"}, to)
+}
+
+func TestFormat(t *testing.T) {
+	t.Parallel()
+
+	y, err := yaml.New(memory.NewHashTable(), bytes.NewBufferString("from,to\n//x40/foo,//k3s/bar\n"), yaml.WithFormat(catalogue.CSV))
+	assert.Nil(t, err)
+
+	to, err := y.Get(context.Background(), &url.URL{Host: "x40", Path: "/foo"})
+	assert.Nil(t, err)
+	assert.Equal(t, &url.URL{Host: "k3s", Path: "/bar"}, to)
+}